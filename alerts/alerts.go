@@ -0,0 +1,120 @@
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"nucleus/config"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// TypeQuotaThreshold is raised when an organization's usage of a feature crosses a percentage of its limit
+	TypeQuotaThreshold = "quota.threshold_reached"
+	// TypeSpendThreshold is raised when an organization's estimated metered spend passes ALERT_SPEND_THRESHOLD
+	TypeSpendThreshold = "spend.threshold_reached"
+	// TypeSpendCapReached is raised when an organization's estimated metered spend reaches its spend cap
+	TypeSpendCapReached = "spend_cap.reached"
+	// TypeDuplicateSubscriptions is raised when an organization holds more than one subscription granting a base plan
+	TypeDuplicateSubscriptions = "billing.duplicate_subscriptions"
+)
+
+// pendingRedeliveryWindow is how old an undelivered alert may be to be redelivered
+const pendingRedeliveryWindow = 24 * time.Hour
+
+// pendingRedeliveryDelay is how long an alert stays pending before it's redelivered,
+// long enough for the delivery started when it was raised to finish with all its retries
+const pendingRedeliveryDelay = 5 * time.Minute
+
+// quotaThresholds are the percentages of a limit that raise an alert, ascending
+var quotaThresholds []int64
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	quotaThresholds = []int64{80, 100}
+	if values := config.List("ALERT_THRESHOLDS"); len(values) > 0 {
+		quotaThresholds = nil
+		for _, value := range values {
+			percent, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || percent <= 0 {
+				log.Fatalf("Invalid ALERT_THRESHOLDS value %q", value)
+			}
+			quotaThresholds = append(quotaThresholds, percent)
+		}
+		sort.Slice(quotaThresholds, func(i, j int) bool { return quotaThresholds[i] < quotaThresholds[j] })
+	}
+
+	if err := mongodb.EnsureAlertIndexes(); err != nil {
+		log.Fatalf("Error creating alert indexes: %v", err)
+	}
+}
+
+// Raise stores the alert and delivers it in the background, unless the same alert was already raised
+// The dedup key defaults to the type, organization, subject, threshold and period of the alert
+func Raise(alert mongodbTypes.Alert) {
+	if alert.DedupKey == "" {
+		alert.DedupKey = fmt.Sprintf("%s:%s:%s:%d:%d", alert.Type, alert.OrganizationID, alert.Subject, alert.Threshold, alert.PeriodEnd.Unix())
+	}
+	alert.CreatedAt = time.Now()
+	alert.Status = mongodbTypes.AlertPending
+	alert.Deliveries = []mongodbTypes.AlertDelivery{}
+
+	stored, created, err := mongodb.CreateAlert(alert)
+	if err != nil {
+		log.Printf("Error storing alert %s: %v", alert.DedupKey, err)
+		return
+	}
+	if !created {
+		return
+	}
+
+	go deliver(stored)
+}
+
+// CheckQuota raises an alert for every threshold the usage crossed going from before to after
+func CheckQuota(organizationID string, feature string, before int64, after int64, limit int64, periodEnd time.Time) {
+	if limit <= 0 {
+		return
+	}
+
+	for _, percent := range quotaThresholds {
+		// Compare without dividing so small limits don't round thresholds away
+		reached := func(used int64) bool { return used*100 >= limit*percent }
+		if reached(before) || !reached(after) {
+			continue
+		}
+
+		Raise(mongodbTypes.Alert{
+			Type:           TypeQuotaThreshold,
+			OrganizationID: organizationID,
+			Subject:        feature,
+			Threshold:      percent,
+			Value:          after,
+			Limit:          limit,
+			PeriodEnd:      periodEnd,
+		})
+	}
+}
+
+// RedeliverPending sends the alerts that were stored but never delivered, e.g. because nucleus restarted in between
+// It runs on the usage flusher's ticker, and delivers synchronously so runs never overlap
+func RedeliverPending() {
+	now := time.Now()
+	pending, err := mongodb.ListPendingAlerts(now.Add(-pendingRedeliveryWindow), now.Add(-pendingRedeliveryDelay))
+	if err != nil {
+		log.Printf("Error listing pending alerts: %v", err)
+		return
+	}
+
+	for _, alert := range pending {
+		deliver(alert)
+	}
+}
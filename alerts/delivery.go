@@ -0,0 +1,174 @@
+package alerts
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"nucleus/clerk"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the HMAC signature of webhook alerts
+	SignatureHeader = "Nucleus-Signature"
+
+	channelWebhook = "webhook"
+	channelEmail   = "email"
+
+	// deliveryAttempts is how many times a channel is tried before the delivery is recorded as failed
+	deliveryAttempts = 3
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// deliver sends the alert to every configured channel and records the outcome
+func deliver(alert mongodbTypes.Alert) {
+	var deliveries []mongodbTypes.AlertDelivery
+	status := mongodbTypes.AlertDelivered
+
+	channels := map[string]func(mongodbTypes.Alert) error{}
+	if os.Getenv("ALERT_WEBHOOK_URL") != "" {
+		channels[channelWebhook] = sendWebhook
+	}
+	if os.Getenv("SMTP_HOST") != "" {
+		channels[channelEmail] = sendEmail
+	}
+
+	for channel, send := range channels {
+		var err error
+		for attempt := 0; attempt < deliveryAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * 2 * time.Second)
+			}
+			if err = send(alert); err == nil {
+				break
+			}
+		}
+
+		delivery := mongodbTypes.AlertDelivery{Channel: channel, At: time.Now()}
+		if err != nil {
+			log.Printf("Error delivering alert %s by %s: %v", alert.ID.Hex(), channel, err)
+			delivery.Error = err.Error()
+			status = mongodbTypes.AlertFailed
+		} else {
+			log.Printf("[ALERTS] Delivered alert %s (%s) for organization %s by %s", alert.ID.Hex(), alert.Type, alert.OrganizationID, channel)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := mongodb.CompleteAlertDelivery(alert.ID, status, deliveries); err != nil {
+		log.Printf("Error recording delivery of alert %s: %v", alert.ID.Hex(), err)
+	}
+}
+
+// sendWebhook posts the alert as JSON to ALERT_WEBHOOK_URL, signed with ALERT_WEBHOOK_SECRET
+func sendWebhook(alert mongodbTypes.Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, os.Getenv("ALERT_WEBHOOK_URL"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, Sign(os.Getenv("ALERT_WEBHOOK_SECRET"), time.Now(), body))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header of a webhook body: t=<unix timestamp>,v1=<hex HMAC-SHA256 of "timestamp.body">
+// Receivers recompute the HMAC with the shared secret and reject old timestamps to prevent replays
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendEmail emails the alert to the organization's admins, or the user billed personally, through SMTP_HOST
+func sendEmail(alert mongodbTypes.Alert) error {
+	recipients, err := clerk.GetOwnerAdminEmails(alert.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	host := os.Getenv("SMTP_HOST")
+	from := os.Getenv("SMTP_FROM")
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	subject, text := emailContent(alert)
+	message := "From: " + from + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + text + "\r\n"
+
+	return smtp.SendMail(host+":"+port, auth, from, recipients, []byte(message))
+}
+
+// emailContent returns the subject and plain text body of an alert email
+func emailContent(alert mongodbTypes.Alert) (string, string) {
+	switch alert.Type {
+	case TypeQuotaThreshold:
+		return fmt.Sprintf("You've used %d%% of your %s quota", alert.Threshold, alert.Subject),
+			fmt.Sprintf("Your organization has used %d of its %d %s this billing period, which ends on %s.",
+				alert.Value, alert.Limit, alert.Subject, alert.PeriodEnd.Format("January 2, 2006"))
+	case TypeSpendThreshold:
+		return "Your usage spend passed " + formatAmount(alert.Threshold, alert.Currency),
+			fmt.Sprintf("Your organization's estimated usage spend this month is %s, past the alert threshold of %s.",
+				formatAmount(alert.Value, alert.Currency), formatAmount(alert.Threshold, alert.Currency))
+	case TypeSpendCapReached:
+		return "Your usage spend reached its cap of " + formatAmount(alert.Threshold, alert.Currency),
+			fmt.Sprintf("Your organization's estimated usage spend this month is %s, which reached its spend cap of %s. "+
+				"Further usage is blocked until the cap is raised or the month ends.",
+				formatAmount(alert.Value, alert.Currency), formatAmount(alert.Threshold, alert.Currency))
+	case TypeDuplicateSubscriptions:
+		return "Your organization may be billed twice",
+			fmt.Sprintf("Your organization has several active subscriptions granting a plan (%s). "+
+				"If you changed plans recently, the old subscription may not have been canceled.", alert.Subject)
+	default:
+		return "Billing alert: " + alert.Type,
+			fmt.Sprintf("Your organization raised a %s alert (value %d, threshold %d).", alert.Type, alert.Value, alert.Threshold)
+	}
+}
+
+// formatAmount formats an amount in the currency's smallest unit, assuming two decimals
+func formatAmount(amount int64, currency string) string {
+	return fmt.Sprintf("%d.%02d %s", amount/100, amount%100, strings.ToUpper(currency))
+}
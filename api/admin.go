@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// serviceKeyResponse is returned when a service API key is created or rotated, it's the only time the plaintext key is shown
+type serviceKeyResponse struct {
+	Key        string                     `json:"key"`
+	ServiceKey mongodbTypes.ServiceAPIKey `json:"service_key"`
+}
+
+// ServiceKeysHandler is a handler that lists (GET) or creates (POST) service API keys
+func ServiceKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET", "POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		keys, err := mongodb.ListServiceAPIKeys()
+		if err != nil {
+			log.Printf("Error listing service API keys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(keys)
+		return
+	}
+
+	var request struct {
+		Name           string   `json:"name"`
+		Scopes         []string `json:"scopes"`
+		OrganizationID string   `json:"organization_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || len(request.Scopes) == 0 {
+		http.Error(w, "name and scopes are required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(auth.ServiceScopes, scope) {
+			http.Error(w, "unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, prefix, hash, err := auth.GenerateSecret(auth.ServiceKeyPrefix)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := mongodb.CreateServiceAPIKey(mongodbTypes.ServiceAPIKey{
+		Name:           request.Name,
+		Prefix:         prefix,
+		KeyHash:        hash,
+		Scopes:         request.Scopes,
+		OrganizationID: request.OrganizationID,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error creating service API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(serviceKeyResponse{Key: secret, ServiceKey: key})
+}
+
+// RotateServiceKeyHandler is a handler that replaces a service API key with a new secret
+func RotateServiceKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	secret, prefix, hash, err := auth.GenerateSecret(auth.ServiceKeyPrefix)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := mongodb.RotateServiceAPIKey(id, prefix, hash)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating service API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(serviceKeyResponse{Key: secret, ServiceKey: key})
+}
+
+// RevokeServiceKeyHandler is a handler that revokes a service API key
+func RevokeServiceKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	err = mongodb.RevokeServiceAPIKey(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking service API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/mongodb"
+	"nucleus/spend"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// defaultBillingHistoryLimit is how many billing events are returned when no limit is given
+	defaultBillingHistoryLimit = 50
+	// maxBillingHistoryLimit is the maximum number of billing events returned at once
+	maxBillingHistoryLimit = 200
+)
+
+// SpendCapHandler is a handler that returns (GET) or sets (PUT) the spend cap of the active organization,
+// or of the user when billed personally, together with the spend estimated for the current billing period
+func SpendCapHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET", "PUT"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, err := getBillingOwner(r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting billing owner: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ownerID := owner.OwnerID()
+
+	if r.Method == http.MethodPut {
+		var request struct {
+			Amount *int64 `json:"amount"` // null removes the cap
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if request.Amount != nil && *request.Amount <= 0 {
+			http.Error(w, "amount must be positive", http.StatusBadRequest)
+			return
+		}
+
+		// Access tokens have no user, they're identified by their prefix instead
+		actor, ok := auth.GetUserID(r)
+		if token, isToken := auth.GetAccessToken(r); !ok && isToken {
+			actor = token.Prefix
+		}
+
+		_, err := spend.SetCap(ownerID, request.Amount, actor)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error setting spend cap: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	spendCap, err := spend.GetCap(ownerID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting spend cap: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	estimate, err := spend.EstimateCurrentPeriod(ownerID)
+	if err != nil {
+		log.Printf("Error estimating spend: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	blocked, err := spend.Blocked(ownerID)
+	if err != nil {
+		log.Printf("Error getting spend cap state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"spend_cap": spendCap,
+		"estimate":  estimate,
+		"blocked":   blocked,
+	})
+}
+
+// GetBillingHistoryHandler is a handler that returns the most recent billing events of the active organization,
+// or of the user when billed personally
+func GetBillingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, err := getBillingOwner(r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting billing owner: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	limit := int64(defaultBillingHistoryLimit)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxBillingHistoryLimit {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := mongodb.ListBillingEvents(owner.OwnerID(), limit)
+	if err != nil {
+		log.Printf("Error listing billing history: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
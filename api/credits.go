@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/credits"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultCreditTransactionsLimit is how many credit transactions are returned when no limit is given
+	defaultCreditTransactionsLimit = 50
+	// maxCreditTransactionsLimit is the maximum number of credit transactions returned at once
+	maxCreditTransactionsLimit = 200
+)
+
+// DebitCreditsHandler is a handler that idempotently debits prepaid credits from an organization's wallet
+// It answers 200 with the transaction, 402 when the wallet doesn't hold enough credits
+// and 409 when the idempotency key was already used for a different amount
+func DebitCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		OrganizationID string `json:"organization_id"`
+		Amount         int64  `json:"amount"`
+		IdempotencyKey string `json:"idempotency_key"`
+		Description    string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.Amount <= 0 || request.IdempotencyKey == "" {
+		http.Error(w, "a positive amount and an idempotency_key are required", http.StatusBadRequest)
+		return
+	}
+
+	organizationID, ok := getTargetOrganization(r, request.OrganizationID)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	transaction, err := credits.Debit(organizationID, request.IdempotencyKey, request.Amount, request.Description)
+	if errors.Is(err, credits.ErrInsufficientCredits) {
+		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, credits.ErrConflict) {
+		http.Error(w, "idempotency_key was already used for a different transaction", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error debiting credits: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(transaction)
+}
+
+// GetCreditsHandler is a handler that returns the credit balance, active grants and most recent transactions of the active organization
+// Credits are only granted to organizations, so users billed personally get 404
+func GetCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID, ok := auth.GetOrganizationID(r)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	limit := int64(defaultCreditTransactionsLimit)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxCreditTransactionsLimit {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	wallet, err := credits.GetWallet(organizationID)
+	if err != nil {
+		log.Printf("Error getting credit wallet: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := credits.ListTransactions(organizationID, limit)
+	if err != nil {
+		log.Printf("Error listing credit transactions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balance":      wallet.Balance,
+		"available":    credits.Available(wallet, time.Now()),
+		"grants":       wallet.Grants,
+		"transactions": transactions,
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/clerk"
+	"nucleus/entitlements"
+	"nucleus/quota"
+	entitlementsTypes "nucleus/types/entitlements"
+	"time"
+)
+
+// entitlementsResponse lists every feature the caller is entitled to
+type entitlementsResponse struct {
+	CatalogVersion int      `json:"catalog_version"`
+	Plans          []string `json:"plans"`
+	Addons         []string `json:"addons"`
+	EffectivePlan  string   `json:"effective_plan,omitempty"`
+	// Set while a subscription only grants access through its grace period, so the UI can warn before access is lost
+	GraceEndsAt           *int64                                    `json:"grace_ends_at,omitempty"`
+	GraceRemainingSeconds *int64                                    `json:"grace_remaining_seconds,omitempty"`
+	Features              map[string]entitlementsTypes.FeatureCheck `json:"features"`
+}
+
+// GetUserEntitlementsHandler is a handler that returns every feature of the caller's organization (or personal billing)
+// together with its limit, current usage and the subscriptions granting it
+func GetUserEntitlementsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, ok := getCallerEntitlements(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response := entitlementsResponse{
+		CatalogVersion: current.CatalogVersion,
+		Plans:          current.Plans,
+		Addons:         current.Addons,
+		EffectivePlan:  current.EffectivePlan,
+		Features:       map[string]entitlementsTypes.FeatureCheck{},
+	}
+	if response.Addons == nil {
+		response.Addons = []string{}
+	}
+	if current.GraceEndsAt != nil {
+		remaining := max(*current.GraceEndsAt-time.Now().Unix(), 0)
+		response.GraceEndsAt = current.GraceEndsAt
+		response.GraceRemainingSeconds = &remaining
+	}
+	for name := range current.Features {
+		response.Features[name] = checkFeature(r, current, name)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUserEntitlementHandler is a handler that returns whether the caller's organization (or personal billing) has a single feature
+// Unknown features are reported as disabled so frontends can gate UI with a single call
+func GetUserEntitlementHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, ok := getCallerEntitlements(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(checkFeature(r, current, r.PathValue("feature")))
+}
+
+// getCallerEntitlements computes the entitlements of the caller's organization, falling back to personal billing
+func getCallerEntitlements(r *http.Request) (entitlementsTypes.Entitlements, bool) {
+	if organizationID, ok := auth.GetOrganizationID(r); ok {
+		return clerk.GetEntitlementsByOrganizationID(organizationID), true
+	}
+
+	if userID, ok := auth.GetUserID(r); ok {
+		return clerk.GetEntitlementsByUserID(userID), true
+	}
+
+	return entitlementsTypes.Entitlements{}, false
+}
+
+// checkFeature builds the check result of a feature, including its current usage when it's limited
+func checkFeature(r *http.Request, current entitlementsTypes.Entitlements, name string) entitlementsTypes.FeatureCheck {
+	feature, ok := current.Features[name]
+	check := entitlementsTypes.FeatureCheck{
+		Feature:   name,
+		Enabled:   ok && feature.Enabled,
+		Limit:     feature.Limit,
+		SoftLimit: feature.SoftLimit,
+		GrantedBy: feature.GrantedBy,
+	}
+	if check.GrantedBy == nil {
+		check.GrantedBy = []string{}
+	}
+
+	if check.Enabled && (check.Limit != nil || check.SoftLimit != nil) {
+		if usage, ok := currentUsage(r, name); ok {
+			check.Usage = &usage
+		}
+	}
+
+	return check
+}
+
+// currentUsage returns how much of a limited feature the caller's organization, or personal billing, currently uses,
+// if nucleus can measure it. Seats are the organization's members count, other features are read from the quota counter of the current period
+func currentUsage(r *http.Request, feature string) (int64, bool) {
+	ownerID, ok := auth.GetOrganizationID(r)
+	isOrganization := ok
+	if !ok {
+		ownerID, ok = auth.GetUserID(r)
+	}
+	if !ok {
+		return 0, false
+	}
+
+	switch feature {
+	case entitlements.FeatureSeats:
+		if !isOrganization {
+			return 0, false
+		}
+		count, err := clerk.GetOrganizationMembersCount(ownerID)
+		if err != nil {
+			log.Printf("Error getting organization members count: %v", err)
+			return 0, false
+		}
+		return count, true
+	default:
+		used, err := quota.CurrentUsage(ownerID, feature)
+		if err != nil {
+			log.Printf("Error getting quota usage: %v", err)
+			return 0, false
+		}
+		return used, true
+	}
+}
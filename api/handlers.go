@@ -5,10 +5,11 @@ import (
 	"net/http"
 	"nucleus/auth"
 	"nucleus/clerk"
-	"nucleus/mongodb"
+	clerkTypes "nucleus/types/clerk"
 )
 
 // GetUserSuscriptionsHandler is a handler that returns the user's subscriptions
+// Users without an active organization get their personal subscriptions
 func GetUserSuscriptionsHandler(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"GET"}) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -16,39 +17,96 @@ func GetUserSuscriptionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the organization ID from the user's organization memberships
-	organizationID, ok := auth.GetOrganizationID(r)
+	if organizationID, ok := auth.GetOrganizationID(r); ok {
+		// Get the active subscriptions for the organization
+		subscriptions := clerk.GetActiveSubscriptionsByOrganizationID(organizationID)
+
+		// Return the subscriptions
+		json.NewEncoder(w).Encode(subscriptions)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Get the active subscriptions for the organization
-	subscriptions := clerk.GetActiveSubscriptionsByOrganizationID(organizationID)
+	// Get the personal subscriptions of the user
+	subscriptions := clerk.GetActiveSubscriptionsByUserID(userID)
 
-	// Return the subscriptions
 	json.NewEncoder(w).Encode(subscriptions)
 }
 
+// GetUserStripeCustomerIDHandler is a handler that returns the Stripe customer ID of the user's organization
+// Users without an active organization get the ID of their personal customer
 func GetUserStripeCustomerIDHandler(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"GET"}) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get the organization ID from the user's organization memberships
-	organizationID, ok := auth.GetOrganizationID(r)
+	// Get the billing mapping from the database using the organization or user ID
+	organization, err := getBillingOwner(r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the stripe customer ID
+	json.NewEncoder(w).Encode(organization.StripeCustomerID)
+}
+
+// GetUserOrganizationsHandler is a handler that returns every organization the user belongs to along with its current plan
+func GetUserOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Get the organization object from the database using the clerkID
-	organization, err := mongodb.GetOrganizationByClerkID(organizationID)
+	memberships, err := clerk.GetUserOrganizations(userID)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Return the stripe customer ID
-	json.NewEncoder(w).Encode(organization.StripeCustomerID)
+	activeOrganizationID, _ := auth.GetOrganizationID(r)
+
+	organizations := []clerkTypes.OrganizationSummary{}
+	for _, membership := range memberships.OrganizationMemberships {
+		if membership.Organization == nil {
+			continue
+		}
+
+		// The membership already embeds the organization's public metadata, so no extra lookup is needed
+		organizations = append(organizations, clerkTypes.OrganizationSummary{
+			ID:            membership.Organization.ID,
+			Name:          membership.Organization.Name,
+			Slug:          membership.Organization.Slug,
+			Role:          membership.Role,
+			Active:        membership.Organization.ID == activeOrganizationID,
+			Subscriptions: clerk.GetActiveSubscriptionsFromRawMetadata(membership.Organization.PublicMetadata),
+			EffectivePlan: clerk.GetEffectivePlanFromRawMetadata(membership.Organization.PublicMetadata),
+		})
+	}
+
+	json.NewEncoder(w).Encode(organizations)
+}
+
+// GetMetricsHandler is a handler that returns the service's internal metrics
+func GetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"membership_cache": clerk.GetMembershipCacheStats(),
+	})
 }
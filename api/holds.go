@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/holds"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ListHoldsHandler is a handler that lists the access holds placed on an organization after disputes, refunds and fraud warnings
+func ListHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	accessHolds, err := holds.List(r.PathValue("clerkOrgId"))
+	if err != nil {
+		log.Printf("Error listing access holds: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(accessHolds)
+}
+
+// ReleaseHoldHandler is a handler that releases an active access hold of an organization and restores its access
+// The author is passed in the released_by query parameter and an optional explanation in reason
+func ReleaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.PathValue("clerkOrgId")
+
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	releasedBy := r.URL.Query().Get("released_by")
+	if releasedBy == "" {
+		http.Error(w, "released_by is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err = holds.ReleaseByID(organizationID, id, releasedBy, r.URL.Query().Get("reason"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error releasing access hold: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/clerk"
+)
+
+// GetInternalOrganizationSubscriptionsHandler is a handler that returns the active subscriptions of any organization to internal backends
+func GetInternalOrganizationSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.PathValue("clerkOrgId")
+	if !auth.ServiceKeyAllowsOrganization(r, organizationID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	subscriptions := clerk.GetActiveSubscriptionsByOrganizationID(organizationID)
+
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+// GetInternalOrganizationEntitlementsHandler is a handler that returns the entitlements of any organization to internal backends
+func GetInternalOrganizationEntitlementsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.PathValue("clerkOrgId")
+	if !auth.ServiceKeyAllowsOrganization(r, organizationID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	entitlements := clerk.GetEntitlementsByOrganizationID(organizationID)
+
+	json.NewEncoder(w).Encode(entitlements)
+}
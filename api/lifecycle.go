@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/clerk"
+	"nucleus/lifecycle"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+)
+
+// billingStateResponse is a billing lifecycle with whether the owner's subscriptions grant access and how much grace is left
+type billingStateResponse struct {
+	mongodbTypes.BillingLifecycle
+	Entitled              bool   `json:"entitled"`
+	GraceRemainingSeconds *int64 `json:"grace_remaining_seconds,omitempty"`
+}
+
+// GetUserBillingStateHandler is a handler that returns the billing state of the caller's organization (or personal billing)
+// together with its recent transitions
+func GetUserBillingStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if organizationID, ok := auth.GetOrganizationID(r); ok {
+		writeBillingState(w, organizationID, clerk.GetActiveSubscriptionsByOrganizationID(organizationID))
+		return
+	}
+
+	if userID, ok := auth.GetUserID(r); ok {
+		writeBillingState(w, userID, clerk.GetActiveSubscriptionsByUserID(userID))
+		return
+	}
+
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// GetBillingStateHandler is a handler that returns the billing state of any organization and its recent transitions to admins
+func GetBillingStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.PathValue("clerkOrgId")
+	writeBillingState(w, organizationID, clerk.GetActiveSubscriptionsByOrganizationID(organizationID))
+}
+
+// writeBillingState writes the billing lifecycle of the owner, entitled when its state keeps access and any of its subscriptions grants it
+// Owners that never subscribed, with purchases only, are entitled by their subscriptions alone
+func writeBillingState(w http.ResponseWriter, ownerID string, activeSubscriptions []map[string]interface{}) {
+	current, err := lifecycle.Get(ownerID)
+	if err != nil {
+		log.Printf("Error getting billing state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	entitled := len(activeSubscriptions) > 0 && (current.State == mongodbTypes.BillingStateNone || current.State.Entitled())
+	response := billingStateResponse{BillingLifecycle: current, Entitled: entitled}
+	if current.State == mongodbTypes.BillingStateGrace && current.GraceEndsAt != nil {
+		remaining := max(int64(time.Until(*current.GraceEndsAt).Seconds()), 0)
+		response.GraceRemainingSeconds = &remaining
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
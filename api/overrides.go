@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// OverridesHandler is a handler that lists (GET) or creates (POST) entitlement overrides and comped plans of an organization
+// or of a user billed personally
+func OverridesHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET", "POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := overrideOwner(r)
+
+	if r.Method == http.MethodGet {
+		overrides, err := mongodb.ListEntitlementOverrides(owner)
+		if err != nil {
+			log.Printf("Error listing entitlement overrides: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(overrides)
+		return
+	}
+
+	var request struct {
+		Kind      mongodbTypes.OverrideKind `json:"kind"`
+		Feature   string                    `json:"feature"`
+		Enabled   *bool                     `json:"enabled"`
+		Limit     *int64                    `json:"limit"`
+		PlanID    string                    `json:"plan_id"`
+		Reason    string                    `json:"reason"`
+		CreatedBy string                    `json:"created_by"`
+		ExpiresAt time.Time                 `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.Reason == "" || request.CreatedBy == "" {
+		http.Error(w, "reason and created_by are required", http.StatusBadRequest)
+		return
+	}
+	if !request.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	override := mongodbTypes.EntitlementOverride{
+		Kind:      request.Kind,
+		Enabled:   request.Enabled == nil || *request.Enabled,
+		Reason:    request.Reason,
+		CreatedBy: request.CreatedBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: request.ExpiresAt,
+	}
+	if owner.IsUser() {
+		override.UserID = owner.ClerkUserID
+	} else {
+		override.OrganizationID = owner.ClerkID
+	}
+
+	switch request.Kind {
+	case mongodbTypes.OverrideKindFeature:
+		if request.Feature == "" {
+			http.Error(w, "feature is required", http.StatusBadRequest)
+			return
+		}
+		if request.Limit != nil && !override.Enabled {
+			http.Error(w, "limit can't be set when revoking a feature", http.StatusBadRequest)
+			return
+		}
+		if request.Limit != nil && *request.Limit < 0 {
+			http.Error(w, "limit can't be negative", http.StatusBadRequest)
+			return
+		}
+		override.Feature = request.Feature
+		override.Limit = request.Limit
+	case mongodbTypes.OverrideKindPlan:
+		if _, ok := catalog.GetPlan(request.PlanID); !ok {
+			http.Error(w, "unknown plan: "+request.PlanID, http.StatusBadRequest)
+			return
+		}
+		if !override.Enabled {
+			http.Error(w, "comped plans can't be disabled, revoke the override instead", http.StatusBadRequest)
+			return
+		}
+		override.PlanID = request.PlanID
+	default:
+		http.Error(w, "kind must be feature or plan", http.StatusBadRequest)
+		return
+	}
+
+	override, err := mongodb.CreateEntitlementOverride(override)
+	if err != nil {
+		log.Printf("Error creating entitlement override: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	refreshEntitlements(owner)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(override)
+}
+
+// RevokeOverrideHandler is a handler that revokes an entitlement override of an organization or of a user billed personally
+// The author is passed in the revoked_by query parameter
+func RevokeOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := overrideOwner(r)
+
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	revokedBy := r.URL.Query().Get("revoked_by")
+	if revokedBy == "" {
+		http.Error(w, "revoked_by is required", http.StatusBadRequest)
+		return
+	}
+
+	err = mongodb.RevokeEntitlementOverride(owner, id, revokedBy)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking entitlement override: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	refreshEntitlements(owner)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// overrideOwner returns the owner named in the path, an organization or a user billed personally
+func overrideOwner(r *http.Request) mongodbTypes.Organization {
+	if userID := r.PathValue("clerkUserId"); userID != "" {
+		return mongodbTypes.Organization{OwnerType: mongodbTypes.OwnerTypeUser, ClerkUserID: userID}
+	}
+	return mongodbTypes.Organization{OwnerType: mongodbTypes.OwnerTypeOrganization, ClerkID: r.PathValue("clerkOrgId")}
+}
+
+// refreshEntitlements republishes the owner's entitlements after an override changed
+// A failure is only logged: the override is stored and applies on the next recompute
+func refreshEntitlements(owner mongodbTypes.Organization) {
+	if err := clerk.RefreshOwnerEntitlements(owner); err != nil {
+		log.Printf("Error refreshing entitlements of owner %s: %v", owner.OwnerID(), err)
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/entitlements"
+	entitlementsTypes "nucleus/types/entitlements"
+	"sort"
+)
+
+// Types of the pending changes of a subscription
+const (
+	pendingChangeCancel        = "cancel"         // The subscription ends at effective_at
+	pendingChangePlan          = "plan_change"    // A schedule switches the subscription to plan at effective_at
+	pendingChangePendingUpdate = "pending_update" // An update to plan applies once its payment succeeds, until expires_at
+)
+
+// pendingChange is an upcoming change of a subscription the UI can announce, e.g. "Your plan changes to Basic on Nov 1"
+type pendingChange struct {
+	SubscriptionID string         `json:"subscription_id"`
+	Type           string         `json:"type"`
+	Plan           *effectivePlan `json:"plan,omitempty"`
+	EffectiveAt    int64          `json:"effective_at,omitempty"`
+	ExpiresAt      int64          `json:"expires_at,omitempty"`
+}
+
+// effectivePlan describes the catalog plan consumers should show and gate on
+type effectivePlan struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Tier int    `json:"tier"`
+}
+
+// planResponse resolves the caller's subscriptions to a single plan
+type planResponse struct {
+	EffectivePlan          *effectivePlan           `json:"effective_plan"`
+	Plans                  []string                 `json:"plans"`
+	Addons                 []string                 `json:"addons"`
+	Subscriptions          []map[string]interface{} `json:"subscriptions"`
+	DuplicateSubscriptions []string                 `json:"duplicate_subscriptions"`
+	PendingChanges         []pendingChange          `json:"pending_changes"`
+}
+
+// GetUserPlanHandler is a handler that returns the effective plan of the caller's organization (or personal billing)
+// When several subscriptions grant a plan, the one with the highest catalog tier wins and the subscriptions are listed as duplicates
+func GetUserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var current entitlementsTypes.Entitlements
+	var subscriptions []map[string]interface{}
+	if organizationID, ok := auth.GetOrganizationID(r); ok {
+		current = clerk.GetEntitlementsByOrganizationID(organizationID)
+		subscriptions = clerk.GetActiveSubscriptionsByOrganizationID(organizationID)
+	} else if userID, ok := auth.GetUserID(r); ok {
+		current = clerk.GetEntitlementsByUserID(userID)
+		subscriptions = clerk.GetActiveSubscriptionsByUserID(userID)
+	} else {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response := planResponse{
+		Plans:                  current.Plans,
+		Addons:                 current.Addons,
+		Subscriptions:          subscriptions,
+		DuplicateSubscriptions: current.DuplicateSubscriptions,
+		PendingChanges:         pendingChanges(subscriptions),
+	}
+	response.EffectivePlan = planSummary(current.EffectivePlan)
+	if response.Addons == nil {
+		response.Addons = []string{}
+	}
+	if response.Subscriptions == nil {
+		response.Subscriptions = []map[string]interface{}{}
+	}
+	if response.DuplicateSubscriptions == nil {
+		response.DuplicateSubscriptions = []string{}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// pendingChanges lists the upcoming cancellations and plan changes mirrored in the subscriptions, soonest first
+// A scheduled phase without a following one ends the subscription when the schedule's end behavior is cancel
+func pendingChanges(subscriptions []map[string]interface{}) []pendingChange {
+	changes := []pendingChange{}
+	for _, subscription := range subscriptions {
+		subscriptionID, _ := subscription["id"].(string)
+
+		cancelAt, _ := entitlements.MetadataInt(subscription["cancel_at"])
+		if cancelAtPeriodEnd, _ := subscription["cancel_at_period_end"].(bool); cancelAt == 0 && cancelAtPeriodEnd {
+			cancelAt, _ = entitlements.MetadataInt(subscription["current_period_end"])
+		}
+
+		if schedule, ok := subscription["schedule"].(map[string]interface{}); ok {
+			if nextPhase, ok := schedule["next_phase"].(map[string]interface{}); ok {
+				change := pendingChange{SubscriptionID: subscriptionID, Type: pendingChangePlan, Plan: planSummary(nextPhase["plan_id"])}
+				change.EffectiveAt, _ = entitlements.MetadataInt(nextPhase["start_date"])
+				changes = append(changes, change)
+			} else if schedule["end_behavior"] == "cancel" && cancelAt == 0 {
+				cancelAt, _ = entitlements.MetadataInt(schedule["current_phase_end"])
+			}
+		}
+
+		if update, ok := subscription["pending_update"].(map[string]interface{}); ok {
+			change := pendingChange{SubscriptionID: subscriptionID, Type: pendingChangePendingUpdate, Plan: planSummary(update["plan_id"])}
+			change.ExpiresAt, _ = entitlements.MetadataInt(update["expires_at"])
+			changes = append(changes, change)
+		}
+
+		if cancelAt > 0 {
+			changes = append(changes, pendingChange{SubscriptionID: subscriptionID, Type: pendingChangeCancel, EffectiveAt: cancelAt})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].EffectiveAt < changes[j].EffectiveAt })
+	return changes
+}
+
+// planSummary returns the catalog plan with the given ID, nil when it isn't in the catalog
+func planSummary(planID interface{}) *effectivePlan {
+	id, _ := planID.(string)
+	plan, ok := catalog.GetPlan(id)
+	if !ok {
+		return nil
+	}
+	return &effectivePlan{ID: plan.ID, Name: plan.Name, Tier: plan.Tier}
+}
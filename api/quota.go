@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/quota"
+)
+
+// ConsumeQuotaHandler is a handler that atomically consumes quota of a limited feature for the current billing period
+// It answers 200 when the consumption is allowed, 429 when it would go past the hard limit,
+// 402 when the owner reached its spend cap and 403 when the feature isn't enabled
+// Users without an active organization consume the quota of their personal billing
+func ConsumeQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		OrganizationID string `json:"organization_id"`
+		Amount         *int64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	amount := int64(1)
+	if request.Amount != nil {
+		amount = *request.Amount
+	}
+	if amount < 0 {
+		http.Error(w, "amount can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	ownerID, ok := getTargetOwner(r, request.OrganizationID)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	result, err := quota.Consume(ownerID, r.PathValue("feature"), amount)
+	if errors.Is(err, quota.ErrNotEntitled) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if err != nil {
+		log.Printf("Error consuming quota: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case result.Reason == quota.ReasonSpendCapReached:
+		w.WriteHeader(http.StatusPaymentRequired)
+	case !result.Allowed:
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	json.NewEncoder(w).Encode(result)
+}
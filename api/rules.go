@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/entitlements"
+	"nucleus/entitlements/rules"
+	"sort"
+)
+
+// ruleResult is the outcome of evaluating one rule expression
+type ruleResult struct {
+	Feature    string `json:"feature,omitempty"`
+	Expression string `json:"expression"`
+	Result     bool   `json:"result"`
+	Error      string `json:"error,omitempty"`
+}
+
+// EvaluateRulesHandler is a debug handler that evaluates an expression, or every catalog rule when none is given,
+// against an organization and returns the results together with the context they were evaluated against
+func EvaluateRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		OrganizationID string `json:"organization_id"`
+		Expression     string `json:"expression"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.OrganizationID == "" {
+		http.Error(w, "organization_id is required", http.StatusBadRequest)
+		return
+	}
+
+	programs := map[string]*rules.Program{}
+	if request.Expression != "" {
+		program, err := rules.Compile(request.Expression)
+		if err != nil {
+			http.Error(w, "invalid expression: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		programs[""] = program
+	} else {
+		programs = catalog.Rules()
+	}
+
+	input, err := clerk.GetEntitlementsInputByOrganizationID(request.OrganizationID)
+	if err != nil {
+		log.Printf("Error getting organization metadata: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ruleContext := entitlements.RuleContext(input)
+
+	results := []ruleResult{}
+	for feature, program := range programs {
+		result := ruleResult{Feature: feature, Expression: program.String()}
+		result.Result, err = program.Evaluate(ruleContext)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Feature < results[j].Feature })
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"organization_id": request.OrganizationID,
+		"results":         results,
+		"context":         ruleContext,
+	})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/catalog"
+	"nucleus/mongodb"
+	"nucleus/stripe"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// scheduleResponse describes the plan change an admin scheduled on a subscription
+type scheduleResponse struct {
+	ScheduleID     string `json:"schedule_id"`
+	SubscriptionID string `json:"subscription_id"`
+	PlanID         string `json:"plan_id"`
+	PriceID        string `json:"price_id"`
+	Quantity       int64  `json:"quantity"`
+	StartsAt       int64  `json:"starts_at"`
+}
+
+// SubscriptionScheduleHandler is a handler that schedules a change of an organization's subscription to another plan
+// at the end of its current period (POST), or releases the subscription's schedule (DELETE)
+// The author is passed in created_by for a new schedule and in the released_by query parameter for a release
+func SubscriptionScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST", "DELETE"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organization, err := mongodb.GetOrganizationByClerkID(r.PathValue("clerkOrgId"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting organization: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	subscriptionID := r.PathValue("subscriptionId")
+
+	if r.Method == http.MethodDelete {
+		releasedBy := r.URL.Query().Get("released_by")
+		if releasedBy == "" {
+			http.Error(w, "released_by is required", http.StatusBadRequest)
+			return
+		}
+
+		_, err := stripe.ReleaseSubscriptionSchedule(organization, subscriptionID, releasedBy)
+		if errors.Is(err, stripe.ErrSubscriptionNotFound) || errors.Is(err, stripe.ErrNoSchedule) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error releasing subscription schedule: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var request struct {
+		PriceID   string `json:"price_id"`
+		Quantity  int64  `json:"quantity"`
+		CreatedBy string `json:"created_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.PriceID == "" || request.CreatedBy == "" {
+		http.Error(w, "price_id and created_by are required", http.StatusBadRequest)
+		return
+	}
+	if request.Quantity < 0 {
+		http.Error(w, "quantity can't be negative", http.StatusBadRequest)
+		return
+	}
+	if request.Quantity == 0 {
+		request.Quantity = 1
+	}
+
+	schedule, err := stripe.ScheduleSubscriptionChange(organization, subscriptionID, request.PriceID, request.Quantity, request.CreatedBy)
+	switch {
+	case errors.Is(err, stripe.ErrNotBasePlan):
+		http.Error(w, "price_id must be a price of a recurring base plan: "+request.PriceID, http.StatusBadRequest)
+		return
+	case errors.Is(err, stripe.ErrSubscriptionNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	case errors.Is(err, stripe.ErrScheduleExists):
+		http.Error(w, "subscription already has a schedule, release it first", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error scheduling subscription change: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	plan, _ := catalog.FindPlan("", request.PriceID)
+	response := scheduleResponse{
+		ScheduleID:     schedule.ID,
+		SubscriptionID: subscriptionID,
+		PlanID:         plan.ID,
+		PriceID:        request.PriceID,
+		Quantity:       request.Quantity,
+	}
+	if len(schedule.Phases) > 1 {
+		response.StartsAt = schedule.Phases[1].StartDate
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/auth"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	defaultAccessTokenLifetimeDays = 90
+	maxAccessTokenLifetimeDays     = 365
+)
+
+// accessTokenResponse is returned when an access token is created, it's the only time the plaintext token is shown
+type accessTokenResponse struct {
+	Token       string                   `json:"token"`
+	AccessToken mongodbTypes.AccessToken `json:"access_token"`
+}
+
+// AccessTokensHandler is a handler that lists (GET) or mints (POST) access tokens for the active organization
+// Tokens can only be granted permissions the caller holds in the organization
+func AccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET", "POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID, ok := auth.GetOrganizationID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		tokens, err := mongodb.ListAccessTokensByOrganization(organizationID)
+		if err != nil {
+			log.Printf("Error listing access tokens: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(tokens)
+		return
+	}
+
+	var request struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if request.ExpiresInDays == 0 {
+		request.ExpiresInDays = defaultAccessTokenLifetimeDays
+	}
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxAccessTokenLifetimeDays {
+		http.Error(w, "expires_in_days must be between 1 and 365", http.StatusBadRequest)
+		return
+	}
+
+	permissions, _ := auth.GetOrganizationPermissions(r)
+	for _, scope := range request.Scopes {
+		if !slices.Contains(permissions, scope) {
+			http.Error(w, "cannot grant a permission you don't hold: "+scope, http.StatusForbidden)
+			return
+		}
+	}
+
+	secret, prefix, hash, err := auth.GenerateSecret(auth.AccessTokenPrefix)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := auth.GetUserID(r)
+	now := time.Now()
+	token, err := mongodb.CreateAccessToken(mongodbTypes.AccessToken{
+		OrganizationID: organizationID,
+		Name:           request.Name,
+		Prefix:         prefix,
+		TokenHash:      hash,
+		Scopes:         request.Scopes,
+		CreatedBy:      userID,
+		CreatedAt:      now,
+		ExpiresAt:      now.AddDate(0, 0, request.ExpiresInDays),
+	})
+	if err != nil {
+		log.Printf("Error creating access token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(accessTokenResponse{Token: secret, AccessToken: token})
+}
+
+// RevokeAccessTokenHandler is a handler that revokes an access token of the active organization
+func RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID, ok := auth.GetOrganizationID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	err = mongodb.RevokeAccessToken(organizationID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
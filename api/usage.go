@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nucleus/spend"
+	"nucleus/usage"
+)
+
+// maxUsageBatchSize is the maximum number of usage records accepted per request
+const maxUsageBatchSize = 1000
+
+// usageRecordResult is the outcome of a single record of a usage batch
+type usageRecordResult struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	Status         string `json:"status"` // accepted, duplicate or rejected
+	Error          string `json:"error,omitempty"`
+}
+
+// PostUsageEventsHandler is a handler that buffers a batch of usage records until they're flushed to Stripe
+// Service API keys can report usage for any organization they're allowed to access, users only for their active organization,
+// or for themselves when billed personally
+// The spend of every owner that got new usage is checked right away, so a spend cap blocks quota before the next flush
+func PostUsageEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Events []usage.Record `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if len(request.Events) == 0 || len(request.Events) > maxUsageBatchSize {
+		http.Error(w, "events must contain between 1 and 1000 records", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]usageRecordResult, 0, len(request.Events))
+	accepted := 0
+	owners := map[string]bool{}
+	for _, record := range request.Events {
+		result := usageRecordResult{IdempotencyKey: record.IdempotencyKey}
+
+		ownerID, allowed := getTargetOwner(r, record.OrganizationID)
+		if allowed {
+			record.OrganizationID = ownerID
+		}
+
+		switch err := record.Validate(); {
+		case err != nil:
+			result.Status, result.Error = "rejected", err.Error()
+		case !allowed:
+			result.Status, result.Error = "rejected", "not allowed to report usage for this organization"
+		default:
+			_, err := usage.Ingest(record)
+			if errors.Is(err, usage.ErrDuplicate) {
+				result.Status = "duplicate"
+			} else if err != nil {
+				log.Printf("Error recording usage event: %v", err)
+				result.Status, result.Error = "rejected", "internal error, retry with the same idempotency key"
+			} else {
+				result.Status = "accepted"
+				accepted++
+				owners[record.OrganizationID] = true
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	for ownerID := range owners {
+		if _, err := spend.Check(ownerID); err != nil {
+			log.Printf("Error checking spend of owner %s: %v", ownerID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accepted": accepted,
+		"results":  results,
+	})
+}
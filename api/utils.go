@@ -1,7 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"nucleus/auth"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
 	"strings"
 )
 
@@ -27,3 +31,51 @@ func VerifyMethod(r *http.Request, allowedMethods []string) bool {
 	}
 	return false
 }
+
+// getTargetOrganization returns the organization a request shared by service keys and users acts on
+// Service API keys must name an organization they're allowed to access, users act on their active organization
+// and may only name that one
+func getTargetOrganization(r *http.Request, requested string) (string, bool) {
+	if _, ok := auth.GetServiceKey(r); ok {
+		return requested, requested != "" && auth.ServiceKeyAllowsOrganization(r, requested)
+	}
+
+	organizationID, ok := auth.GetOrganizationID(r)
+	if !ok || (requested != "" && requested != organizationID) {
+		return "", false
+	}
+	return organizationID, true
+}
+
+// getTargetOwner returns the billing owner a usage request acts on, like getTargetOrganization,
+// except that users without an active organization act on their personal billing under their user ID
+func getTargetOwner(r *http.Request, requested string) (string, bool) {
+	if _, ok := auth.GetServiceKey(r); ok {
+		return getTargetOrganization(r, requested)
+	}
+	if _, ok := auth.GetOrganizationID(r); ok {
+		return getTargetOrganization(r, requested)
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok || (requested != "" && requested != userID) {
+		return "", false
+	}
+	return userID, true
+}
+
+// getBillingOwner returns the billing mapping for the authenticated request.
+// It resolves the active organization first and falls back to the user's personal mapping.
+func getBillingOwner(r *http.Request) (mongodbTypes.Organization, error) {
+	// Get the organization object from the database using the clerkID
+	if organizationID, ok := auth.GetOrganizationID(r); ok {
+		return mongodb.GetOrganizationByClerkID(organizationID)
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		return mongodbTypes.Organization{}, fmt.Errorf("no organization or user in request context")
+	}
+
+	return mongodb.GetOrganizationByClerkUserID(userID)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"nucleus/clerk"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+)
+
+// AccessTokenPrefix is the prefix of every organization access token
+const AccessTokenPrefix = "npat_"
+
+const (
+	// AuthMethodSession marks requests authenticated with a Clerk session token
+	AuthMethodSession = "session"
+	// AuthMethodAccessToken marks requests authenticated with an organization access token
+	AuthMethodAccessToken = "access_token"
+	// AuthMethodServiceKey marks requests authenticated with a service API key
+	AuthMethodServiceKey = "service_key"
+)
+
+// AuthMethodKey is the context key for storing how the request was authenticated
+type AuthMethodKey struct{}
+
+// AccessTokenKey is the context key for storing the organization access token used by the request
+type AccessTokenKey struct{}
+
+// GetAuthMethod retrieves how the request was authenticated from the request context
+func GetAuthMethod(r *http.Request) (string, bool) {
+	method, ok := r.Context().Value(AuthMethodKey{}).(string)
+	return method, ok
+}
+
+// GetAccessToken retrieves the organization access token used by the request from the request context
+func GetAccessToken(r *http.Request) (mongodbTypes.AccessToken, bool) {
+	token, ok := r.Context().Value(AccessTokenKey{}).(mongodbTypes.AccessToken)
+	return token, ok
+}
+
+// RequireSession is a middleware that only lets requests authenticated with a Clerk session through
+// It keeps access tokens from managing access tokens
+// It must be chained after VerifyingMiddleware
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if method, _ := GetAuthMethod(r); method != AuthMethodSession {
+			logDenied(r, "auth_method", AuthMethodSession)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAccessToken resolves an organization access token to the same context a session would produce
+// The token's scopes that its creator still holds take the place of the organization permissions, so a demoted creator's
+// tokens lose the permissions they lost. Tokens are only valid while their creator is a member of the organization,
+// in case the webhook that revokes them was missed
+func authenticateAccessToken(ctx context.Context, secret string) (context.Context, error) {
+	token, err := mongodb.GetActiveAccessTokenByHash(HashSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	membership, err := clerk.GetUserOrganizationMembership(token.CreatedBy, token.OrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("creator of access token %s: %w", token.Prefix, err)
+	}
+
+	permissions := []string{}
+	for _, scope := range token.Scopes {
+		if slices.Contains(membership.Permissions, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	go func() {
+		if err := mongodb.TouchAccessToken(token.ID); err != nil {
+			log.Printf("Error updating access token last use: %v", err)
+		}
+	}()
+
+	ctx = context.WithValue(ctx, AuthMethodKey{}, AuthMethodAccessToken)
+	ctx = context.WithValue(ctx, AccessTokenKey{}, token)
+	ctx = context.WithValue(ctx, OrganizationIDKey{}, token.OrganizationID)
+	ctx = context.WithValue(ctx, OrganizationPermissionsKey{}, permissions)
+	return ctx, nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminMiddleware protects the operator endpoints under /admin with the ADMIN_API_KEY bearer token
+// Admin endpoints are disabled when ADMIN_API_KEY is not set
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		if adminKey == "" {
+			http.Error(w, "Admin API disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) != 1 {
+			log.Printf("[AUTH] Denied admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("[API] Admin request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
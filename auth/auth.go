@@ -2,17 +2,30 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"nucleus/auth/session"
 	"nucleus/clerk"
 	"strings"
 	"time"
 
-	clerkhttp "github.com/clerk/clerk-sdk-go/v2/http"
-	clerkjwt "github.com/clerk/clerk-sdk-go/v2/jwt"
+	clerkSDK "github.com/clerk/clerk-sdk-go/v2"
 )
 
+// OrganizationHeader is the request header clients use to select the active organization
+const OrganizationHeader = "X-Organization-ID"
+
+// UserIDKey is the context key for storing the authenticated user ID
+type UserIDKey struct{}
+
+// GetUserID retrieves the authenticated user ID from the request context
+func GetUserID(r *http.Request) (string, bool) {
+	userID, ok := r.Context().Value(UserIDKey{}).(string)
+	return userID, ok
+}
+
 // OrganizationIDKey is the context key for storing organization ID
 type OrganizationIDKey struct{}
 
@@ -23,59 +36,129 @@ func GetOrganizationID(r *http.Request) (string, bool) {
 }
 
 // VerifyingMiddleware is the general middleware that verifies the passed JWT Token from clerk and extracts the user ID and organization ID to pass it to the next handler
+// The active organization is taken from the X-Organization-ID header or the session's org_id claim, falling back to the user's first membership
+// The caller's role and permissions in that organization are stored in the context next to the organization ID
+// Users without an organization only get the user ID in the context so handlers can fall back to personal billing
+// Organization access tokens (npat_) are accepted as an alternative to Clerk JWTs and resolve to the token's organization
 func VerifyingMiddleware(next http.Handler) http.Handler {
-	return clerkhttp.RequireHeaderAuthorization()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[API] Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 		startTime := time.Now()
 
-		userID, err := extractUserIDFromAuthHeader(r)
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); strings.HasPrefix(token, AccessTokenPrefix) {
+			ctx, err := authenticateAccessToken(r.Context(), token)
+			if err != nil {
+				log.Printf("[AUTH] Rejected access token %s: %v", token[:min(len(token), secretPrefixLength)], err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(ctx)
+
+			next.ServeHTTP(w, r)
+			log.Printf("[API] Response: %s %s -> STATUS: %d completed in %v", r.Method, r.URL.Path, http.StatusOK, time.Since(startTime))
+			return
+		}
+
+		claims, err := extractClaimsFromAuthHeader(r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		userID := claims.RegisteredClaims.Subject
 
-		organizationID, err := clerk.GetUserOrganizationId(userID)
-		if err != nil {
+		access, err := resolveOrganization(r, userID, claims)
+		if errors.Is(err, clerk.ErrNotOrganizationMember) {
+			log.Printf("[API] User %s is not a member of organization %s", userID, r.Header.Get(OrganizationHeader))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err != nil && !errors.Is(err, clerk.ErrNoOrganization) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		// Add user ID to request context
-		ctx := context.WithValue(r.Context(), OrganizationIDKey{}, organizationID)
+		// Add user ID and organization access (if any) to request context
+		ctx := context.WithValue(r.Context(), AuthMethodKey{}, AuthMethodSession)
+		ctx = context.WithValue(ctx, UserIDKey{}, userID)
+		if access.ID != "" {
+			ctx = context.WithValue(ctx, OrganizationIDKey{}, access.ID)
+			ctx = context.WithValue(ctx, OrganizationRoleKey{}, access.Role)
+			ctx = context.WithValue(ctx, OrganizationPermissionsKey{}, access.Permissions)
+		}
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 		log.Printf("[API] Response: %s %s -> STATUS: %d completed in %v", r.Method, r.URL.Path, http.StatusOK, time.Since(startTime))
-	}))
+	})
+}
+
+// organizationAccess is the organization a request acts on and the caller's role and permissions in it
+type organizationAccess struct {
+	ID          string
+	Role        string
+	Permissions []string
 }
 
-// extractUserIDFromAuthHeader extracts the user ID from the Authorization header
-func extractUserIDFromAuthHeader(req *http.Request) (string, error) {
+// resolveOrganization picks the organization the request acts on
+// An explicit X-Organization-ID header wins over the org_id claim and must be an organization the user belongs to
+// The org_id, org_role and org_permissions claims are signed by Clerk so they don't need to be checked against the membership API
+func resolveOrganization(r *http.Request, userID string, claims *clerkSDK.SessionClaims) (organizationAccess, error) {
+	if requested := r.Header.Get(OrganizationHeader); requested != "" && requested != claims.ActiveOrganizationID {
+		membership, err := clerk.GetUserOrganizationMembership(userID, requested)
+		if err != nil {
+			return organizationAccess{}, err
+		}
+		return accessFromMembership(membership), nil
+	}
+
+	if claims.ActiveOrganizationID != "" {
+		return organizationAccess{
+			ID:          claims.ActiveOrganizationID,
+			Role:        claims.ActiveOrganizationRole,
+			Permissions: claims.ActiveOrganizationPermissions,
+		}, nil
+	}
+
+	membership, err := clerk.GetUserDefaultOrganizationMembership(userID)
+	if err != nil {
+		return organizationAccess{}, err
+	}
+	return accessFromMembership(membership), nil
+}
+
+// accessFromMembership builds the organization access from a membership returned by the Clerk API
+func accessFromMembership(membership *clerkSDK.OrganizationMembership) organizationAccess {
+	return organizationAccess{
+		ID:          membership.Organization.ID,
+		Role:        membership.Role,
+		Permissions: membership.Permissions,
+	}
+}
+
+// extractClaimsFromAuthHeader verifies the token in the Authorization header and returns its session claims
+func extractClaimsFromAuthHeader(req *http.Request) (*clerkSDK.SessionClaims, error) {
 	authHeader := req.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", fmt.Errorf("missing authorization header")
+		return nil, fmt.Errorf("missing authorization header")
 	}
 
 	// Check if it's a Bearer token
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", fmt.Errorf("invalid authorization header format")
+		return nil, fmt.Errorf("invalid authorization header format")
 	}
 
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 
 	// Verify the JWT token and extract claims
-	claims, err := clerkjwt.Verify(context.Background(), &clerkjwt.VerifyParams{
-		Token: token,
-	})
+	claims, err := session.Verify(req.Context(), token)
 	if err != nil {
-		return "", fmt.Errorf("failed to verify token: %v", err)
+		return nil, fmt.Errorf("failed to verify token: %v", err)
 	}
 
 	// Extract user ID from the subject claim
-	userID := claims.RegisteredClaims.Subject
-	if userID == "" {
-		return "", fmt.Errorf("no user ID found in token")
+	if claims.RegisteredClaims.Subject == "" {
+		return nil, fmt.Errorf("no user ID found in token")
 	}
 
-	return userID, nil
+	return claims, nil
 }
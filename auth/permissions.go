@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"slices"
+)
+
+const (
+	// RoleAdmin is the Clerk role of organization admins
+	RoleAdmin = "org:admin"
+
+	// PermissionBillingManage allows changing the organization's billing configuration
+	PermissionBillingManage = "org:billing:manage"
+
+	// PermissionQuotaConsume allows consuming the quota of every feature
+	PermissionQuotaConsume = "org:quota:consume"
+
+	// PermissionCreditsDebit allows debiting the organization's prepaid credits
+	PermissionCreditsDebit = "org:credits:debit"
+)
+
+// QuotaConsumePermission returns the permission that allows consuming the quota of a single feature, e.g. org:api_calls:consume
+func QuotaConsumePermission(feature string) string {
+	return "org:" + feature + ":consume"
+}
+
+// OrganizationRoleKey is the context key for storing the caller's role in the active organization
+type OrganizationRoleKey struct{}
+
+// OrganizationPermissionsKey is the context key for storing the caller's permissions in the active organization
+type OrganizationPermissionsKey struct{}
+
+// GetOrganizationRole retrieves the caller's role in the active organization from the request context
+func GetOrganizationRole(r *http.Request) (string, bool) {
+	role, ok := r.Context().Value(OrganizationRoleKey{}).(string)
+	return role, ok
+}
+
+// GetOrganizationPermissions retrieves the caller's permissions in the active organization from the request context
+func GetOrganizationPermissions(r *http.Request) ([]string, bool) {
+	permissions, ok := r.Context().Value(OrganizationPermissionsKey{}).([]string)
+	return permissions, ok
+}
+
+// HasPermission reports whether the caller holds the permission in the active organization
+func HasPermission(r *http.Request, permission string) bool {
+	permissions, _ := GetOrganizationPermissions(r)
+	return slices.Contains(permissions, permission)
+}
+
+// HasRole reports whether the caller has the role in the active organization
+func HasRole(r *http.Request, role string) bool {
+	current, _ := GetOrganizationRole(r)
+	return current == role
+}
+
+// RequirePermission is a middleware that only lets the request through if the caller holds the permission in the active organization
+// It must be chained after VerifyingMiddleware
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasPermission(r, permission) {
+				logDenied(r, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireQuotaPermission is a middleware that only lets the request consume the quota of the feature in its path
+// if the caller holds the feature's consume permission or PermissionQuotaConsume in the active organization
+// Service keys are let through, their quota:consume scope is checked by ServiceKeyOrVerifyingMiddleware,
+// and so are users without an active organization, who consume the quota of their personal billing
+func RequireQuotaPermission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, isServiceKey := GetServiceKey(r)
+		if _, hasOrganization := GetOrganizationID(r); !isServiceKey && hasOrganization {
+			permission := QuotaConsumePermission(r.PathValue("feature"))
+			if !HasPermission(r, permission) && !HasPermission(r, PermissionQuotaConsume) {
+				logDenied(r, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMemberPermission is a middleware that only lets session and access token callers through if they hold the permission
+// Service keys are let through, their scope is checked by ServiceKeyOrVerifyingMiddleware
+func RequireMemberPermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := GetServiceKey(r); !ok && !HasPermission(r, permission) {
+				logDenied(r, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireBillingManager is a middleware that only lets the request through if the caller may manage the billing
+// of its billing owner: PermissionBillingManage in the active organization, or any user billed personally
+// It must be chained after VerifyingMiddleware
+func RequireBillingManager(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasOrganization := GetOrganizationID(r)
+		if hasOrganization && !HasPermission(r, PermissionBillingManage) {
+			logDenied(r, "permission", PermissionBillingManage)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole is a middleware that only lets the request through if the caller has the role in the active organization
+// Prefer RequirePermission, role checks can usually be expressed as a permission
+// It must be chained after VerifyingMiddleware
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasRole(r, role) {
+				logDenied(r, "role", role)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logDenied writes an audit log entry for a denied authorization decision
+func logDenied(r *http.Request, kind string, required string) {
+	userID, _ := GetUserID(r)
+	organizationID, _ := GetOrganizationID(r)
+	role, _ := GetOrganizationRole(r)
+	log.Printf("[AUTH] Denied %s %s: user=%s organization=%s role=%s required_%s=%s", r.Method, r.URL.Path, userID, organizationID, role, kind, required)
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// secretPrefixLength is the number of characters of a secret kept in clear text to identify it
+const secretPrefixLength = 12
+
+// GenerateSecret returns a new random secret with the given prefix, its display prefix and its hash
+// Secrets carry 256 bits of entropy so a plain SHA-256 hash is enough to store them
+func GenerateSecret(prefix string) (secret string, displayPrefix string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	secret = prefix + hex.EncodeToString(buf)
+	return secret, secret[:secretPrefixLength], HashSecret(secret), nil
+}
+
+// HashSecret returns the hex encoded SHA-256 hash of the secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ServiceKeyPrefix is the prefix of every service API key
+const ServiceKeyPrefix = "nsk_"
+
+const (
+	// ScopeSubscriptionsRead allows reading the subscriptions of any organization
+	ScopeSubscriptionsRead = "subscriptions:read"
+	// ScopeEntitlementsRead allows reading the entitlements of any organization
+	ScopeEntitlementsRead = "entitlements:read"
+	// ScopeUsageWrite allows reporting usage events for any organization
+	ScopeUsageWrite = "usage:write"
+	// ScopeQuotaConsume allows consuming the quotas of any organization
+	ScopeQuotaConsume = "quota:consume"
+	// ScopeCreditsDebit allows debiting the prepaid credits of any organization
+	ScopeCreditsDebit = "credits:debit"
+)
+
+// ServiceScopes lists the scopes that can be granted to a service API key
+var ServiceScopes = []string{ScopeSubscriptionsRead, ScopeEntitlementsRead, ScopeUsageWrite, ScopeQuotaConsume, ScopeCreditsDebit}
+
+// ServiceKeyKey is the context key for storing the authenticated service API key
+type ServiceKeyKey struct{}
+
+// GetServiceKey retrieves the authenticated service API key from the request context
+func GetServiceKey(r *http.Request) (mongodbTypes.ServiceAPIKey, bool) {
+	key, ok := r.Context().Value(ServiceKeyKey{}).(mongodbTypes.ServiceAPIKey)
+	return key, ok
+}
+
+// ServiceKeyMiddleware is the middleware for internal backends, it authenticates the service API key passed
+// as a Bearer token or in the X-API-Key header and stores it in the request context
+func ServiceKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[API] Service request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		startTime := time.Now()
+
+		secret := extractServiceKey(r)
+		if !strings.HasPrefix(secret, ServiceKeyPrefix) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		key, err := mongodb.GetServiceAPIKeyByHash(HashSecret(secret))
+		if err != nil {
+			log.Printf("[AUTH] Rejected service API key %s: %v", secret[:min(len(secret), secretPrefixLength)], err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		go func() {
+			if err := mongodb.TouchServiceAPIKey(key.ID); err != nil {
+				log.Printf("Error updating service API key last use: %v", err)
+			}
+		}()
+
+		ctx := context.WithValue(r.Context(), ServiceKeyKey{}, key)
+		ctx = context.WithValue(ctx, AuthMethodKey{}, AuthMethodServiceKey)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+		log.Printf("[API] Service response: %s %s (key %s) completed in %v", r.Method, r.URL.Path, key.Prefix, time.Since(startTime))
+	})
+}
+
+// ServiceKeyOrVerifyingMiddleware is the middleware for endpoints shared by internal backends and users
+// Service API keys are authenticated by ServiceKeyMiddleware and must hold the scope, anything else goes through VerifyingMiddleware
+func ServiceKeyOrVerifyingMiddleware(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		serviceKeyHandler := ServiceKeyMiddleware(RequireScope(scope)(next))
+		verifyingHandler := VerifyingMiddleware(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(extractServiceKey(r), ServiceKeyPrefix) {
+				serviceKeyHandler.ServeHTTP(w, r)
+				return
+			}
+			verifyingHandler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope is a middleware that only lets the request through if the service API key holds the scope
+// It must be chained after ServiceKeyMiddleware
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := GetServiceKey(r)
+			if !ok || !slices.Contains(key.Scopes, scope) {
+				log.Printf("[AUTH] Denied %s %s: service_key=%s required_scope=%s", r.Method, r.URL.Path, key.Prefix, scope)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ServiceKeyAllowsOrganization reports whether the service API key in the context may access the organization
+func ServiceKeyAllowsOrganization(r *http.Request, organizationID string) bool {
+	key, ok := GetServiceKey(r)
+	if !ok {
+		return false
+	}
+	return key.OrganizationID == "" || key.OrganizationID == organizationID
+}
+
+// extractServiceKey returns the service API key from the X-API-Key header or the Bearer token
+func extractServiceKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
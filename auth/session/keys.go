@@ -0,0 +1,157 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	clerkSDK "github.com/clerk/clerk-sdk-go/v2"
+	"github.com/clerk/clerk-sdk-go/v2/jwks"
+)
+
+// minJWKSRefetchInterval limits how often an unknown key ID can force a JWKS refetch
+const minJWKSRefetchInterval = time.Minute
+
+// KeyProvider returns the JSON Web Key used to verify session tokens signed with the given key ID
+type KeyProvider interface {
+	GetJSONWebKey(ctx context.Context, keyID string) (*clerkSDK.JSONWebKey, error)
+}
+
+// staticKeyProvider always returns the same key, e.g. the PEM public key from the Clerk Dashboard
+type staticKeyProvider struct {
+	key *clerkSDK.JSONWebKey
+}
+
+// NewStaticKeyProvider returns a provider that verifies every token with the given key
+func NewStaticKeyProvider(key *clerkSDK.JSONWebKey) KeyProvider {
+	return &staticKeyProvider{key: key}
+}
+
+// NewPEMKeyProvider returns a provider that verifies every token with the PEM encoded RSA public key
+func NewPEMKeyProvider(pemKey string) (KeyProvider, error) {
+	key, err := clerkSDK.JSONWebKeyFromPEM(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeyProvider(key), nil
+}
+
+func (p *staticKeyProvider) GetJSONWebKey(_ context.Context, _ string) (*clerkSDK.JSONWebKey, error) {
+	return p.key, nil
+}
+
+// jwksKeyProvider keeps the JSON Web Key Set in memory and refreshes it periodically
+type jwksKeyProvider struct {
+	mu        sync.RWMutex
+	url       string
+	keys      map[string]*clerkSDK.JSONWebKey
+	fetchedAt time.Time
+}
+
+// NewJWKSKeyProvider returns a provider backed by a cached JWKS that is refreshed every refreshInterval
+// Keys are fetched from the JWKS URL if one is given and from the Clerk Backend API otherwise
+// A token signed with an unknown key ID triggers an early refetch so key rotations are picked up
+func NewJWKSKeyProvider(url string, refreshInterval time.Duration) KeyProvider {
+	provider := &jwksKeyProvider{url: url, keys: map[string]*clerkSDK.JSONWebKey{}}
+
+	if err := provider.refresh(context.Background()); err != nil {
+		log.Printf("[AUTH] Error fetching JWKS: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := provider.refresh(context.Background()); err != nil {
+				log.Printf("[AUTH] Error refreshing JWKS: %v", err)
+			}
+		}
+	}()
+
+	return provider
+}
+
+func (p *jwksKeyProvider) GetJSONWebKey(ctx context.Context, keyID string) (*clerkSDK.JSONWebKey, error) {
+	if keyID == "" {
+		return nil, fmt.Errorf("missing jwt kid header claim")
+	}
+
+	p.mu.RLock()
+	key, ok := p.keys[keyID]
+	fetchedAt := p.fetchedAt
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(fetchedAt) < minJWKSRefetchInterval {
+		return nil, fmt.Errorf("unknown json web key %s", keyID)
+	}
+
+	if err := p.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if key, ok := p.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown json web key %s", keyID)
+}
+
+// refresh replaces the cached keys with the current JWKS
+func (p *jwksKeyProvider) refresh(ctx context.Context) error {
+	keySet, err := p.fetch(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*clerkSDK.JSONWebKey, len(keySet.Keys))
+	for _, key := range keySet.Keys {
+		if key != nil {
+			keys[key.KeyID] = key
+		}
+	}
+	p.keys = keys
+	log.Printf("[AUTH] Loaded %d JSON Web Keys", len(keys))
+
+	return nil
+}
+
+// fetch downloads the JWKS from the configured URL or the Clerk Backend API
+func (p *jwksKeyProvider) fetch(ctx context.Context) (*clerkSDK.JSONWebKeySet, error) {
+	if p.url == "" {
+		return jwks.Get(ctx, &jwks.GetParams{})
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected JWKS response status: %d", response.StatusCode)
+	}
+
+	var keySet clerkSDK.JSONWebKeySet
+	if err := json.NewDecoder(response.Body).Decode(&keySet); err != nil {
+		return nil, err
+	}
+
+	return &keySet, nil
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"nucleus/config"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clerkSDK "github.com/clerk/clerk-sdk-go/v2"
+	clerkjwt "github.com/clerk/clerk-sdk-go/v2/jwt"
+	"github.com/joho/godotenv"
+)
+
+// VerifierConfig controls how session tokens are verified
+type VerifierConfig struct {
+	Keys              KeyProvider   // Source of the verification keys
+	Leeway            time.Duration // Allowed clock skew when checking exp/nbf/iat
+	AuthorizedParties []string      // Accepted azp claim values, empty accepts any
+	Clock             clerkSDK.Clock
+}
+
+// verifierConfig is read by every request and replaced by SetVerifierConfig, so it's swapped atomically
+var verifierConfig atomic.Pointer[VerifierConfig]
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	cfg := VerifierConfig{
+		Leeway:            config.Duration("CLERK_JWT_LEEWAY", 5*time.Second),
+		AuthorizedParties: config.List("CLERK_AUTHORIZED_PARTIES"),
+	}
+
+	// A PEM public key lets tokens be verified without ever calling Clerk
+	if pemKey := os.Getenv("CLERK_JWT_KEY"); pemKey != "" {
+		keys, err := NewPEMKeyProvider(pemKey)
+		if err != nil {
+			log.Fatalf("Invalid CLERK_JWT_KEY: %v", err)
+		}
+		cfg.Keys = keys
+	} else {
+		cfg.Keys = &lazyKeyProvider{build: func() KeyProvider {
+			return NewJWKSKeyProvider(os.Getenv("CLERK_JWKS_URL"), config.Duration("CLERK_JWKS_REFRESH_INTERVAL", time.Hour))
+		}}
+	}
+
+	verifierConfig.Store(&cfg)
+}
+
+// SetVerifierConfig replaces the token verification configuration
+// It lets tests inject a local key pair and clock so authentication runs fully offline
+func SetVerifierConfig(cfg VerifierConfig) {
+	verifierConfig.Store(&cfg)
+}
+
+// lazyKeyProvider builds its provider on first use, so importing the package never fetches keys over the network
+type lazyKeyProvider struct {
+	once     sync.Once
+	build    func() KeyProvider
+	provider KeyProvider
+}
+
+func (p *lazyKeyProvider) GetJSONWebKey(ctx context.Context, keyID string) (*clerkSDK.JSONWebKey, error) {
+	p.once.Do(func() { p.provider = p.build() })
+	return p.provider.GetJSONWebKey(ctx, keyID)
+}
+
+// Verify verifies a Clerk session token with the configured key, leeway and authorized parties
+func Verify(ctx context.Context, token string) (*clerkSDK.SessionClaims, error) {
+	cfg := verifierConfig.Load()
+
+	decoded, err := clerkjwt.Decode(ctx, &clerkjwt.DecodeParams{Token: token})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := cfg.Keys.GetJSONWebKey(ctx, decoded.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get json web key: %v", err)
+	}
+
+	params := &clerkjwt.VerifyParams{
+		Token:  token,
+		JWK:    key,
+		Clock:  cfg.Clock,
+		Leeway: cfg.Leeway,
+	}
+	if len(cfg.AuthorizedParties) > 0 {
+		params.AuthorizedPartyHandler = func(azp string) bool {
+			return slices.Contains(cfg.AuthorizedParties, azp)
+		}
+	}
+
+	return clerkjwt.Verify(ctx, params)
+}
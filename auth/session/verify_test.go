@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	clerkSDK "github.com/clerk/clerk-sdk-go/v2"
+)
+
+const testKeyID = "ins_test"
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// signToken signs the claims as an RS256 JWT with the given key
+func signToken(t *testing.T, key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+	t.Helper()
+
+	encode := func(value interface{}) string {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	signingInput := encode(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerify(t *testing.T) {
+	key := generateKey(t)
+	otherKey := generateKey(t)
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{
+			"sub": "user_123",
+			"iss": "https://clerk.example.com",
+			"azp": "https://app.example.com",
+			"iat": now.Add(-time.Minute).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(time.Minute).Unix(),
+		}
+		for name, value := range overrides {
+			result[name] = value
+		}
+		return result
+	}
+
+	tests := []struct {
+		name              string
+		signingKey        *rsa.PrivateKey
+		claims            map[string]interface{}
+		authorizedParties []string
+		wantErr           bool
+	}{
+		{name: "valid", signingKey: key, claims: claims(nil)},
+		{name: "expired", signingKey: key, claims: claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), wantErr: true},
+		{name: "expired within leeway", signingKey: key, claims: claims(map[string]interface{}{"exp": now.Add(-2 * time.Second).Unix()})},
+		{name: "not yet valid", signingKey: key, claims: claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}), wantErr: true},
+		{name: "signed with another key", signingKey: otherKey, claims: claims(nil), wantErr: true},
+		{name: "invalid issuer", signingKey: key, claims: claims(map[string]interface{}{"iss": "https://evil.example.com"}), wantErr: true},
+		{name: "authorized party", signingKey: key, claims: claims(nil), authorizedParties: []string{"https://app.example.com"}},
+		{name: "unauthorized party", signingKey: key, claims: claims(nil), authorizedParties: []string{"https://other.example.com"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetVerifierConfig(VerifierConfig{
+				Keys:              NewStaticKeyProvider(&clerkSDK.JSONWebKey{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: "RS256"}),
+				Leeway:            5 * time.Second,
+				AuthorizedParties: test.authorizedParties,
+				Clock:             fixedClock(now),
+			})
+
+			verified, err := Verify(context.Background(), signToken(t, test.signingKey, testKeyID, test.claims))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got claims for %s", verified.Subject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if verified.Subject != "user_123" {
+				t.Errorf("subject = %q, want user_123", verified.Subject)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformedToken(t *testing.T) {
+	key := generateKey(t)
+	SetVerifierConfig(VerifierConfig{
+		Keys: NewStaticKeyProvider(&clerkSDK.JSONWebKey{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: "RS256"}),
+	})
+
+	if _, err := Verify(context.Background(), "not.a.token"); err == nil {
+		t.Fatal("expected an error for a malformed token")
+	}
+}
+
+func TestLazyKeyProviderBuildsOnce(t *testing.T) {
+	key := generateKey(t)
+	builds := 0
+	provider := &lazyKeyProvider{build: func() KeyProvider {
+		builds++
+		return NewStaticKeyProvider(&clerkSDK.JSONWebKey{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: "RS256"})
+	}}
+
+	if builds != 0 {
+		t.Fatal("provider built before first use")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := provider.GetJSONWebKey(context.Background(), testKeyID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if builds != 1 {
+		t.Errorf("provider built %d times, want 1", builds)
+	}
+}
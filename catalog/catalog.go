@@ -0,0 +1,327 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/joho/godotenv"
+
+	"nucleus/entitlements/rules"
+	catalogTypes "nucleus/types/catalog"
+)
+
+var (
+	current        catalogTypes.Catalog
+	plansByPrice   map[string]catalogTypes.Plan
+	plansByProduct map[string]catalogTypes.Plan
+	compiledRules  map[string]*rules.Program
+	metersByEvent  map[string]catalogTypes.Meter
+	packsByID      map[string]catalogTypes.CreditPack
+	packsByPrice   map[string]catalogTypes.CreditPack
+)
+
+// defaultCurrency is the currency of meter prices when the catalog doesn't set one
+const defaultCurrency = "usd"
+
+// subscriptionStatuses are the Stripe subscription statuses a grace policy can name
+var subscriptionStatuses = []string{"active", "trialing", "past_due", "unpaid", "paused", "incomplete", "incomplete_expired", "canceled"}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	path := os.Getenv("PLAN_CATALOG_PATH")
+	if path == "" {
+		path = "plans.json"
+	}
+
+	catalog, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: plan catalog %s not found, no entitlements will be granted", path)
+		catalog = catalogTypes.Catalog{}
+	} else if err != nil {
+		log.Fatalf("Error loading plan catalog %s: %v", path, err)
+	}
+
+	if err := Set(catalog); err != nil {
+		log.Fatalf("Invalid plan catalog %s: %v", path, err)
+	}
+	log.Printf("[CATALOG] Loaded plan catalog version %d with %d plans and %d rules", current.Version, len(current.Plans), len(current.Rules))
+}
+
+// Load reads a plan catalog from a JSON file
+func Load(path string) (catalogTypes.Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return catalogTypes.Catalog{}, err
+	}
+
+	var catalog catalogTypes.Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return catalogTypes.Catalog{}, err
+	}
+
+	return catalog, nil
+}
+
+// Set validates the catalog, compiles its rules and makes it the current one
+func Set(catalog catalogTypes.Catalog) error {
+	byPrice := map[string]catalogTypes.Plan{}
+	byProduct := map[string]catalogTypes.Plan{}
+	byTier := map[int]catalogTypes.Plan{}
+	seen := map[string]bool{}
+
+	for _, plan := range catalog.Plans {
+		if plan.ID == "" {
+			return fmt.Errorf("plan without id")
+		}
+		if seen[plan.ID] {
+			return fmt.Errorf("duplicate plan id %s", plan.ID)
+		}
+		seen[plan.ID] = true
+
+		if plan.Kind != "" && plan.Kind != catalogTypes.PlanKindBase && plan.Kind != catalogTypes.PlanKindAddon {
+			return fmt.Errorf("plan %s has unknown kind %q", plan.ID, plan.Kind)
+		}
+		if plan.Tier < 0 {
+			return fmt.Errorf("plan %s has a negative tier", plan.ID)
+		}
+		// The effective plan is the held base plan with the highest tier, so ties would leave it to catalog order
+		if !plan.IsAddon() {
+			if other, ok := byTier[plan.Tier]; ok {
+				return fmt.Errorf("base plans %s and %s share tier %d, give each base plan its own tier", other.ID, plan.ID, plan.Tier)
+			}
+			byTier[plan.Tier] = plan
+		}
+
+		if plan.Grace != nil {
+			if err := validateGracePolicy(*plan.Grace); err != nil {
+				return fmt.Errorf("plan %s grace: %w", plan.ID, err)
+			}
+		}
+
+		switch plan.Billing {
+		case "", catalogTypes.BillingRecurring:
+			if plan.DurationDays != 0 {
+				return fmt.Errorf("plan %s sets duration_days but isn't one_time", plan.ID)
+			}
+		case catalogTypes.BillingOneTime:
+			if plan.DurationDays < 0 {
+				return fmt.Errorf("plan %s has a negative duration_days", plan.ID)
+			}
+		default:
+			return fmt.Errorf("plan %s has unknown billing %q", plan.ID, plan.Billing)
+		}
+
+		for _, priceID := range plan.PriceIDs {
+			if other, ok := byPrice[priceID]; ok {
+				return fmt.Errorf("price %s is mapped to both %s and %s", priceID, other.ID, plan.ID)
+			}
+			byPrice[priceID] = plan
+		}
+		for _, productID := range plan.ProductIDs {
+			if other, ok := byProduct[productID]; ok {
+				return fmt.Errorf("product %s is mapped to both %s and %s", productID, other.ID, plan.ID)
+			}
+			byProduct[productID] = plan
+		}
+	}
+
+	programs := map[string]*rules.Program{}
+	for feature, expression := range catalog.Rules {
+		program, err := rules.Compile(expression)
+		if err != nil {
+			return fmt.Errorf("rule for %s: %w", feature, err)
+		}
+		programs[feature] = program
+	}
+
+	meters := map[string]catalogTypes.Meter{}
+	for _, meter := range catalog.Meters {
+		if meter.EventName == "" {
+			return fmt.Errorf("meter without event_name")
+		}
+		if _, ok := meters[meter.EventName]; ok {
+			return fmt.Errorf("duplicate meter %s", meter.EventName)
+		}
+		if err := validateMeter(meter); err != nil {
+			return fmt.Errorf("meter %s: %w", meter.EventName, err)
+		}
+		meters[meter.EventName] = meter
+	}
+
+	packs := map[string]catalogTypes.CreditPack{}
+	packPrices := map[string]catalogTypes.CreditPack{}
+	for _, pack := range catalog.CreditPacks {
+		if pack.ID == "" {
+			return fmt.Errorf("credit pack without id")
+		}
+		if _, ok := packs[pack.ID]; ok {
+			return fmt.Errorf("duplicate credit pack id %s", pack.ID)
+		}
+		if pack.Credits <= 0 || pack.ExpiresInDays < 0 {
+			return fmt.Errorf("credit pack %s must grant positive credits with a non-negative expiry", pack.ID)
+		}
+		for _, priceID := range pack.PriceIDs {
+			if other, ok := packPrices[priceID]; ok {
+				return fmt.Errorf("price %s is mapped to both credit packs %s and %s", priceID, other.ID, pack.ID)
+			}
+			if plan, ok := byPrice[priceID]; ok {
+				return fmt.Errorf("price %s is mapped to both plan %s and credit pack %s", priceID, plan.ID, pack.ID)
+			}
+			packPrices[priceID] = pack
+		}
+		packs[pack.ID] = pack
+	}
+
+	if catalog.Grace != nil {
+		if err := validateGracePolicy(*catalog.Grace); err != nil {
+			return fmt.Errorf("grace: %w", err)
+		}
+	}
+
+	if catalog.Currency == "" {
+		catalog.Currency = defaultCurrency
+	}
+
+	current = catalog
+	plansByPrice = byPrice
+	plansByProduct = byProduct
+	compiledRules = programs
+	metersByEvent = meters
+	packsByID = packs
+	packsByPrice = packPrices
+	return nil
+}
+
+// validateGracePolicy checks that a grace policy names known statuses, in a single list each, and a known end action
+func validateGracePolicy(policy catalogTypes.GracePolicy) error {
+	if policy.Days < 0 {
+		return fmt.Errorf("negative days")
+	}
+	if policy.OnEnd != "" && policy.OnEnd != catalogTypes.GraceOnEndSuspend && policy.OnEnd != catalogTypes.GraceOnEndCancel {
+		return fmt.Errorf("unknown on_end %q", policy.OnEnd)
+	}
+	for _, status := range append(slices.Clone(policy.EntitledStatuses), policy.GraceStatuses...) {
+		if !slices.Contains(subscriptionStatuses, status) {
+			return fmt.Errorf("unknown subscription status %q", status)
+		}
+	}
+	for _, status := range policy.GraceStatuses {
+		if slices.Contains(policy.EntitledStatuses, status) {
+			return fmt.Errorf("status %s is both entitled and in grace", status)
+		}
+	}
+	return nil
+}
+
+// validateMeter checks that a meter's prices are positive and its tiers ascending, with only the last one unbounded
+func validateMeter(meter catalogTypes.Meter) error {
+	if meter.UnitAmount < 0 {
+		return fmt.Errorf("negative unit_amount")
+	}
+	if meter.TiersMode != "" && meter.TiersMode != catalogTypes.TiersModeGraduated && meter.TiersMode != catalogTypes.TiersModeVolume {
+		return fmt.Errorf("unknown tiers_mode %s", meter.TiersMode)
+	}
+	if len(meter.Tiers) > 0 && meter.UnitAmount != 0 {
+		return fmt.Errorf("unit_amount and tiers are mutually exclusive")
+	}
+
+	var previous int64
+	for i, tier := range meter.Tiers {
+		if tier.UnitAmount < 0 || tier.FlatAmount < 0 {
+			return fmt.Errorf("tier %d has a negative amount", i)
+		}
+		last := i == len(meter.Tiers)-1
+		if tier.UpTo == nil && !last {
+			return fmt.Errorf("only the last tier can have no up_to")
+		}
+		if tier.UpTo != nil && last {
+			return fmt.Errorf("the last tier must have no up_to")
+		}
+		if tier.UpTo != nil {
+			if *tier.UpTo <= previous {
+				return fmt.Errorf("tier %d up_to must be greater than %d", i, previous)
+			}
+			previous = *tier.UpTo
+		}
+	}
+
+	return nil
+}
+
+// Get returns the current plan catalog
+func Get() catalogTypes.Catalog {
+	return current
+}
+
+// Rules returns the compiled entitlement rules of the current catalog by feature
+func Rules() map[string]*rules.Program {
+	return compiledRules
+}
+
+// GetPlan returns the plan with the given ID
+func GetPlan(id string) (catalogTypes.Plan, bool) {
+	for _, plan := range current.Plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return catalogTypes.Plan{}, false
+}
+
+// GracePolicyFor returns the grace policy of subscriptions to the plan, or the catalog's default for unknown plans
+// Fields left empty fall back to access for active subscriptions only, grace for past_due ones and suspension when grace ends
+func GracePolicyFor(planID string) catalogTypes.GracePolicy {
+	policy := catalogTypes.GracePolicy{}
+	if current.Grace != nil {
+		policy = *current.Grace
+	}
+	if plan, ok := GetPlan(planID); ok && plan.Grace != nil {
+		policy = *plan.Grace
+	}
+
+	if len(policy.EntitledStatuses) == 0 {
+		policy.EntitledStatuses = []string{"active"}
+	}
+	if len(policy.GraceStatuses) == 0 && !slices.Contains(policy.EntitledStatuses, "past_due") {
+		policy.GraceStatuses = []string{"past_due"}
+	}
+	if policy.OnEnd == "" {
+		policy.OnEnd = catalogTypes.GraceOnEndSuspend
+	}
+	return policy
+}
+
+// GetMeter returns the pricing of the Stripe billing meter with the given event name
+func GetMeter(eventName string) (catalogTypes.Meter, bool) {
+	meter, ok := metersByEvent[eventName]
+	return meter, ok
+}
+
+// GetCreditPack returns the credit pack with the given ID
+func GetCreditPack(id string) (catalogTypes.CreditPack, bool) {
+	pack, ok := packsByID[id]
+	return pack, ok
+}
+
+// FindCreditPack returns the credit pack sold through a Stripe price
+func FindCreditPack(priceID string) (catalogTypes.CreditPack, bool) {
+	pack, ok := packsByPrice[priceID]
+	return pack, ok
+}
+
+// FindPlan returns the plan granted by a Stripe price or product
+// A plan mapped to the exact price wins over one mapped to the whole product
+func FindPlan(productID string, priceID string) (catalogTypes.Plan, bool) {
+	if plan, ok := plansByPrice[priceID]; ok {
+		return plan, true
+	}
+	plan, ok := plansByProduct[productID]
+	return plan, ok
+}
@@ -0,0 +1,217 @@
+package clerk
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"nucleus/catalog"
+	"nucleus/config"
+	"nucleus/entitlements"
+	"nucleus/mongodb"
+	"nucleus/types/cache"
+	entitlementsTypes "nucleus/types/entitlements"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+)
+
+// CachedEntitlements are the entitlements an owner was last published with, and the subscriptions they were
+// computed from, so hot paths like quota consumption don't read the metadata from Clerk on every call
+type CachedEntitlements struct {
+	Entitlements  entitlementsTypes.Entitlements
+	Subscriptions []map[string]interface{} // Active subscriptions mirrored in metadata
+}
+
+// entitlementsCache holds the published entitlements of each organization or user billed personally, keyed by owner ID
+// Entries are dropped when nucleus publishes new metadata or an organization.updated webhook arrives,
+// and expire after ENTITLEMENTS_CACHE_TTL
+var entitlementsCache *cache.LRUCache[CachedEntitlements]
+
+func init() {
+	entitlementsCache = cache.NewLRUCache[CachedEntitlements](
+		config.Int("ENTITLEMENTS_CACHE_SIZE", 10000),
+		config.Duration("ENTITLEMENTS_CACHE_TTL", time.Minute),
+	)
+}
+
+// GetCachedOwnerEntitlements returns the entitlements published in the owner's metadata, from the cache when possible
+// They're recomputed only for owners whose metadata predates published entitlements
+func GetCachedOwnerEntitlements(owner mongodbTypes.Organization) (CachedEntitlements, error) {
+	if cached, ok := entitlementsCache.Get(owner.OwnerID()); ok {
+		return cached, nil
+	}
+
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return CachedEntitlements{}, err
+	}
+
+	cached := CachedEntitlements{Subscriptions: activeSubscriptionsFromMetadata(metadata)}
+	if published, ok := publishedEntitlements(metadata); ok {
+		cached.Entitlements = published
+	} else {
+		cached.Entitlements = entitlements.Compute(entitlementsInputFromMetadata(owner, metadata))
+	}
+
+	entitlementsCache.Set(owner.OwnerID(), cached)
+	return cached, nil
+}
+
+// InvalidateEntitlements removes the cached entitlements of an organization or user
+func InvalidateEntitlements(ownerID string) {
+	entitlementsCache.Delete(ownerID)
+}
+
+// publishedEntitlements decodes the entitlements nucleus published in the metadata
+func publishedEntitlements(metadata map[string]interface{}) (entitlementsTypes.Entitlements, bool) {
+	raw, ok := metadata["entitlements"]
+	if !ok {
+		return entitlementsTypes.Entitlements{}, false
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return entitlementsTypes.Entitlements{}, false
+	}
+	var result entitlementsTypes.Entitlements
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Printf("Error decoding published entitlements: %v", err)
+		return entitlementsTypes.Entitlements{}, false
+	}
+	return result, true
+}
+
+// GetEntitlementsByOrganizationID computes the current entitlements of an organization from its metadata
+func GetEntitlementsByOrganizationID(organizationID string) entitlementsTypes.Entitlements {
+	input, err := GetEntitlementsInputByOrganizationID(organizationID)
+	if err != nil {
+		log.Printf("Error getting organization metadata: %v", err)
+		return entitlements.Compute(entitlements.Input{})
+	}
+
+	return entitlements.Compute(input)
+}
+
+// GetEntitlementsInputByOrganizationID collects everything an organization's entitlements are computed from
+func GetEntitlementsInputByOrganizationID(organizationID string) (entitlements.Input, error) {
+	metadata, err := GetOrganizationPublicMetadata(organizationID)
+	if err != nil {
+		return entitlements.Input{}, err
+	}
+
+	owner := mongodbTypes.Organization{OwnerType: mongodbTypes.OwnerTypeOrganization, ClerkID: organizationID}
+	return entitlementsInputFromMetadata(owner, metadata), nil
+}
+
+// GetEntitlementsByUserID computes the current entitlements of a user billed personally
+func GetEntitlementsByUserID(userID string) entitlementsTypes.Entitlements {
+	metadata, err := GetUserPublicMetadata(userID)
+	if err != nil {
+		log.Printf("Error getting user metadata: %v", err)
+		return entitlements.Compute(entitlements.Input{})
+	}
+
+	owner := mongodbTypes.Organization{OwnerType: mongodbTypes.OwnerTypeUser, ClerkUserID: userID}
+	return entitlements.Compute(entitlementsInputFromMetadata(owner, metadata))
+}
+
+// SetStripeFeaturesInOrganizationMetadata stores the lookup keys of the customer's active Stripe entitlements
+// in the local mapping and mirrors them into the owner's metadata, which recomputes the entitlements
+func SetStripeFeaturesInOrganizationMetadata(customerId string, features []string) {
+	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		log.Printf("Error getting organization: %v", err)
+		return
+	}
+
+	if err := mongodb.UpdateOrganizationStripeFeatures(customerId, features); err != nil {
+		log.Printf("Error storing Stripe features: %v", err)
+		return
+	}
+
+	metadata, err := getOwnerPublicMetadata(organization)
+	if err != nil {
+		log.Printf("Error getting user metadata: %v", err)
+		return
+	}
+
+	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
+		stripeData["features"] = features
+	} else {
+		metadata["stripe"] = map[string]interface{}{
+			"features": features,
+		}
+	}
+
+	if err := updateOwnerPublicMetadata(organization, metadata); err != nil {
+		log.Printf("Error updating metadata: %v", err)
+	}
+}
+
+// computedMetadataKeys are the metadata keys nucleus derives from the entitlements, hidden from rules so they can't
+// read their own previous output and never settle
+var computedMetadataKeys = []string{"entitlements", "billing", "access_holds"}
+
+// entitlementsInputFromMetadata collects everything entitlements are computed from out of the owner's metadata
+// Users billed personally have no members to count, but can own one-time purchases and overrides
+func entitlementsInputFromMetadata(owner mongodbTypes.Organization, metadata map[string]interface{}) entitlements.Input {
+	ruleMetadata := maps.Clone(metadata)
+	for _, key := range computedMetadataKeys {
+		delete(ruleMetadata, key)
+	}
+
+	input := entitlements.Input{
+		Subscriptions: activeSubscriptionsFromMetadata(metadata),
+		Usage:         map[string]int64{},
+		Metadata:      ruleMetadata,
+	}
+
+	organizationID := ""
+	if !owner.IsUser() {
+		organizationID = owner.ClerkID
+	}
+
+	if owner.OwnerID() != "" {
+		purchases, err := mongodb.ListActivePurchases(owner.OwnerID())
+		if err != nil {
+			log.Printf("Error getting purchases: %v", err)
+		} else {
+			input.Purchases = purchases
+		}
+
+		holds, err := mongodb.ListActiveAccessHolds(owner.OwnerID())
+		if err != nil {
+			log.Printf("Error getting access holds: %v", err)
+		} else {
+			input.Holds = holds
+		}
+
+		overrides, err := mongodb.ListActiveEntitlementOverrides(owner)
+		if err != nil {
+			log.Printf("Error getting entitlement overrides: %v", err)
+		} else {
+			input.Overrides = overrides
+		}
+	}
+
+	// Usage is only read by rules, skip the extra Clerk call when there are none
+	if organizationID != "" && len(catalog.Rules()) > 0 {
+		count, err := GetOrganizationMembersCount(organizationID)
+		if err != nil {
+			log.Printf("Error getting organization members count: %v", err)
+		} else {
+			input.Usage[entitlements.FeatureSeats] = count
+		}
+	}
+
+	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
+		if features, ok := stripeData["features"].([]interface{}); ok {
+			for _, feature := range features {
+				if lookupKey, ok := feature.(string); ok {
+					input.StripeFeatures = append(input.StripeFeatures, lookupKey)
+				}
+			}
+		}
+	}
+
+	return input
+}
@@ -2,6 +2,7 @@ package clerk
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"nucleus/mongodb"
 	"os"
@@ -48,7 +49,17 @@ func HandleOrganizationCreated(event *ClerkWebhookEvent) error {
 	return nil
 }
 
+// HandleOrganizationUpdated drops the cached memberships that embed the organization and its cached entitlements
+// so they don't serve stale data
 func HandleOrganizationUpdated(event *ClerkWebhookEvent) error {
+	var organization clerk.Organization
+	err := json.Unmarshal(event.Data, &organization)
+	if err != nil {
+		return err
+	}
+
+	InvalidateOrganizationMemberships(organization.ID)
+	InvalidateEntitlements(organization.ID)
 	return nil
 }
 
@@ -64,6 +75,13 @@ func HandleOrganizationDeleted(event *ClerkWebhookEvent) error {
 		return err
 	}
 
+	InvalidateOrganizationMemberships(organizationId)
+	InvalidateEntitlements(organizationId)
+
+	if _, err := mongodb.RevokeAccessTokens(organizationId, ""); err != nil {
+		return err
+	}
+
 	organization, err := mongodb.GetOrganizationByClerkID(organizationId)
 	if err != nil {
 		return err
@@ -81,3 +99,116 @@ func HandleOrganizationDeleted(event *ClerkWebhookEvent) error {
 
 	return nil
 }
+
+// HandleOrganizationMembershipChanged drops the cached memberships of the user whose membership was created, updated or deleted
+// A user leaving the organization also loses the access tokens they created for it
+func HandleOrganizationMembershipChanged(event *ClerkWebhookEvent) error {
+	var membership clerk.OrganizationMembership
+	err := json.Unmarshal(event.Data, &membership)
+	if err != nil {
+		return err
+	}
+
+	if membership.PublicUserData == nil || membership.PublicUserData.UserID == "" {
+		return fmt.Errorf("%s event without user id", event.Type)
+	}
+
+	InvalidateUserMemberships(membership.PublicUserData.UserID)
+
+	if event.Type == "organizationMembership.deleted" && membership.Organization != nil {
+		if _, err := mongodb.RevokeAccessTokens(membership.Organization.ID, membership.PublicUserData.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleUserCreated creates a Stripe customer for the user so they can subscribe personally
+func HandleUserCreated(event *ClerkWebhookEvent) error {
+	var user clerk.User
+	err := json.Unmarshal(event.Data, &user)
+	if err != nil {
+		return err
+	}
+
+	params := &stripe.CustomerParams{
+		Name: stripe.String(userDisplayName(&user)),
+	}
+	if email := userPrimaryEmail(&user); email != "" {
+		params.Email = stripe.String(email)
+	}
+
+	customer, err := customer.New(params)
+	if err != nil {
+		return err
+	}
+
+	err = mongodb.CreateUserSync(user.ID, customer.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HandleUserDeleted deletes the user's personal Stripe customer and its mapping
+func HandleUserDeleted(event *ClerkWebhookEvent) error {
+	var eventData map[string]interface{}
+	err := json.Unmarshal(event.Data, &eventData)
+	if err != nil {
+		return err
+	}
+
+	userId, ok := eventData["id"].(string)
+	if !ok {
+		return fmt.Errorf("user.deleted event without user id")
+	}
+
+	organization, err := mongodb.GetOrganizationByClerkUserID(userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = customer.Del(organization.StripeCustomerID, nil)
+	if err != nil {
+		return err
+	}
+
+	err = mongodb.DeleteOrganizationByClerkUserID(userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// userPrimaryEmail returns the primary email address of the user, if any
+func userPrimaryEmail(user *clerk.User) string {
+	for _, email := range user.EmailAddresses {
+		if user.PrimaryEmailAddressID != nil && email.ID == *user.PrimaryEmailAddressID {
+			return email.EmailAddress
+		}
+	}
+	return ""
+}
+
+// userDisplayName returns the best available human readable name for the user
+func userDisplayName(user *clerk.User) string {
+	var name string
+	if user.FirstName != nil {
+		name = *user.FirstName
+	}
+	if user.LastName != nil && *user.LastName != "" {
+		if name != "" {
+			name += " "
+		}
+		name += *user.LastName
+	}
+	if name == "" {
+		name = userPrimaryEmail(user)
+	}
+	if name == "" {
+		name = user.ID
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+package clerk
+
+import (
+	"nucleus/config"
+	"nucleus/types/cache"
+	"time"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+)
+
+// membershipCache holds the organization memberships of each user, keyed by user ID
+// Entries are invalidated by organizationMembership.* and organization.* webhooks and expire after MEMBERSHIP_CACHE_TTL
+var membershipCache *cache.LRUCache[*clerk.OrganizationMembershipList]
+
+// membershipFetches marks the users whose memberships were fetched from Clerk in the last MEMBERSHIP_NEGATIVE_CACHE_TTL
+// Lookups of an organization missing from those memberships are answered negatively without asking Clerk again,
+// so requests with a bogus organization can't bypass the cache
+var membershipFetches *cache.LRUCache[bool]
+
+func init() {
+	membershipCache = cache.NewLRUCache[*clerk.OrganizationMembershipList](
+		config.Int("MEMBERSHIP_CACHE_SIZE", 10000),
+		config.Duration("MEMBERSHIP_CACHE_TTL", 5*time.Minute),
+	)
+	membershipFetches = cache.NewLRUCache[bool](
+		config.Int("MEMBERSHIP_CACHE_SIZE", 10000),
+		config.Duration("MEMBERSHIP_NEGATIVE_CACHE_TTL", 30*time.Second),
+	)
+}
+
+// GetMembershipCacheStats returns the size and hit rate of the organization membership cache
+func GetMembershipCacheStats() cache.CacheStats {
+	return membershipCache.Stats()
+}
+
+// InvalidateUserMemberships removes the cached memberships of a user
+func InvalidateUserMemberships(userId string) {
+	membershipCache.Delete(userId)
+	membershipFetches.Delete(userId)
+}
+
+// InvalidateOrganizationMemberships removes the cached memberships of every user that belongs to the organization
+func InvalidateOrganizationMemberships(organizationId string) {
+	membershipCache.DeleteFunc(func(userId string, memberships *clerk.OrganizationMembershipList) bool {
+		for _, membership := range memberships.OrganizationMemberships {
+			if membership.Organization != nil && membership.Organization.ID == organizationId {
+				membershipFetches.Delete(userId)
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -3,35 +3,122 @@ package clerk
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"nucleus/mongodb"
 	"os"
 
 	"github.com/clerk/clerk-sdk-go/v2"
 	"github.com/clerk/clerk-sdk-go/v2/organization"
+	"github.com/clerk/clerk-sdk-go/v2/organizationmembership"
 	"github.com/clerk/clerk-sdk-go/v2/user"
 )
 
+// ErrNoOrganization is returned when a user does not belong to any organization
+var ErrNoOrganization = errors.New("user has no organization memberships")
+
+// ErrNotOrganizationMember is returned when a user is not a member of the requested organization
+var ErrNotOrganizationMember = errors.New("user is not a member of the organization")
+
+// maxOrganizationMemberships is the page size used when listing memberships
+const maxOrganizationMemberships = 100
+
+// adminRole is the Clerk role of organization admins
+const adminRole = "org:admin"
+
 func init() {
 	clerkAPIKey := os.Getenv("CLERK_SECRET_KEY")
 	clerk.SetKey(clerkAPIKey)
 }
 
+// GetUserOrganizations returns the organization memberships of the user, served from the membership cache when possible
 func GetUserOrganizations(userId string) (*clerk.OrganizationMembershipList, error) {
-	orgMemberships, err := user.ListOrganizationMemberships(context.Background(), userId, &user.ListOrganizationMembershipsParams{})
+	if orgMemberships, ok := membershipCache.Get(userId); ok {
+		return orgMemberships, nil
+	}
 
+	orgMemberships, err := listAllMemberships(func(params clerk.ListParams) (*clerk.OrganizationMembershipList, error) {
+		return user.ListOrganizationMemberships(context.Background(), userId, &user.ListOrganizationMembershipsParams{ListParams: params})
+	})
 	if err != nil {
 		log.Printf("Error getting organization memberships: %v", err)
+		return nil, err
 	}
 
+	membershipCache.Set(userId, orgMemberships)
+	membershipFetches.Set(userId, true)
 	return orgMemberships, nil
 }
 
+// listAllMemberships pages through a membership listing until every membership was fetched
+func listAllMemberships(list func(params clerk.ListParams) (*clerk.OrganizationMembershipList, error)) (*clerk.OrganizationMembershipList, error) {
+	result := &clerk.OrganizationMembershipList{}
+	for offset := int64(0); ; offset += maxOrganizationMemberships {
+		page, err := list(clerk.ListParams{Limit: clerk.Int64(maxOrganizationMemberships), Offset: clerk.Int64(offset)})
+		if err != nil {
+			return nil, err
+		}
+
+		result.OrganizationMemberships = append(result.OrganizationMemberships, page.OrganizationMemberships...)
+		result.TotalCount = page.TotalCount
+		if len(page.OrganizationMemberships) < maxOrganizationMemberships || int64(len(result.OrganizationMemberships)) >= page.TotalCount {
+			return result, nil
+		}
+	}
+}
+
 func GetUserOrganizationId(userId string) (string, error) {
-	orgMemberships, err := GetUserOrganizations(userId)
+	membership, err := GetUserDefaultOrganizationMembership(userId)
 	if err != nil {
 		return "", err
 	}
-	return orgMemberships.OrganizationMemberships[0].Organization.ID, nil
+	return membership.Organization.ID, nil
+}
+
+// GetUserDefaultOrganizationMembership returns the membership used when the user didn't select an organization
+// It returns ErrNoOrganization if the user doesn't belong to any organization
+func GetUserDefaultOrganizationMembership(userId string) (*clerk.OrganizationMembership, error) {
+	orgMemberships, err := GetUserOrganizations(userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgMemberships.OrganizationMemberships) == 0 {
+		return nil, ErrNoOrganization
+	}
+	return orgMemberships.OrganizationMemberships[0], nil
+}
+
+// GetUserOrganizationMembership returns the user's membership in the given organization
+// It returns ErrNotOrganizationMember if the user doesn't belong to it
+// Memberships fetched from Clerk recently are trusted for negative answers, older ones are refetched once in case
+// the user joined after they were cached and before the webhook arrived
+func GetUserOrganizationMembership(userId string, organizationId string) (*clerk.OrganizationMembership, error) {
+	membership, err := findUserOrganizationMembership(userId, organizationId)
+	if errors.Is(err, ErrNotOrganizationMember) {
+		if _, recent := membershipFetches.Get(userId); recent {
+			return nil, err
+		}
+		InvalidateUserMemberships(userId)
+		return findUserOrganizationMembership(userId, organizationId)
+	}
+
+	return membership, err
+}
+
+// findUserOrganizationMembership looks up the organization in the user's memberships
+func findUserOrganizationMembership(userId string, organizationId string) (*clerk.OrganizationMembership, error) {
+	orgMemberships, err := GetUserOrganizations(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, membership := range orgMemberships.OrganizationMemberships {
+		if membership.Organization != nil && membership.Organization.ID == organizationId {
+			return membership, nil
+		}
+	}
+
+	return nil, ErrNotOrganizationMember
 }
 
 func GetOrganizationPublicMetadata(organizationId string) (map[string]interface{}, error) {
@@ -48,6 +135,73 @@ func GetOrganizationPublicMetadata(organizationId string) (map[string]interface{
 	return metadata, nil
 }
 
+// GetOwnerAdminEmails returns the primary email addresses of whoever manages the billing of an organization or user:
+// the organization's admins, or the user billed personally
+func GetOwnerAdminEmails(ownerID string) ([]string, error) {
+	owner, err := mongodb.GetOrganizationByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !owner.IsUser() {
+		return GetOrganizationAdminEmails(owner.ClerkID)
+	}
+
+	user, err := user.Get(context.Background(), owner.ClerkUserID)
+	if err != nil {
+		return nil, err
+	}
+	if email := userPrimaryEmail(user); email != "" {
+		return []string{email}, nil
+	}
+	return []string{}, nil
+}
+
+// GetOrganizationAdminEmails returns the primary email addresses of the organization's admins
+func GetOrganizationAdminEmails(organizationId string) ([]string, error) {
+	memberships, err := listAllMemberships(func(params clerk.ListParams) (*clerk.OrganizationMembershipList, error) {
+		return organizationmembership.List(context.Background(), &organizationmembership.ListParams{
+			OrganizationID: organizationId,
+			Roles:          []string{adminRole},
+			ListParams:     params,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	emails := []string{}
+	for _, membership := range memberships.OrganizationMemberships {
+		if membership.PublicUserData == nil {
+			continue
+		}
+		admin, err := user.Get(context.Background(), membership.PublicUserData.UserID)
+		if err != nil {
+			log.Printf("Error getting organization admin %s: %v", membership.PublicUserData.UserID, err)
+			continue
+		}
+		if email := userPrimaryEmail(admin); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, nil
+}
+
+// GetOrganizationMembersCount returns the number of members of the organization
+func GetOrganizationMembersCount(organizationId string) (int64, error) {
+	organization, err := organization.GetWithParams(context.Background(), organizationId, &organization.GetParams{
+		IncludeMembersCount: clerk.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if organization.MembersCount == nil {
+		return 0, nil
+	}
+	return *organization.MembersCount, nil
+}
+
 func UpdateOrganizationPublicMetadata(organizationId string, metadata map[string]interface{}) error {
 	jsonData, err := json.Marshal(metadata)
 	if err != nil {
@@ -0,0 +1,66 @@
+package clerk
+
+import (
+	"log"
+	"nucleus/config"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+)
+
+// RefreshOwnerEntitlements recomputes the entitlements of an organization or a user billed personally and publishes them
+// in its metadata, used when something they depend on changes outside of a Stripe webhook, like an override
+func RefreshOwnerEntitlements(owner mongodbTypes.Organization) error {
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return err
+	}
+
+	return updateOwnerPublicMetadata(owner, metadata)
+}
+
+// StartOverrideExpirySweeper periodically recomputes the entitlements of organizations whose overrides expired,
+// so expired grants disappear from the metadata without waiting for the next webhook
+func StartOverrideExpirySweeper() {
+	interval := config.Duration("OVERRIDE_SWEEP_INTERVAL", 5*time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredOverrides()
+		}
+	}()
+}
+
+// sweepExpiredOverrides refreshes every owner with an override that expired since the last sweep
+func sweepExpiredOverrides() {
+	overrides, err := mongodb.ListUnappliedExpiredOverrides()
+	if err != nil {
+		log.Printf("Error listing expired overrides: %v", err)
+		return
+	}
+
+	refreshed := map[string]error{}
+	for _, override := range overrides {
+		ownerID := override.OwnerID()
+		err, done := refreshed[ownerID]
+		if !done {
+			err = RefreshOwnerEntitlements(override.Owner())
+			refreshed[ownerID] = err
+			if err != nil {
+				log.Printf("Error refreshing entitlements of owner %s: %v", ownerID, err)
+			} else {
+				log.Printf("[CLERK] Refreshed entitlements of owner %s after override %s expired", ownerID, override.ID.Hex())
+			}
+		}
+
+		// Failed refreshes are retried on the next sweep
+		if err == nil {
+			if err := mongodb.MarkOverrideExpiryApplied(override.ID); err != nil {
+				log.Printf("Error marking override %s as applied: %v", override.ID.Hex(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package clerk
+
+import (
+	"errors"
+	"log"
+	"nucleus/config"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func init() {
+	if err := mongodb.EnsurePurchaseIndexes(); err != nil {
+		log.Fatalf("Error creating purchase indexes: %v", err)
+	}
+}
+
+// AddPurchasesToOwner records the one-time purchases of a customer and republishes its owner's entitlements
+// Purchases already recorded for the same payment are ignored, so redelivered webhooks grant nothing twice
+// A pass bought while the owner still holds one of the same plan extends it: its term starts when the running pass expires
+func AddPurchasesToOwner(customerId string, purchases []mongodbTypes.Purchase) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	for _, purchase := range purchases {
+		purchase.OwnerID = owner.OwnerID()
+		purchase.StripeCustomerID = customerId
+
+		if purchase.ExpiresAt != nil {
+			if err := extendRunningPass(&purchase); err != nil {
+				return err
+			}
+		}
+
+		ok, err := mongodb.CreatePurchase(purchase)
+		if err != nil {
+			return err
+		}
+		if ok {
+			created++
+		}
+	}
+	if created == 0 {
+		return nil
+	}
+
+	return RefreshOwnerEntitlements(owner)
+}
+
+// extendRunningPass moves the term of a pass after the running pass of the same plan, if the owner holds one
+func extendRunningPass(purchase *mongodbTypes.Purchase) error {
+	running, err := mongodb.GetLastRunningPass(purchase.OwnerID, purchase.PlanID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	term := purchase.ExpiresAt.Sub(purchase.PurchasedAt)
+	startsAt := *running.ExpiresAt
+	expiresAt := startsAt.Add(term)
+	purchase.StartsAt = &startsAt
+	purchase.ExpiresAt = &expiresAt
+
+	return nil
+}
+
+// RefundPurchases revokes the one-time purchases paid with the payment intent and republishes their owners' entitlements
+func RefundPurchases(paymentIntentID string) error {
+	purchases, err := mongodb.RefundPurchasesByPaymentIntent(paymentIntentID)
+	if err != nil {
+		return err
+	}
+
+	refreshed := map[string]bool{}
+	for _, purchase := range purchases {
+		if refreshed[purchase.StripeCustomerID] {
+			continue
+		}
+		refreshed[purchase.StripeCustomerID] = true
+
+		if err := refreshPurchaseOwner(purchase.StripeCustomerID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartPurchaseExpirySweeper periodically recomputes the entitlements of owners whose fixed-term purchases expired or,
+// for passes that extend a running one, started
+func StartPurchaseExpirySweeper() {
+	interval := config.Duration("PURCHASE_SWEEP_INTERVAL", 5*time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredPurchases()
+		}
+	}()
+}
+
+// sweepExpiredPurchases refreshes every owner with a purchase that expired or started since the last sweep
+func sweepExpiredPurchases() {
+	expired, err := mongodb.ListUnappliedExpiredPurchases()
+	if err != nil {
+		log.Printf("Error listing expired purchases: %v", err)
+		return
+	}
+	started, err := mongodb.ListUnappliedStartedPurchases()
+	if err != nil {
+		log.Printf("Error listing started purchases: %v", err)
+		return
+	}
+
+	refreshed := map[string]error{}
+	for _, purchase := range expired {
+		if refreshSweptOwner(refreshed, purchase, "expired") == nil {
+			if err := mongodb.MarkPurchaseExpiryApplied(purchase.ID); err != nil {
+				log.Printf("Error marking purchase %s as applied: %v", purchase.ID.Hex(), err)
+			}
+		}
+	}
+	for _, purchase := range started {
+		if refreshSweptOwner(refreshed, purchase, "started") == nil {
+			if err := mongodb.MarkPurchaseStartApplied(purchase.ID); err != nil {
+				log.Printf("Error marking purchase %s as applied: %v", purchase.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// refreshSweptOwner refreshes the owner of a swept purchase once per sweep and returns the outcome
+// Failed refreshes are retried on the next sweep, since the purchase isn't marked as applied
+func refreshSweptOwner(refreshed map[string]error, purchase mongodbTypes.Purchase, event string) error {
+	err, done := refreshed[purchase.StripeCustomerID]
+	if done {
+		return err
+	}
+
+	err = refreshPurchaseOwner(purchase.StripeCustomerID)
+	refreshed[purchase.StripeCustomerID] = err
+	if err != nil {
+		log.Printf("Error refreshing entitlements of customer %s: %v", purchase.StripeCustomerID, err)
+	} else {
+		log.Printf("[CLERK] Refreshed entitlements of %s after purchase %s %s", purchase.OwnerID, purchase.ID.Hex(), event)
+	}
+	return err
+}
+
+// refreshPurchaseOwner republishes the entitlements of the customer's owner
+func refreshPurchaseOwner(customerId string) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+	return RefreshOwnerEntitlements(owner)
+}
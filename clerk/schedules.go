@@ -0,0 +1,107 @@
+package clerk
+
+import (
+	"nucleus/entitlements"
+	"nucleus/mongodb"
+
+	"github.com/stripe/stripe-go/v82"
+)
+
+// UpdateSubscriptionScheduleInOwnerMetadata mirrors the schedule attached to a subscription, or removes it when schedule is nil
+func UpdateSubscriptionScheduleInOwnerMetadata(customerId string, subscriptionID string, schedule map[string]interface{}) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range metadataSubscriptions(metadata) {
+		if subscription["id"] != subscriptionID {
+			continue
+		}
+		if schedule == nil {
+			if _, ok := subscription["schedule"]; !ok {
+				return nil
+			}
+			delete(subscription, "schedule")
+		} else {
+			subscription["schedule"] = schedule
+		}
+		return updateOwnerPublicMetadata(owner, metadata)
+	}
+
+	return nil
+}
+
+// SubscriptionScheduleMetadata returns an active schedule as it's mirrored in its subscription's metadata
+// Only the next phase is kept, which is what the UI needs to announce an upcoming plan change, and the phase items'
+// prices must be expanded so they map to catalog plans by product as well as by price
+func SubscriptionScheduleMetadata(schedule *stripe.SubscriptionSchedule) map[string]interface{} {
+	info := map[string]interface{}{
+		"id":           schedule.ID,
+		"end_behavior": string(schedule.EndBehavior),
+	}
+
+	if schedule.CurrentPhase == nil {
+		return info
+	}
+	info["current_phase_end"] = schedule.CurrentPhase.EndDate
+
+	for _, phase := range schedule.Phases {
+		if phase.StartDate < schedule.CurrentPhase.EndDate {
+			continue
+		}
+		items := scheduledItemsMetadata(phase.Items)
+		info["next_phase"] = map[string]interface{}{
+			"start_date": phase.StartDate,
+			"plan_id":    entitlements.SubscriptionPlanID(map[string]interface{}{"items": items}),
+			"items":      items,
+		}
+		break
+	}
+
+	return info
+}
+
+// pendingUpdateMetadata returns the changes of a subscription update waiting for its payment, as mirrored in metadata
+func pendingUpdateMetadata(update *stripe.SubscriptionPendingUpdate) map[string]interface{} {
+	items := []interface{}{}
+	for _, item := range update.SubscriptionItems {
+		items = append(items, scheduledItemMetadata(item.Price, item.Quantity))
+	}
+
+	return map[string]interface{}{
+		"expires_at": update.ExpiresAt,
+		"plan_id":    entitlements.SubscriptionPlanID(map[string]interface{}{"items": items}),
+		"items":      items,
+	}
+}
+
+// scheduledItemsMetadata returns the items of a schedule phase in the shape of mirrored subscription items
+func scheduledItemsMetadata(phaseItems []*stripe.SubscriptionSchedulePhaseItem) []interface{} {
+	items := []interface{}{}
+	for _, item := range phaseItems {
+		items = append(items, scheduledItemMetadata(item.Price, item.Quantity))
+	}
+	return items
+}
+
+// scheduledItemMetadata returns a price and quantity that will apply later in the shape of a mirrored subscription item
+func scheduledItemMetadata(price *stripe.Price, quantity int64) map[string]interface{} {
+	item := map[string]interface{}{
+		"product_id": "",
+		"price_id":   "",
+		"quantity":   quantity,
+	}
+	if price != nil {
+		item["price_id"] = price.ID
+		if price.Product != nil {
+			item["product_id"] = price.Product.ID
+		}
+	}
+	return item
+}
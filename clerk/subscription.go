@@ -1,8 +1,15 @@
 package clerk
 
 import (
+	"encoding/json"
 	"log"
+	"maps"
+	"nucleus/catalog"
+	"nucleus/entitlements"
 	"nucleus/mongodb"
+	entitlementsTypes "nucleus/types/entitlements"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
 
 	"time"
 
@@ -10,48 +17,32 @@ import (
 )
 
 // AddSubscriptionToOrganizationMetadata adds subscription information to user metadata
-func AddSubscriptionToOrganizationMetadata(customerId string, subscription *stripe.Subscription) {
+// It returns the entitlements computed for the new metadata, false if nothing was written
+func AddSubscriptionToOrganizationMetadata(customerId string, subscription *stripe.Subscription) (entitlementsTypes.Entitlements, bool) {
 	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
 	if err != nil {
 		log.Printf("Error getting organization: %v", err)
-		return
+		return entitlementsTypes.Entitlements{}, false
 	}
 
-	metadata, err := GetOrganizationPublicMetadata(organization.ClerkID)
+	metadata, err := getOwnerPublicMetadata(organization)
 	if err != nil {
 		log.Printf("Error getting user metadata: %v", err)
-		return
+		return entitlementsTypes.Entitlements{}, false
 	}
 
-	// Get current period end from subscription items
-	var currentPeriodEnd int64
-	if len(subscription.Items.Data) > 0 {
-		currentPeriodEnd = subscription.Items.Data[0].CurrentPeriodEnd
-	}
-
-	// Create subscription info
-	subscriptionInfo := map[string]interface{}{
-		"id":                 subscription.ID,
-		"status":             subscription.Status,
-		"current_period_end": currentPeriodEnd,
-		"product_id":         subscription.Items.Data[0].Price.Product.ID,
-		"price_id":           subscription.Items.Data[0].Price.ID,
-	}
+	subscriptionInfo := subscriptionMetadata(subscription, nil)
 
 	// Initialize stripe data if it doesn't exist
 	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
 		if subscriptions, ok := stripeData["subscriptions"].([]interface{}); ok {
 			// Check if subscription already exists
-			for _, sub := range subscriptions {
+			for i, sub := range subscriptions {
 				if subMap, ok := sub.(map[string]interface{}); ok {
 					if subMap["id"] == subscription.ID {
 						// Update existing subscription
-						subMap["status"] = subscription.Status
-						subMap["current_period_end"] = currentPeriodEnd
-						subMap["product_id"] = subscription.Items.Data[0].Price.Product.ID
-						subMap["price_id"] = subscription.Items.Data[0].Price.ID
-						UpdateOrganizationPublicMetadata(organization.ClerkID, metadata)
-						return
+						subscriptions[i] = subscriptionMetadata(subscription, subMap)
+						return publishSubscriptions(organization, metadata)
 					}
 				}
 			}
@@ -66,27 +57,22 @@ func AddSubscriptionToOrganizationMetadata(customerId string, subscription *stri
 		}
 	}
 
-	UpdateOrganizationPublicMetadata(organization.ClerkID, metadata)
+	return publishSubscriptions(organization, metadata)
 }
 
 // UpdateSubscriptionInOrganizationMetadata updates existing subscription information
-func UpdateSubscriptionInOrganizationMetadata(customerId string, subscription *stripe.Subscription) {
+// It returns the entitlements computed for the new metadata, false if nothing was written
+func UpdateSubscriptionInOrganizationMetadata(customerId string, subscription *stripe.Subscription) (entitlementsTypes.Entitlements, bool) {
 	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
 	if err != nil {
 		log.Printf("Error getting organization: %v", err)
-		return
+		return entitlementsTypes.Entitlements{}, false
 	}
 
-	metadata, err := GetOrganizationPublicMetadata(organization.ClerkID)
+	metadata, err := getOwnerPublicMetadata(organization)
 	if err != nil {
 		log.Printf("Error getting user metadata: %v", err)
-		return
-	}
-
-	// Get current period end from subscription items
-	var currentPeriodEnd int64
-	if len(subscription.Items.Data) > 0 {
-		currentPeriodEnd = subscription.Items.Data[0].CurrentPeriodEnd
+		return entitlementsTypes.Entitlements{}, false
 	}
 
 	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
@@ -95,20 +81,101 @@ func UpdateSubscriptionInOrganizationMetadata(customerId string, subscription *s
 				if subMap, ok := sub.(map[string]interface{}); ok {
 					if subMap["id"] == subscription.ID {
 						// Update subscription info
-						subscriptions[i] = map[string]interface{}{
-							"id":                 subscription.ID,
-							"status":             subscription.Status,
-							"current_period_end": currentPeriodEnd,
-							"product_id":         subscription.Items.Data[0].Price.Product.ID,
-							"price_id":           subscription.Items.Data[0].Price.ID,
-						}
-						UpdateOrganizationPublicMetadata(organization.ClerkID, metadata)
-						return
+						subscriptions[i] = subscriptionMetadata(subscription, subMap)
+						return publishSubscriptions(organization, metadata)
 					}
 				}
 			}
 		}
 	}
+
+	return entitlementsTypes.Entitlements{}, false
+}
+
+// publishSubscriptions writes the owner's metadata after its subscriptions changed and returns the entitlements computed for it,
+// so callers can act on them without reading the metadata back from Clerk
+func publishSubscriptions(owner mongodbTypes.Organization, metadata map[string]interface{}) (entitlementsTypes.Entitlements, bool) {
+	if err := updateOwnerPublicMetadata(owner, metadata); err != nil {
+		log.Printf("Error updating metadata of %s: %v", owner.OwnerID(), err)
+		return entitlementsTypes.Entitlements{}, false
+	}
+	return publishedEntitlements(metadata)
+}
+
+// subscriptionMetadata returns the subscription as it's mirrored in metadata, with every item's price, product,
+// quantity and period. The top-level price, product, quantity and period are those of the base plan item
+// (the first item when none maps to a base plan), so consumers reading a single plan keep working
+// past_due_since is when the subscription stopped being paid, carried over from the previous mirror while it stays unpaid
+// Pending cancellations and updates are mirrored too, and the schedule mirrored from subscription_schedule events is
+// carried over while the same schedule stays attached
+func subscriptionMetadata(subscription *stripe.Subscription, previous map[string]interface{}) map[string]interface{} {
+	info := map[string]interface{}{
+		"id":                   subscription.ID,
+		"status":               string(subscription.Status),
+		"current_period_start": int64(0),
+		"current_period_end":   int64(0),
+		"product_id":           "",
+		"price_id":             "",
+		"quantity":             int64(0),
+	}
+
+	items := []interface{}{}
+	var baseItem map[string]interface{}
+	if subscription.Items != nil {
+		for _, item := range subscription.Items.Data {
+			itemInfo := map[string]interface{}{
+				"id":                   item.ID,
+				"product_id":           "",
+				"price_id":             "",
+				"quantity":             item.Quantity,
+				"current_period_start": item.CurrentPeriodStart,
+				"current_period_end":   item.CurrentPeriodEnd,
+			}
+			if item.Price != nil {
+				itemInfo["price_id"] = item.Price.ID
+				if item.Price.Product != nil {
+					itemInfo["product_id"] = item.Price.Product.ID
+				}
+			}
+			items = append(items, itemInfo)
+
+			plan, ok := catalog.FindPlan(itemInfo["product_id"].(string), itemInfo["price_id"].(string))
+			if baseItem == nil && ok && !plan.IsAddon() {
+				baseItem = itemInfo
+			}
+		}
+	}
+	if baseItem == nil && len(items) > 0 {
+		baseItem = items[0].(map[string]interface{})
+	}
+	if baseItem != nil {
+		for _, key := range []string{"current_period_start", "current_period_end", "product_id", "price_id", "quantity"} {
+			info[key] = baseItem[key]
+		}
+	}
+	info["items"] = items
+
+	if subscription.Status == stripe.SubscriptionStatusPastDue || subscription.Status == stripe.SubscriptionStatusUnpaid {
+		info["past_due_since"] = time.Now().Unix()
+		if since, ok := entitlements.MetadataInt(previous["past_due_since"]); ok {
+			info["past_due_since"] = since
+		}
+	}
+
+	if subscription.CancelAtPeriodEnd {
+		info["cancel_at_period_end"] = true
+	}
+	if subscription.CancelAt > 0 {
+		info["cancel_at"] = subscription.CancelAt
+	}
+	if subscription.PendingUpdate != nil {
+		info["pending_update"] = pendingUpdateMetadata(subscription.PendingUpdate)
+	}
+	if schedule, ok := previous["schedule"].(map[string]interface{}); ok && subscription.Schedule != nil && schedule["id"] == subscription.Schedule.ID {
+		info["schedule"] = schedule
+	}
+
+	return info
 }
 
 // RemoveSubscriptionFromOrganizationMetadata removes a subscription from user metadata
@@ -119,7 +186,7 @@ func RemoveSubscriptionFromOrganizationMetadata(customerId string, subscriptionI
 		return
 	}
 
-	metadata, err := GetOrganizationPublicMetadata(organization.ClerkID)
+	metadata, err := getOwnerPublicMetadata(organization)
 	if err != nil {
 		log.Printf("Error getting user metadata: %v", err)
 		return
@@ -136,7 +203,7 @@ func RemoveSubscriptionFromOrganizationMetadata(customerId string, subscriptionI
 				}
 			}
 			stripeData["subscriptions"] = updatedSubscriptions
-			UpdateOrganizationPublicMetadata(organization.ClerkID, metadata)
+			updateOwnerPublicMetadata(organization, metadata)
 		}
 	}
 }
@@ -149,28 +216,70 @@ func GetActiveSubscriptionsByCustomerID(customerId string) []map[string]interfac
 		return nil
 	}
 
-	metadata, err := GetOrganizationPublicMetadata(organization.ClerkID)
+	metadata, err := getOwnerPublicMetadata(organization)
+	if err != nil {
+		log.Printf("Error getting user metadata: %v", err)
+		return nil
+	}
+
+	return activeSubscriptionsFromMetadata(metadata)
+}
+
+// GetActiveSubscriptions returns all active subscriptions for a organization
+func GetActiveSubscriptionsByOrganizationID(organizationID string) []map[string]interface{} {
+
+	metadata, err := GetOrganizationPublicMetadata(organizationID)
 	if err != nil {
 		log.Printf("Error getting user metadata: %v", err)
 		return nil
 	}
 
+	return activeSubscriptionsFromMetadata(metadata)
+}
+
+// GetActiveSubscriptionsByUserID returns all active personal subscriptions for a user
+func GetActiveSubscriptionsByUserID(userID string) []map[string]interface{} {
+	metadata, err := GetUserPublicMetadata(userID)
+	if err != nil {
+		log.Printf("Error getting user metadata: %v", err)
+		return nil
+	}
+
+	return activeSubscriptionsFromMetadata(metadata)
+}
+
+// GetActiveSubscriptionsFromRawMetadata returns the active subscriptions stored in raw public metadata
+// It is used when the metadata was already fetched alongside another resource, e.g. a membership's organization
+func GetActiveSubscriptionsFromRawMetadata(rawMetadata json.RawMessage) []map[string]interface{} {
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(rawMetadata, &metadata); err != nil {
+		log.Printf("Error parsing metadata: %v", err)
+		return nil
+	}
+
+	return activeSubscriptionsFromMetadata(metadata)
+}
+
+// activeSubscriptionsFromMetadata returns the subscriptions in the metadata that grant access under their plan's grace policy
+// Subscriptions only kept through grace are returned with the grace_ends_at of their grace period
+func activeSubscriptionsFromMetadata(metadata map[string]interface{}) []map[string]interface{} {
 	var activeSubscriptions []map[string]interface{}
-	currentTime := time.Now().Unix()
+	now := time.Now()
 
 	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
 		if subscriptions, ok := stripeData["subscriptions"].([]interface{}); ok {
 			for _, sub := range subscriptions {
 				if subMap, ok := sub.(map[string]interface{}); ok {
-					// Check if subscription is active
-					if status, ok := subMap["status"].(string); ok && status == "active" {
-						// Check if subscription hasn't expired
-						if periodEnd, ok := subMap["current_period_end"].(float64); ok {
-							if int64(periodEnd) > currentTime {
-								activeSubscriptions = append(activeSubscriptions, subMap)
-							}
-						}
+					access := entitlements.CheckSubscriptionAccess(subMap, now)
+					if !access.Entitled {
+						continue
+					}
+					if access.GraceEndsAt > 0 {
+						// Copied so the grace end isn't written back into the mirrored subscription
+						subMap = maps.Clone(subMap)
+						subMap["grace_ends_at"] = access.GraceEndsAt
 					}
+					activeSubscriptions = append(activeSubscriptions, subMap)
 				}
 			}
 		}
@@ -179,35 +288,198 @@ func GetActiveSubscriptionsByCustomerID(customerId string) []map[string]interfac
 	return activeSubscriptions
 }
 
-// GetActiveSubscriptions returns all active subscriptions for a organization
-func GetActiveSubscriptionsByOrganizationID(organizationID string) []map[string]interface{} {
+// GetOwnerSubscription returns a subscription mirrored in the metadata of the organization or user that owns the billing mapping
+func GetOwnerSubscription(owner mongodbTypes.Organization, subscriptionID string) (map[string]interface{}, bool) {
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		log.Printf("Error getting owner metadata: %v", err)
+		return nil, false
+	}
 
-	metadata, err := GetOrganizationPublicMetadata(organizationID)
+	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
+		if subscriptions, ok := stripeData["subscriptions"].([]interface{}); ok {
+			for _, sub := range subscriptions {
+				if subMap, ok := sub.(map[string]interface{}); ok && subMap["id"] == subscriptionID {
+					return subMap, true
+				}
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// ListOwnerSubscriptions returns the subscriptions mirrored in the metadata of the organization or user that owns the billing mapping
+func ListOwnerSubscriptions(owner mongodbTypes.Organization) ([]map[string]interface{}, error) {
+	metadata, err := getOwnerPublicMetadata(owner)
 	if err != nil {
-		log.Printf("Error getting user metadata: %v", err)
-		return nil
+		return nil, err
 	}
+	return metadataSubscriptions(metadata), nil
+}
 
-	var activeSubscriptions []map[string]interface{}
-	currentTime := time.Now().Unix()
+// getOwnerPublicMetadata returns the public metadata of the organization or user that owns the billing mapping
+func getOwnerPublicMetadata(owner mongodbTypes.Organization) (map[string]interface{}, error) {
+	if owner.IsUser() {
+		return GetUserPublicMetadata(owner.ClerkUserID)
+	}
+	return GetOrganizationPublicMetadata(owner.ClerkID)
+}
+
+// updateOwnerPublicMetadata writes the public metadata of the organization or user that owns the billing mapping
+// The entitlements, active access holds and billing state are recomputed on every write so they never drift apart
+func updateOwnerPublicMetadata(owner mongodbTypes.Organization, metadata map[string]interface{}) error {
+	input := entitlementsInputFromMetadata(owner, metadata)
+	metadata["entitlements"] = entitlements.Compute(input)
+	if len(input.Holds) > 0 {
+		metadata["access_holds"] = input.Holds
+	} else {
+		delete(metadata, "access_holds")
+	}
+
+	if lifecycle, err := mongodb.GetBillingLifecycle(owner.OwnerID()); err != nil {
+		log.Printf("Error getting billing lifecycle of %s: %v", owner.OwnerID(), err)
+	} else {
+		metadata["billing"] = billingStateMetadata(lifecycle, len(input.Subscriptions) > 0)
+	}
+
+	var err error
+	if owner.IsUser() {
+		err = UpdateUserPublicMetadata(owner.ClerkUserID, metadata)
+	} else {
+		err = UpdateOrganizationPublicMetadata(owner.ClerkID, metadata)
+	}
+	InvalidateEntitlements(owner.OwnerID())
+	if err != nil {
+		return err
+	}
+
+	syncSubscriptionsExpireAt(owner, metadata)
+	return nil
+}
+
+// syncSubscriptionsExpireAt stores the earliest period end of the owner's mirrored subscriptions on its billing mapping
+// so the expiry sweep can find owners whose subscriptions weren't renewed or deleted by a webhook
+func syncSubscriptionsExpireAt(owner mongodbTypes.Organization, metadata map[string]interface{}) {
+	if owner.StripeCustomerID == "" {
+		return
+	}
+
+	expireAt := earliestPeriodEnd(metadata, nil)
+	unchanged := (expireAt == nil && owner.SubscriptionsExpireAt == nil) ||
+		(expireAt != nil && owner.SubscriptionsExpireAt != nil && expireAt.Equal(*owner.SubscriptionsExpireAt))
+	if unchanged {
+		return
+	}
+
+	if err := mongodb.UpdateOrganizationSubscriptionsExpireAt(owner.StripeCustomerID, expireAt); err != nil {
+		log.Printf("Error updating subscriptions expiry of %s: %v", owner.OwnerID(), err)
+	}
+}
+
+// BackfillSubscriptionsExpireAt stores the earliest period end of the owner's mirrored subscriptions on a mapping that has none yet,
+// for the mappings created before the expiry sweep whose metadata didn't change since
+func BackfillSubscriptionsExpireAt(owner mongodbTypes.Organization) error {
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return err
+	}
+	return mongodb.UpdateOrganizationSubscriptionsExpireAt(owner.StripeCustomerID, earliestPeriodEnd(metadata, nil))
+}
+
+// AdvanceSubscriptionsExpireAt stores the earliest period end of the owner's mirrored subscriptions leaving out the confirmed ones,
+// which Stripe just reported with a period that stays ended (paused or unpaid), so they aren't refetched on every sweep
+// The next metadata update counts them again, and a later sweep confirms them once more
+func AdvanceSubscriptionsExpireAt(owner mongodbTypes.Organization, confirmedIDs []string) error {
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return err
+	}
+	return mongodb.UpdateOrganizationSubscriptionsExpireAt(owner.StripeCustomerID, earliestPeriodEnd(metadata, confirmedIDs))
+}
 
+// earliestPeriodEnd returns the earliest current period end of the subscriptions mirrored in the metadata but the skipped ones,
+// nil when none has a period end
+func earliestPeriodEnd(metadata map[string]interface{}, skippedIDs []string) *time.Time {
+	var expireAt *time.Time
+	for _, subscription := range metadataSubscriptions(metadata) {
+		if id, _ := subscription["id"].(string); slices.Contains(skippedIDs, id) {
+			continue
+		}
+		periodEnd, ok := entitlements.MetadataInt(subscription["current_period_end"])
+		if !ok || periodEnd <= 0 {
+			continue
+		}
+		if end := time.Unix(periodEnd, 0); expireAt == nil || end.Before(*expireAt) {
+			expireAt = &end
+		}
+	}
+	return expireAt
+}
+
+// metadataSubscriptions returns the subscriptions mirrored in the metadata
+func metadataSubscriptions(metadata map[string]interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
 	if stripeData, ok := metadata["stripe"].(map[string]interface{}); ok {
 		if subscriptions, ok := stripeData["subscriptions"].([]interface{}); ok {
 			for _, sub := range subscriptions {
 				if subMap, ok := sub.(map[string]interface{}); ok {
-					// Check if subscription is active
-					if status, ok := subMap["status"].(string); ok && status == "active" {
-						// Check if subscription hasn't expired
-						if periodEnd, ok := subMap["current_period_end"].(float64); ok {
-							if int64(periodEnd) > currentTime {
-								activeSubscriptions = append(activeSubscriptions, subMap)
-							}
-						}
-					}
+					result = append(result, subMap)
 				}
 			}
 		}
 	}
+	return result
+}
 
-	return activeSubscriptions
+// GetExpiredOwnerSubscriptionIDs returns the subscriptions mirrored in the owner's metadata whose current period ended before the given time
+func GetExpiredOwnerSubscriptionIDs(owner mongodbTypes.Organization, before time.Time) ([]string, error) {
+	metadata, err := getOwnerPublicMetadata(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, subscription := range metadataSubscriptions(metadata) {
+		id, _ := subscription["id"].(string)
+		periodEnd, ok := entitlements.MetadataInt(subscription["current_period_end"])
+		if id != "" && ok && periodEnd > 0 && periodEnd <= before.Unix() {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// billingStateMetadata builds the billing state published in the owner's metadata, without the transitions history
+// The owner is entitled when its state keeps access and any of its subscriptions grants access under its plan's grace policy
+func billingStateMetadata(lifecycle mongodbTypes.BillingLifecycle, hasAccess bool) map[string]interface{} {
+	billing := map[string]interface{}{
+		"state":    lifecycle.State,
+		"entitled": hasAccess && (lifecycle.State == mongodbTypes.BillingStateNone || lifecycle.State.Entitled()),
+	}
+	if !lifecycle.Since.IsZero() {
+		billing["since"] = lifecycle.Since.Unix()
+	}
+	if lifecycle.SubscriptionID != "" {
+		billing["subscription_id"] = lifecycle.SubscriptionID
+	}
+	if lifecycle.GraceEndsAt != nil {
+		billing["grace_ends_at"] = lifecycle.GraceEndsAt.Unix()
+	}
+	return billing
+}
+
+// GetEffectivePlanFromRawMetadata returns the effective plan of the entitlements stored in raw public metadata
+func GetEffectivePlanFromRawMetadata(rawMetadata json.RawMessage) string {
+	var metadata struct {
+		Entitlements struct {
+			EffectivePlan string `json:"effective_plan"`
+		} `json:"entitlements"`
+	}
+	if err := json.Unmarshal(rawMetadata, &metadata); err != nil {
+		log.Printf("Error parsing metadata: %v", err)
+		return ""
+	}
+
+	return metadata.Entitlements.EffectivePlan
 }
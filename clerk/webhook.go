@@ -70,6 +70,12 @@ func processWebhookEvent(event *ClerkWebhookEvent) error {
 		return HandleOrganizationUpdated(event)
 	case "organization.deleted":
 		return HandleOrganizationDeleted(event)
+	case "organizationMembership.created", "organizationMembership.updated", "organizationMembership.deleted":
+		return HandleOrganizationMembershipChanged(event)
+	case "user.created":
+		return HandleUserCreated(event)
+	case "user.deleted":
+		return HandleUserDeleted(event)
 	default:
 		log.Printf("Unhandled webhook event type: %s", event.Type)
 		return nil
@@ -0,0 +1,335 @@
+package credits
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// maxApplyAttempts is how many times a transaction is retried when concurrent transactions change the wallet
+const maxApplyAttempts = 10
+
+// ErrInsufficientCredits is returned when a debit is larger than the wallet's unexpired credits
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// ErrConflict is returned when an idempotency key is reused for a different transaction
+var ErrConflict = errors.New("idempotency key already used for a different transaction")
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if err := mongodb.EnsureCreditIndexes(); err != nil {
+		log.Fatalf("Error creating credit indexes: %v", err)
+	}
+}
+
+// mutation changes a wallet for a transaction and returns the ledger entries describing the change
+type mutation func(wallet *mongodbTypes.CreditWallet, now time.Time) ([]mongodbTypes.LedgerEntry, error)
+
+// Grant adds credits to the organization's wallet, expiring at expiresAt unless it's nil
+// Granting again with the same idempotency key returns the original transaction
+func Grant(organizationID string, idempotencyKey string, amount int64, source string, packID string, expiresAt *time.Time, description string) (mongodbTypes.CreditTransaction, error) {
+	if amount <= 0 {
+		return mongodbTypes.CreditTransaction{}, fmt.Errorf("amount must be positive")
+	}
+
+	transaction := mongodbTypes.CreditTransaction{
+		OrganizationID: organizationID,
+		Type:           mongodbTypes.CreditTransactionGrant,
+		IdempotencyKey: idempotencyKey,
+		Amount:         amount,
+		Description:    description,
+		Reference:      source,
+	}
+
+	return execute(transaction, func(wallet *mongodbTypes.CreditWallet, now time.Time) ([]mongodbTypes.LedgerEntry, error) {
+		grant := mongodbTypes.CreditGrant{
+			ID:        bson.NewObjectID(),
+			Source:    source,
+			PackID:    packID,
+			Amount:    amount,
+			Remaining: amount,
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		}
+		wallet.Grants = append(wallet.Grants, grant)
+		wallet.Balance += amount
+
+		return []mongodbTypes.LedgerEntry{
+			{Account: mongodbTypes.CreditAccountPurchases, Amount: -amount},
+			{Account: mongodbTypes.CreditAccountWallet, GrantID: &grant.ID, Amount: amount},
+		}, nil
+	})
+}
+
+// Debit consumes credits from the organization's wallet, using the grants that expire first
+// Debiting again with the same idempotency key returns the original transaction without consuming more
+func Debit(organizationID string, idempotencyKey string, amount int64, description string) (mongodbTypes.CreditTransaction, error) {
+	if amount <= 0 {
+		return mongodbTypes.CreditTransaction{}, fmt.Errorf("amount must be positive")
+	}
+
+	transaction := mongodbTypes.CreditTransaction{
+		OrganizationID: organizationID,
+		Type:           mongodbTypes.CreditTransactionDebit,
+		IdempotencyKey: idempotencyKey,
+		Amount:         amount,
+		Description:    description,
+	}
+
+	return execute(transaction, func(wallet *mongodbTypes.CreditWallet, now time.Time) ([]mongodbTypes.LedgerEntry, error) {
+		if Available(*wallet, now) < amount {
+			return nil, ErrInsufficientCredits
+		}
+
+		// Grants without an expiry are used last
+		usable := []int{}
+		for i, grant := range wallet.Grants {
+			if grant.Remaining > 0 && !isExpired(grant, now) {
+				usable = append(usable, i)
+			}
+		}
+		sort.SliceStable(usable, func(a, b int) bool {
+			first, second := wallet.Grants[usable[a]].ExpiresAt, wallet.Grants[usable[b]].ExpiresAt
+			if first == nil || second == nil {
+				return second == nil && first != nil
+			}
+			return first.Before(*second)
+		})
+
+		entries := []mongodbTypes.LedgerEntry{}
+		left := amount
+		for _, i := range usable {
+			if left == 0 {
+				break
+			}
+			grant := &wallet.Grants[i]
+			used := min(grant.Remaining, left)
+			grant.Remaining -= used
+			left -= used
+			entries = append(entries, mongodbTypes.LedgerEntry{Account: mongodbTypes.CreditAccountWallet, GrantID: &grant.ID, Amount: -used})
+		}
+		wallet.Balance -= amount
+		wallet.Grants = activeGrants(wallet.Grants)
+
+		return append(entries, mongodbTypes.LedgerEntry{Account: mongodbTypes.CreditAccountConsumption, Amount: amount}), nil
+	})
+}
+
+// Revoke takes back up to amount credits granted for a refunded payment, from the grants of the payment first
+// Credits that were already consumed can't be taken back, so it takes no more than the wallet's unexpired credits,
+// and nothing when the wallet is empty
+// Revoking again with the same idempotency key returns the original transaction without taking more
+func Revoke(organizationID string, idempotencyKey string, amount int64, source string, description string) (mongodbTypes.CreditTransaction, error) {
+	if amount <= 0 {
+		return mongodbTypes.CreditTransaction{}, fmt.Errorf("amount must be positive")
+	}
+
+	for attempt := 0; attempt < maxApplyAttempts; attempt++ {
+		// A revocation that was already recorded is finished with its own amount
+		existing, err := mongodb.GetCreditTransaction(organizationID, idempotencyKey)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+		if err == nil {
+			posted, err := execute(existing, revokeMutation(existing.Amount, source))
+			if !errors.Is(err, ErrInsufficientCredits) {
+				return posted, err
+			}
+			continue
+		}
+
+		wallet, err := mongodb.GetCreditWallet(organizationID)
+		if err != nil {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+		take := min(amount, Available(wallet, time.Now()))
+		if take == 0 {
+			return mongodbTypes.CreditTransaction{}, nil
+		}
+
+		transaction := mongodbTypes.CreditTransaction{
+			OrganizationID: organizationID,
+			Type:           mongodbTypes.CreditTransactionRevocation,
+			IdempotencyKey: idempotencyKey,
+			Amount:         take,
+			Description:    description,
+			Reference:      source,
+		}
+		posted, err := execute(transaction, revokeMutation(take, source))
+		// Credits consumed since the wallet was read are retried with what's left
+		if errors.Is(err, ErrInsufficientCredits) {
+			continue
+		}
+		return posted, err
+	}
+
+	return mongodbTypes.CreditTransaction{}, fmt.Errorf("wallet of organization %s kept changing, revocation %s not applied", organizationID, idempotencyKey)
+}
+
+// revokeMutation takes amount credits out of the wallet, from the grants of the source first
+func revokeMutation(amount int64, source string) mutation {
+	return func(wallet *mongodbTypes.CreditWallet, now time.Time) ([]mongodbTypes.LedgerEntry, error) {
+		if Available(*wallet, now) < amount {
+			return nil, ErrInsufficientCredits
+		}
+
+		usable := []int{}
+		for i, grant := range wallet.Grants {
+			if grant.Remaining > 0 && !isExpired(grant, now) {
+				usable = append(usable, i)
+			}
+		}
+		sort.SliceStable(usable, func(a, b int) bool {
+			return wallet.Grants[usable[a]].Source == source && wallet.Grants[usable[b]].Source != source
+		})
+
+		entries := []mongodbTypes.LedgerEntry{}
+		left := amount
+		for _, i := range usable {
+			if left == 0 {
+				break
+			}
+			grant := &wallet.Grants[i]
+			taken := min(grant.Remaining, left)
+			grant.Remaining -= taken
+			left -= taken
+			entries = append(entries, mongodbTypes.LedgerEntry{Account: mongodbTypes.CreditAccountWallet, GrantID: &grant.ID, Amount: -taken})
+		}
+		wallet.Balance -= amount
+		wallet.Grants = activeGrants(wallet.Grants)
+
+		return append(entries, mongodbTypes.LedgerEntry{Account: mongodbTypes.CreditAccountRefunds, Amount: amount}), nil
+	}
+}
+
+// expire removes the credits left in an expired grant from the organization's wallet
+func expire(organizationID string, grant mongodbTypes.CreditGrant) (mongodbTypes.CreditTransaction, error) {
+	transaction := mongodbTypes.CreditTransaction{
+		OrganizationID: organizationID,
+		Type:           mongodbTypes.CreditTransactionExpiry,
+		IdempotencyKey: "expiry:" + grant.ID.Hex(),
+		Amount:         grant.Remaining,
+		Description:    "Credits expired",
+		Reference:      grant.Source,
+	}
+
+	return execute(transaction, func(wallet *mongodbTypes.CreditWallet, now time.Time) ([]mongodbTypes.LedgerEntry, error) {
+		i := slices.IndexFunc(wallet.Grants, func(g mongodbTypes.CreditGrant) bool { return g.ID == grant.ID })
+		if i == -1 || wallet.Grants[i].Remaining != grant.Remaining {
+			return nil, fmt.Errorf("grant %s changed since it expired", grant.ID.Hex())
+		}
+
+		wallet.Balance -= grant.Remaining
+		wallet.Grants = slices.Delete(wallet.Grants, i, i+1)
+
+		return []mongodbTypes.LedgerEntry{
+			{Account: mongodbTypes.CreditAccountWallet, GrantID: &grant.ID, Amount: -grant.Remaining},
+			{Account: mongodbTypes.CreditAccountExpired, Amount: grant.Remaining},
+		}, nil
+	})
+}
+
+// execute records a transaction as pending, applies it to the wallet and posts it
+// A pending transaction left behind by an interrupted call is finished by the next call with the same idempotency key
+func execute(transaction mongodbTypes.CreditTransaction, mutate mutation) (mongodbTypes.CreditTransaction, error) {
+	pending, created, err := mongodb.CreateCreditTransaction(transaction)
+	if err != nil {
+		return mongodbTypes.CreditTransaction{}, err
+	}
+	if !created {
+		existing, err := mongodb.GetCreditTransaction(transaction.OrganizationID, transaction.IdempotencyKey)
+		if err != nil {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+		if existing.Type != transaction.Type || existing.Amount != transaction.Amount {
+			return existing, ErrConflict
+		}
+		if existing.Status == mongodbTypes.CreditTransactionPosted {
+			return existing, nil
+		}
+		pending = existing
+	}
+
+	for attempt := 0; attempt < maxApplyAttempts; attempt++ {
+		wallet, err := mongodb.GetCreditWallet(transaction.OrganizationID)
+		if err != nil {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+		if slices.Contains(wallet.AppliedTransactions, pending.ID) {
+			return mongodb.PostCreditTransaction(pending.ID)
+		}
+
+		entries, err := mutate(&wallet, time.Now())
+		if err != nil {
+			if errors.Is(err, ErrInsufficientCredits) {
+				if err := mongodb.DeleteCreditTransaction(pending.ID); err != nil {
+					log.Printf("Error deleting rejected credit transaction %s: %v", pending.ID.Hex(), err)
+				}
+			}
+			return mongodbTypes.CreditTransaction{}, err
+		}
+
+		if err := mongodb.PrepareCreditTransaction(pending.ID, entries, wallet.Balance); err != nil {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+
+		updated, err := mongodb.UpdateCreditWallet(wallet, pending.ID)
+		if err != nil {
+			return mongodbTypes.CreditTransaction{}, err
+		}
+		if updated {
+			posted, err := mongodb.PostCreditTransaction(pending.ID)
+			if err != nil {
+				return mongodbTypes.CreditTransaction{}, err
+			}
+
+			log.Printf("[CREDITS] Posted %s of %d credits for organization %s, balance %d", posted.Type, posted.Amount, posted.OrganizationID, posted.BalanceAfter)
+			return posted, nil
+		}
+	}
+
+	return mongodbTypes.CreditTransaction{}, fmt.Errorf("wallet of organization %s kept changing, transaction %s left pending", transaction.OrganizationID, transaction.IdempotencyKey)
+}
+
+// GetWallet returns the organization's wallet with the grants that still hold credits
+func GetWallet(organizationID string) (mongodbTypes.CreditWallet, error) {
+	return mongodb.GetCreditWallet(organizationID)
+}
+
+// ListTransactions returns the organization's most recent posted transactions, newest first
+func ListTransactions(organizationID string, limit int64) ([]mongodbTypes.CreditTransaction, error) {
+	return mongodb.ListCreditTransactions(organizationID, limit)
+}
+
+// Available returns the credits of the wallet that can be debited, which excludes expired grants the sweeper hasn't removed yet
+func Available(wallet mongodbTypes.CreditWallet, now time.Time) int64 {
+	var available int64
+	for _, grant := range wallet.Grants {
+		if !isExpired(grant, now) {
+			available += grant.Remaining
+		}
+	}
+	return available
+}
+
+// isExpired reports whether the grant's credits can no longer be used
+func isExpired(grant mongodbTypes.CreditGrant, now time.Time) bool {
+	return grant.ExpiresAt != nil && !grant.ExpiresAt.After(now)
+}
+
+// activeGrants drops the grants that were fully used, so wallets don't grow with every purchase
+func activeGrants(grants []mongodbTypes.CreditGrant) []mongodbTypes.CreditGrant {
+	return slices.DeleteFunc(grants, func(grant mongodbTypes.CreditGrant) bool { return grant.Remaining == 0 })
+}
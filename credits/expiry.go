@@ -0,0 +1,47 @@
+package credits
+
+import (
+	"log"
+	"nucleus/config"
+	"nucleus/mongodb"
+	"time"
+)
+
+// StartExpirySweeper periodically removes the credits left in expired grants from the wallets
+func StartExpirySweeper() {
+	interval := config.Duration("CREDIT_EXPIRY_SWEEP_INTERVAL", 10*time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredGrants()
+		}
+	}()
+}
+
+// sweepExpiredGrants posts an expiry transaction for every expired grant with credits left
+// Failed expiries are retried on the next sweep with the same idempotency key
+func sweepExpiredGrants() {
+	wallets, err := mongodb.ListWalletsWithExpiredGrants()
+	if err != nil {
+		log.Printf("Error listing wallets with expired credits: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, wallet := range wallets {
+		for _, grant := range wallet.Grants {
+			if grant.Remaining == 0 || !isExpired(grant, now) {
+				continue
+			}
+
+			if _, err := expire(wallet.OrganizationID, grant); err != nil {
+				log.Printf("Error expiring credit grant %s of organization %s: %v", grant.ID.Hex(), wallet.OrganizationID, err)
+				continue
+			}
+			log.Printf("[CREDITS] Expired %d credits of grant %s for organization %s", grant.Remaining, grant.ID.Hex(), wallet.OrganizationID)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package entitlements
+
+import (
+	"nucleus/catalog"
+	catalogTypes "nucleus/types/catalog"
+	"slices"
+	"time"
+)
+
+// secondsPerDay converts grace days to seconds
+const secondsPerDay = 24 * 60 * 60
+
+// SubscriptionAccess is whether a subscription mirrored in metadata grants access right now
+type SubscriptionAccess struct {
+	Entitled    bool
+	GraceEndsAt int64 // Unix time the grace period ends, 0 unless the subscription is only entitled through grace
+}
+
+// SubscriptionPlanID returns the base plan of a subscription mirrored in metadata, or its first plan when it only holds add-ons
+func SubscriptionPlanID(subscription map[string]interface{}) string {
+	planID := ""
+	for _, item := range SubscriptionItems(subscription) {
+		plan, ok := catalog.FindPlan(item.ProductID, item.PriceID)
+		if !ok {
+			continue
+		}
+		if !plan.IsAddon() {
+			return plan.ID
+		}
+		if planID == "" {
+			planID = plan.ID
+		}
+	}
+	return planID
+}
+
+// SubscriptionGracePolicy returns the grace policy of the plan a subscription mirrored in metadata is for
+func SubscriptionGracePolicy(subscription map[string]interface{}) catalogTypes.GracePolicy {
+	return catalog.GracePolicyFor(SubscriptionPlanID(subscription))
+}
+
+// CheckSubscriptionAccess applies the grace policy of the subscription's plan to its status
+// Subscriptions in an entitled status grant access until their current period ends, those in a grace status
+// grant access until the grace period counted from their first failed payment (past_due_since) ends
+// This is the single access check behind every active subscriptions lookup and the entitlements computation
+func CheckSubscriptionAccess(subscription map[string]interface{}, now time.Time) SubscriptionAccess {
+	status, _ := subscription["status"].(string)
+	policy := SubscriptionGracePolicy(subscription)
+
+	if slices.Contains(policy.EntitledStatuses, status) {
+		periodEnd, ok := MetadataInt(subscription["current_period_end"])
+		return SubscriptionAccess{Entitled: ok && periodEnd > now.Unix()}
+	}
+
+	if slices.Contains(policy.GraceStatuses, status) && policy.Days > 0 {
+		since, ok := MetadataInt(subscription["past_due_since"])
+		if !ok {
+			return SubscriptionAccess{}
+		}
+		graceEndsAt := since + int64(policy.Days)*secondsPerDay
+		if graceEndsAt > now.Unix() {
+			return SubscriptionAccess{Entitled: true, GraceEndsAt: graceEndsAt}
+		}
+	}
+
+	return SubscriptionAccess{}
+}
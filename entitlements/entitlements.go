@@ -0,0 +1,357 @@
+package entitlements
+
+import (
+	"log"
+	"nucleus/catalog"
+	"nucleus/entitlements/rules"
+	catalogTypes "nucleus/types/catalog"
+	entitlementsTypes "nucleus/types/entitlements"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"sort"
+	"time"
+)
+
+// GrantedByStripe marks features granted through the Stripe Entitlements API
+const GrantedByStripe = "stripe"
+
+// GrantedByRule marks features granted by a catalog rule
+const GrantedByRule = "rule"
+
+// GrantedByOverride marks features granted by a manual override or a comped plan
+const GrantedByOverride = "override"
+
+// GrantedByPurchase marks features granted by a one-time purchase
+const GrantedByPurchase = "purchase"
+
+// FeatureSeats is the limit on the number of organization members
+const FeatureSeats = "seats"
+
+// Input is everything an owner's entitlements are computed from
+type Input struct {
+	Subscriptions  []map[string]interface{}           // Active subscriptions mirrored in metadata
+	StripeFeatures []string                           // Lookup keys of the customer's active Stripe entitlements
+	Overrides      []mongodbTypes.EntitlementOverride // Active manual overrides and comped plans, oldest first
+	Purchases      []mongodbTypes.Purchase            // Active one-time purchases
+	Holds          []mongodbTypes.AccessHold          // Active holds placed after disputes, refunds and fraud warnings
+	Usage          map[string]int64                   // Current usage of limited features, only needed by rules
+	Metadata       map[string]interface{}             // The owner's public metadata, only needed by rules
+}
+
+// Compute returns the entitlements granted by the active subscriptions and one-time purchases according to the plan catalog,
+// merged with the features granted through the Stripe Entitlements API, manual overrides and the catalog rules
+// When several base plans grant the same limit, the highest one applies and add-ons add to it. An override's limit replaces the
+// granted one outright, revoking overrides win over everything, and a suspending access hold removes every feature
+func Compute(input Input) entitlementsTypes.Entitlements {
+	result := computeBase(input)
+
+	for _, hold := range input.Holds {
+		switch hold.Action {
+		case mongodbTypes.HoldActionSuspend:
+			result.Suspended = true
+		case mongodbTypes.HoldActionFlag:
+			if !slices.Contains(result.Flags, string(hold.Reason)) {
+				result.Flags = append(result.Flags, string(hold.Reason))
+			}
+		}
+	}
+	if result.Suspended {
+		result.Features = map[string]entitlementsTypes.Feature{}
+		return result
+	}
+
+	ruleContext := newRuleContext(input, result)
+	for _, feature := range sortedRuleFeatures() {
+		granted, err := catalog.Rules()[feature].Evaluate(ruleContext)
+		if err != nil {
+			log.Printf("[ENTITLEMENTS] Error evaluating rule for %s: %v", feature, err)
+			continue
+		}
+		if granted {
+			grant(result.Features, feature, nil, GrantedByRule)
+		}
+	}
+
+	revokeOverridden(result.Features, input.Overrides)
+
+	return result
+}
+
+// RuleContext returns the context catalog rules are evaluated against for the input
+func RuleContext(input Input) rules.Context {
+	return newRuleContext(input, computeBase(input))
+}
+
+// planGrant is a catalog plan granted to the owner, with the quantity it's held in
+type planGrant struct {
+	plan      catalogTypes.Plan
+	quantity  int64
+	grantedBy string
+}
+
+// computeBase returns the entitlements granted by plans and Stripe, before rules are applied
+func computeBase(input Input) entitlementsTypes.Entitlements {
+	result := entitlementsTypes.Entitlements{
+		CatalogVersion: catalog.Get().Version,
+		Plans:          []string{},
+		Features:       map[string]entitlementsTypes.Feature{},
+		ComputedAt:     time.Now().Unix(),
+	}
+
+	plans := []planGrant{}
+	baseSubscriptions := []string{}
+	now := time.Now()
+	for _, subscription := range input.Subscriptions {
+		subscriptionID, _ := subscription["id"].(string)
+		access := CheckSubscriptionAccess(subscription, now)
+		if !access.Entitled {
+			continue
+		}
+		if access.GraceEndsAt > 0 && (result.GraceEndsAt == nil || access.GraceEndsAt < *result.GraceEndsAt) {
+			graceEndsAt := access.GraceEndsAt
+			result.GraceEndsAt = &graceEndsAt
+		}
+		for _, item := range SubscriptionItems(subscription) {
+			plan, ok := catalog.FindPlan(item.ProductID, item.PriceID)
+			if !ok {
+				continue
+			}
+			plans = append(plans, planGrant{plan: plan, quantity: item.Quantity, grantedBy: subscriptionID})
+			if !plan.IsAddon() && !slices.Contains(baseSubscriptions, subscriptionID) {
+				baseSubscriptions = append(baseSubscriptions, subscriptionID)
+			}
+		}
+	}
+	// More than one subscription granting a base plan usually means an old subscription wasn't canceled after a plan change
+	if len(baseSubscriptions) > 1 {
+		sort.Strings(baseSubscriptions)
+		result.DuplicateSubscriptions = baseSubscriptions
+	}
+
+	for _, purchase := range input.Purchases {
+		plan, ok := catalog.GetPlan(purchase.PlanID)
+		if !ok {
+			log.Printf("[ENTITLEMENTS] Purchase %s is of unknown plan %s", purchase.ID.Hex(), purchase.PlanID)
+			continue
+		}
+		plans = append(plans, planGrant{plan: plan, quantity: purchase.Quantity, grantedBy: GrantedByPurchase})
+	}
+
+	for _, override := range input.Overrides {
+		if override.Kind != mongodbTypes.OverrideKindPlan {
+			continue
+		}
+		plan, ok := catalog.GetPlan(override.PlanID)
+		if !ok {
+			log.Printf("[ENTITLEMENTS] Override %s comps unknown plan %s", override.ID.Hex(), override.PlanID)
+			continue
+		}
+		plans = append(plans, planGrant{plan: plan, quantity: 1, grantedBy: GrantedByOverride})
+	}
+
+	for _, granted := range plans {
+		if !granted.plan.IsAddon() {
+			if !slices.Contains(result.Plans, granted.plan.ID) {
+				result.Plans = append(result.Plans, granted.plan.ID)
+			}
+			grantPlan(result.Features, granted.plan, granted.grantedBy)
+		}
+	}
+
+	for _, lookupKey := range input.StripeFeatures {
+		grant(result.Features, lookupKey, nil, GrantedByStripe)
+	}
+
+	for _, override := range input.Overrides {
+		if override.Kind == mongodbTypes.OverrideKindFeature && override.Enabled {
+			grant(result.Features, override.Feature, nil, GrantedByOverride)
+		}
+	}
+
+	// Add-ons go last so their limits are added on top of the highest base limit
+	for _, granted := range plans {
+		if granted.plan.IsAddon() {
+			if !slices.Contains(result.Addons, granted.plan.ID) {
+				result.Addons = append(result.Addons, granted.plan.ID)
+			}
+			grantAddon(result.Features, granted.plan, granted.quantity, granted.grantedBy)
+		}
+	}
+
+	// Override limits go after add-ons so they can lower a limit as well as raise it
+	applyOverrideLimits(result.Features, input.Overrides)
+	revokeOverridden(result.Features, input.Overrides)
+
+	result.EffectivePlan = EffectivePlan(result.Plans)
+
+	return result
+}
+
+// EffectivePlan returns the base plan with the highest tier among the given ones, or an empty string if there are none
+// The catalog gives every base plan its own tier, so there are no ties
+func EffectivePlan(planIDs []string) string {
+	effective := ""
+	highest := 0
+	for _, plan := range catalog.Get().Plans {
+		if plan.IsAddon() || !slices.Contains(planIDs, plan.ID) {
+			continue
+		}
+		if effective == "" || plan.Tier > highest {
+			effective = plan.ID
+			highest = plan.Tier
+		}
+	}
+	return effective
+}
+
+// newRuleContext builds the rule context from the input and the entitlements granted before rules
+// Rules only see base features, so they never depend on each other
+func newRuleContext(input Input, base entitlementsTypes.Entitlements) rules.Context {
+	ruleContext := rules.Context{
+		Plans:     append(slices.Clone(base.Plans), base.Addons...),
+		Items:     []rules.Item{},
+		Features:  map[string]bool{},
+		Limits:    map[string]int64{},
+		Overrides: map[string]bool{},
+		Usage:     input.Usage,
+		Metadata:  input.Metadata,
+	}
+
+	for _, subscription := range input.Subscriptions {
+		subscriptionID, _ := subscription["id"].(string)
+		for _, subscriptionItem := range SubscriptionItems(subscription) {
+			item := rules.Item{
+				SubscriptionID: subscriptionID,
+				PriceID:        subscriptionItem.PriceID,
+				ProductID:      subscriptionItem.ProductID,
+				Quantity:       subscriptionItem.Quantity,
+			}
+			if plan, ok := catalog.FindPlan(item.ProductID, item.PriceID); ok {
+				item.PlanID = plan.ID
+			}
+			ruleContext.Items = append(ruleContext.Items, item)
+		}
+	}
+
+	for _, purchase := range input.Purchases {
+		ruleContext.Items = append(ruleContext.Items, rules.Item{
+			PriceID:   purchase.PriceID,
+			ProductID: purchase.ProductID,
+			PlanID:    purchase.PlanID,
+			Quantity:  purchase.Quantity,
+		})
+	}
+
+	for _, override := range input.Overrides {
+		if override.Kind == mongodbTypes.OverrideKindFeature {
+			ruleContext.Overrides[override.Feature] = override.Enabled
+		}
+	}
+
+	for name, feature := range base.Features {
+		ruleContext.Features[name] = feature.Enabled
+		if feature.Limit != nil {
+			ruleContext.Limits[name] = *feature.Limit
+		}
+	}
+
+	return ruleContext
+}
+
+// sortedRuleFeatures returns the features with catalog rules in a stable order
+func sortedRuleFeatures() []string {
+	features := make([]string, 0, len(catalog.Rules()))
+	for feature := range catalog.Rules() {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// revokeOverridden removes the features revoked by an override, whoever granted them
+func revokeOverridden(features map[string]entitlementsTypes.Feature, overrides []mongodbTypes.EntitlementOverride) {
+	for _, override := range overrides {
+		if override.Kind == mongodbTypes.OverrideKindFeature && !override.Enabled {
+			delete(features, override.Feature)
+		}
+	}
+}
+
+// applyOverrideLimits replaces the limits of the features granted by an override with a limit
+// When several overrides set the same limit, the most recent one wins
+func applyOverrideLimits(features map[string]entitlementsTypes.Feature, overrides []mongodbTypes.EntitlementOverride) {
+	for _, override := range overrides {
+		if override.Kind != mongodbTypes.OverrideKindFeature || !override.Enabled || override.Limit == nil {
+			continue
+		}
+		feature := features[override.Feature]
+		value := *override.Limit
+		feature.Limit = &value
+		features[override.Feature] = feature
+	}
+}
+
+// grantPlan grants every feature, limit and soft limit of the plan
+func grantPlan(features map[string]entitlementsTypes.Feature, plan catalogTypes.Plan, grantedBy string) {
+	for _, name := range plan.Features {
+		grant(features, name, nil, grantedBy)
+	}
+	for name, limit := range plan.Limits {
+		grant(features, name, &limit, grantedBy)
+	}
+	for name, softLimit := range plan.SoftLimits {
+		grant(features, name, nil, grantedBy)
+
+		feature := features[name]
+		if feature.SoftLimit == nil || softLimit > *feature.SoftLimit {
+			value := softLimit
+			feature.SoftLimit = &value
+		}
+		features[name] = feature
+	}
+}
+
+// grantAddon grants every feature of the add-on, adding its limits and soft limits times the quantity to the granted ones
+func grantAddon(features map[string]entitlementsTypes.Feature, plan catalogTypes.Plan, quantity int64, grantedBy string) {
+	for _, name := range plan.Features {
+		grant(features, name, nil, grantedBy)
+	}
+	for name, limit := range plan.Limits {
+		grant(features, name, nil, grantedBy)
+
+		feature := features[name]
+		feature.Limit = addLimit(feature.Limit, limit*quantity)
+		features[name] = feature
+	}
+	for name, softLimit := range plan.SoftLimits {
+		grant(features, name, nil, grantedBy)
+
+		feature := features[name]
+		feature.SoftLimit = addLimit(feature.SoftLimit, softLimit*quantity)
+		features[name] = feature
+	}
+}
+
+// addLimit returns the limit increased by amount, or amount when there was no limit
+func addLimit(limit *int64, amount int64) *int64 {
+	if limit != nil {
+		amount += *limit
+	}
+	return &amount
+}
+
+// grant enables the feature, keeping the highest limit and recording who granted it
+func grant(features map[string]entitlementsTypes.Feature, name string, limit *int64, grantedBy string) {
+	feature := features[name]
+	feature.Enabled = true
+
+	if limit != nil && (feature.Limit == nil || *limit > *feature.Limit) {
+		value := *limit
+		feature.Limit = &value
+	}
+
+	if !slices.Contains(feature.GrantedBy, grantedBy) {
+		feature.GrantedBy = append(feature.GrantedBy, grantedBy)
+	}
+	features[name] = feature
+}
@@ -0,0 +1,212 @@
+package entitlements
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"nucleus/catalog"
+	catalogTypes "nucleus/types/catalog"
+	entitlementsTypes "nucleus/types/entitlements"
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// setTestCatalog makes a catalog with two base plans, an add-on and a rule the current one
+func setTestCatalog(t *testing.T) {
+	t.Helper()
+
+	err := catalog.Set(catalogTypes.Catalog{
+		Version: 7,
+		Plans: []catalogTypes.Plan{
+			{ID: "basic", PriceIDs: []string{"price_basic"}, Features: []string{"export"}, Limits: map[string]int64{"api_calls": 100, "seats": 3}, Tier: 1},
+			{ID: "pro", PriceIDs: []string{"price_pro"}, Features: []string{"export", "sso"}, Limits: map[string]int64{"api_calls": 1000, "seats": 10}, SoftLimits: map[string]int64{"storage": 50}, Tier: 3},
+			{ID: "extra_calls", Kind: catalogTypes.PlanKindAddon, PriceIDs: []string{"price_extra_calls"}, Limits: map[string]int64{"api_calls": 500}, SoftLimits: map[string]int64{"storage": 10}},
+		},
+		Rules: map[string]string{"priority_support": `has_plan("pro")`},
+	})
+	if err != nil {
+		t.Fatalf("setting the test catalog: %v", err)
+	}
+}
+
+// subscription builds an active subscription mirrored in metadata with an item per price and quantity pair
+func subscription(id string, items ...interface{}) map[string]interface{} {
+	periodEnd := time.Now().Add(30 * 24 * time.Hour).Unix()
+	rawItems := []interface{}{}
+	for i := 0; i < len(items); i += 2 {
+		rawItems = append(rawItems, map[string]interface{}{
+			"id":                 id + "_item",
+			"price_id":           items[i],
+			"quantity":           int64(items[i+1].(int)),
+			"current_period_end": periodEnd,
+		})
+	}
+	return map[string]interface{}{"id": id, "status": "active", "current_period_end": periodEnd, "items": rawItems}
+}
+
+func limit(value int64) *int64 {
+	return &value
+}
+
+func TestCompute(t *testing.T) {
+	setTestCatalog(t)
+
+	tests := []struct {
+		name              string
+		input             Input
+		wantPlans         []string
+		wantAddons        []string
+		wantEffectivePlan string
+		wantDuplicates    []string
+		wantSuspended     bool
+		wantFlags         []string
+		wantFeatures      map[string]entitlementsTypes.Feature
+	}{
+		{
+			name:              "base plan and add-ons",
+			input:             Input{Subscriptions: []map[string]interface{}{subscription("sub_1", "price_pro", 1, "price_extra_calls", 2, "price_extra_calls", 1)}},
+			wantPlans:         []string{"pro"},
+			wantAddons:        []string{"extra_calls"},
+			wantEffectivePlan: "pro",
+			wantFeatures: map[string]entitlementsTypes.Feature{
+				"export":           {Enabled: true, GrantedBy: []string{"sub_1"}},
+				"sso":              {Enabled: true, GrantedBy: []string{"sub_1"}},
+				"api_calls":        {Enabled: true, Limit: limit(2500), GrantedBy: []string{"sub_1"}},
+				"seats":            {Enabled: true, Limit: limit(10), GrantedBy: []string{"sub_1"}},
+				"storage":          {Enabled: true, SoftLimit: limit(80), GrantedBy: []string{"sub_1"}},
+				"priority_support": {Enabled: true, GrantedBy: []string{GrantedByRule}},
+			},
+		},
+		{
+			name: "two base plans with different tiers",
+			input: Input{Subscriptions: []map[string]interface{}{
+				subscription("sub_2", "price_basic", 1),
+				subscription("sub_1", "price_pro", 1),
+			}},
+			wantPlans:         []string{"basic", "pro"},
+			wantEffectivePlan: "pro",
+			wantDuplicates:    []string{"sub_1", "sub_2"},
+			wantFeatures: map[string]entitlementsTypes.Feature{
+				"export":           {Enabled: true, GrantedBy: []string{"sub_2", "sub_1"}},
+				"sso":              {Enabled: true, GrantedBy: []string{"sub_1"}},
+				"api_calls":        {Enabled: true, Limit: limit(1000), GrantedBy: []string{"sub_2", "sub_1"}},
+				"seats":            {Enabled: true, Limit: limit(10), GrantedBy: []string{"sub_2", "sub_1"}},
+				"storage":          {Enabled: true, SoftLimit: limit(50), GrantedBy: []string{"sub_1"}},
+				"priority_support": {Enabled: true, GrantedBy: []string{GrantedByRule}},
+			},
+		},
+		{
+			name: "overrides revoke a feature and replace a limit",
+			input: Input{
+				Subscriptions: []map[string]interface{}{subscription("sub_1", "price_basic", 1, "price_extra_calls", 1)},
+				Overrides: []mongodbTypes.EntitlementOverride{
+					{Kind: mongodbTypes.OverrideKindFeature, Feature: "export", Enabled: false},
+					{Kind: mongodbTypes.OverrideKindFeature, Feature: "api_calls", Enabled: true, Limit: limit(200)},
+				},
+			},
+			wantPlans:         []string{"basic"},
+			wantAddons:        []string{"extra_calls"},
+			wantEffectivePlan: "basic",
+			wantFeatures: map[string]entitlementsTypes.Feature{
+				"api_calls": {Enabled: true, Limit: limit(200), GrantedBy: []string{"sub_1", GrantedByOverride}},
+				"seats":     {Enabled: true, Limit: limit(3), GrantedBy: []string{"sub_1"}},
+				"storage":   {Enabled: true, SoftLimit: limit(10), GrantedBy: []string{"sub_1"}},
+			},
+		},
+		{
+			name: "suspend hold clears every feature",
+			input: Input{
+				Subscriptions: []map[string]interface{}{subscription("sub_1", "price_pro", 1)},
+				Overrides:     []mongodbTypes.EntitlementOverride{{Kind: mongodbTypes.OverrideKindFeature, Feature: "beta", Enabled: true}},
+				Holds: []mongodbTypes.AccessHold{
+					{Reason: mongodbTypes.HoldReasonRefund, Action: mongodbTypes.HoldActionFlag},
+					{Reason: mongodbTypes.HoldReasonDispute, Action: mongodbTypes.HoldActionSuspend},
+				},
+			},
+			wantPlans:         []string{"pro"},
+			wantEffectivePlan: "pro",
+			wantSuspended:     true,
+			wantFlags:         []string{string(mongodbTypes.HoldReasonRefund)},
+			wantFeatures:      map[string]entitlementsTypes.Feature{},
+		},
+		{
+			name:         "no subscriptions",
+			input:        Input{},
+			wantPlans:    []string{},
+			wantFeatures: map[string]entitlementsTypes.Feature{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Compute(test.input)
+
+			if result.CatalogVersion != 7 {
+				t.Errorf("catalog version = %d, want 7", result.CatalogVersion)
+			}
+			if !reflect.DeepEqual(result.Plans, test.wantPlans) {
+				t.Errorf("plans = %v, want %v", result.Plans, test.wantPlans)
+			}
+			if !reflect.DeepEqual(result.Addons, test.wantAddons) {
+				t.Errorf("addons = %v, want %v", result.Addons, test.wantAddons)
+			}
+			if result.EffectivePlan != test.wantEffectivePlan {
+				t.Errorf("effective plan = %q, want %q", result.EffectivePlan, test.wantEffectivePlan)
+			}
+			if !reflect.DeepEqual(result.DuplicateSubscriptions, test.wantDuplicates) {
+				t.Errorf("duplicate subscriptions = %v, want %v", result.DuplicateSubscriptions, test.wantDuplicates)
+			}
+			if result.Suspended != test.wantSuspended {
+				t.Errorf("suspended = %v, want %v", result.Suspended, test.wantSuspended)
+			}
+			if !reflect.DeepEqual(result.Flags, test.wantFlags) {
+				t.Errorf("flags = %v, want %v", result.Flags, test.wantFlags)
+			}
+
+			if !reflect.DeepEqual(result.Features, test.wantFeatures) {
+				t.Errorf("features = %v, want %v", describeFeatures(result.Features), describeFeatures(test.wantFeatures))
+			}
+		})
+	}
+}
+
+func TestEffectivePlan(t *testing.T) {
+	setTestCatalog(t)
+
+	tests := []struct {
+		name    string
+		planIDs []string
+		want    string
+	}{
+		{name: "none", planIDs: nil, want: ""},
+		{name: "single", planIDs: []string{"basic"}, want: "basic"},
+		{name: "highest tier wins regardless of order", planIDs: []string{"pro", "basic"}, want: "pro"},
+		{name: "add-ons are never effective", planIDs: []string{"extra_calls"}, want: ""},
+		{name: "unknown plans are ignored", planIDs: []string{"legacy", "basic"}, want: "basic"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := EffectivePlan(test.planIDs); got != test.want {
+				t.Errorf("EffectivePlan(%v) = %q, want %q", test.planIDs, got, test.want)
+			}
+		})
+	}
+}
+
+// describeFeatures formats features with their limits dereferenced so failures are readable
+func describeFeatures(features map[string]entitlementsTypes.Feature) map[string]string {
+	result := map[string]string{}
+	for name, feature := range features {
+		description := fmt.Sprintf("enabled=%v granted_by=%v", feature.Enabled, feature.GrantedBy)
+		if feature.Limit != nil {
+			description += fmt.Sprintf(" limit=%d", *feature.Limit)
+		}
+		if feature.SoftLimit != nil {
+			description += fmt.Sprintf(" soft_limit=%d", *feature.SoftLimit)
+		}
+		result[name] = description
+	}
+	return result
+}
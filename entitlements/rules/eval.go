@@ -0,0 +1,222 @@
+package rules
+
+import (
+	"fmt"
+)
+
+type valueType int
+
+const (
+	typeAny valueType = iota // Only known at evaluation time, e.g. metadata values
+	typeBool
+	typeNumber
+	typeString
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeBool:
+		return "boolean"
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	default:
+		return "any"
+	}
+}
+
+// accepts reports whether a value of this type may be used where the other type is expected
+func (t valueType) accepts(other valueType) bool {
+	return t == typeAny || other == typeAny || t == other
+}
+
+type node interface {
+	typ() valueType
+	eval(ctx *Context) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+	t     valueType
+}
+
+func (n *literalNode) typ() valueType { return n.t }
+
+func (n *literalNode) eval(_ *Context) (interface{}, error) { return n.value, nil }
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) typ() valueType { return typeBool }
+
+func (n *notNode) eval(ctx *Context) (interface{}, error) {
+	value, err := n.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := asBool(value)
+	return !b, err
+}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func newLogical(op token, left node, right node) (node, error) {
+	for _, operand := range []node{left, right} {
+		if !operand.typ().accepts(typeBool) {
+			return nil, fmt.Errorf("operator %q at position %d needs booleans, got %s", op.text, op.pos, operand.typ())
+		}
+	}
+	return &logicalNode{and: op.kind == tokenAnd, left: left, right: right}, nil
+}
+
+func (n *logicalNode) typ() valueType { return typeBool }
+
+// eval short-circuits like Go's && and ||
+func (n *logicalNode) eval(ctx *Context) (interface{}, error) {
+	value, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	left, err := asBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if left != n.and {
+		return left, nil
+	}
+
+	value, err = n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return asBool(value)
+}
+
+type comparisonNode struct {
+	op          tokenKind
+	text        string
+	left, right node
+}
+
+func newComparison(op token, left node, right node) (node, error) {
+	if op.kind == tokenEq || op.kind == tokenNeq {
+		if !left.typ().accepts(right.typ()) {
+			return nil, fmt.Errorf("cannot compare %s with %s at position %d", left.typ(), right.typ(), op.pos)
+		}
+	} else {
+		for _, operand := range []node{left, right} {
+			if !operand.typ().accepts(typeNumber) {
+				return nil, fmt.Errorf("operator %q at position %d needs numbers, got %s", op.text, op.pos, operand.typ())
+			}
+		}
+	}
+	return &comparisonNode{op: op.kind, text: op.text, left: left, right: right}, nil
+}
+
+func (n *comparisonNode) typ() valueType { return typeBool }
+
+func (n *comparisonNode) eval(ctx *Context) (interface{}, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case tokenEq:
+		return equal(left, right), nil
+	case tokenNeq:
+		return !equal(left, right), nil
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %q needs numbers, got %v and %v", n.text, left, right)
+	}
+
+	switch n.op {
+	case tokenLt:
+		return l < r, nil
+	case tokenLte:
+		return l <= r, nil
+	case tokenGt:
+		return l > r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+type callNode struct {
+	name string
+	fn   function
+	arg  string
+}
+
+func (n *callNode) typ() valueType { return n.fn.result }
+
+func (n *callNode) eval(ctx *Context) (interface{}, error) {
+	return n.fn.call(ctx, n.arg), nil
+}
+
+type metadataNode struct {
+	path []string
+}
+
+func (n *metadataNode) typ() valueType { return typeAny }
+
+// eval walks the metadata path, missing keys evaluate to nil
+func (n *metadataNode) eval(ctx *Context) (interface{}, error) {
+	var current interface{} = ctx.Metadata
+	for _, key := range n.path {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		current = object[key]
+	}
+
+	// Normalize JSON numbers so they compare with number literals
+	switch value := current.(type) {
+	case int:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	}
+	return current, nil
+}
+
+// equal compares two evaluated values, values of different types are never equal
+func equal(left interface{}, right interface{}) bool {
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		return ok && l == r
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	case nil:
+		return right == nil
+	}
+	return false
+}
+
+// asBool converts an evaluated value to a boolean, failing for non booleans
+func asBool(value interface{}) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, got %v", value)
+	}
+	return b, nil
+}
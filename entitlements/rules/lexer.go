@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenEq
+	tokenNeq
+	tokenLt
+	tokenLte
+	tokenGt
+	tokenGte
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenDot
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value float64 // Parsed value of number tokens
+}
+
+// keywords are the word forms of the boolean operators, matched case-insensitively
+var keywords = map[string]tokenKind{
+	"and": tokenAnd,
+	"or":  tokenOr,
+	"not": tokenNot,
+}
+
+// tokenize splits an expression into tokens
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, pos: start, value: value})
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			text := string(runes[start:i])
+			if kind, ok := keywords[strings.ToLower(text)]; ok {
+				tokens = append(tokens, token{kind: kind, text: text, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: text, pos: start})
+			}
+		default:
+			kind, width, err := operator(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[i : i+width]), pos: i})
+			i += width
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// operator matches the punctuation token starting at position i
+func operator(runes []rune, i int) (tokenKind, int, error) {
+	next := rune(0)
+	if i+1 < len(runes) {
+		next = runes[i+1]
+	}
+
+	switch runes[i] {
+	case '&':
+		if next == '&' {
+			return tokenAnd, 2, nil
+		}
+	case '|':
+		if next == '|' {
+			return tokenOr, 2, nil
+		}
+	case '=':
+		if next == '=' {
+			return tokenEq, 2, nil
+		}
+	case '!':
+		if next == '=' {
+			return tokenNeq, 2, nil
+		}
+		return tokenNot, 1, nil
+	case '<':
+		if next == '=' {
+			return tokenLte, 2, nil
+		}
+		return tokenLt, 1, nil
+	case '>':
+		if next == '=' {
+			return tokenGte, 2, nil
+		}
+		return tokenGt, 1, nil
+	case '(':
+		return tokenLParen, 1, nil
+	case ')':
+		return tokenRParen, 1, nil
+	case ',':
+		return tokenComma, 1, nil
+	case '.':
+		return tokenDot, 1, nil
+	}
+
+	return tokenEOF, 0, fmt.Errorf("unexpected character %q at position %d", runes[i], i)
+}
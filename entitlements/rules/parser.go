@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"fmt"
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s at position %d, got %q", what, t.pos, t.text)
+	}
+	return t, nil
+}
+
+// parseOr parses: and ("||" and)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: not ("&&" not)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+
+	return left, nil
+}
+
+// parseNot parses: "!" not | comparison
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind == tokenNot {
+		op := p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if !operand.typ().accepts(typeBool) {
+			return nil, fmt.Errorf("operator %q at position %d needs a boolean, got %s", op.text, op.pos, operand.typ())
+		}
+		return &notNode{operand: operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: primary (op primary)?
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.peek().kind {
+	case tokenEq, tokenNeq, tokenLt, tokenLte, tokenGt, tokenGte:
+		op := p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return newComparison(op, left, right)
+	}
+
+	return left, nil
+}
+
+// parsePrimary parses literals, function calls, metadata paths and parenthesized expressions
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		return &literalNode{value: t.value, t: typeNumber}, nil
+	case tokenString:
+		return &literalNode{value: t.text, t: typeString}, nil
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokenIdent:
+		return p.parseIdent(t)
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// parseIdent parses booleans, metadata.path lookups and name("arg") calls
+func (p *parser) parseIdent(t token) (node, error) {
+	switch t.text {
+	case "true":
+		return &literalNode{value: true, t: typeBool}, nil
+	case "false":
+		return &literalNode{value: false, t: typeBool}, nil
+	case "metadata":
+		var path []string
+		for p.peek().kind == tokenDot {
+			p.next()
+			segment, err := p.expect(tokenIdent, "metadata key")
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, segment.text)
+		}
+		if len(path) == 0 {
+			return nil, fmt.Errorf("metadata at position %d needs a key, e.g. metadata.region", t.pos)
+		}
+		return &metadataNode{path: path}, nil
+	}
+
+	fn, ok := functions[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown identifier %q at position %d", t.text, t.pos)
+	}
+
+	if _, err := p.expect(tokenLParen, "'(' after "+t.text); err != nil {
+		return nil, err
+	}
+	arg, err := p.expect(tokenString, "string argument to "+t.text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	return &callNode{name: t.text, fn: fn, arg: arg.text}, nil
+}
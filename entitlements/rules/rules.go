@@ -0,0 +1,131 @@
+// Package rules implements the small expression language used for entitlement rules, e.g.
+//
+//	has_plan("pro") || has_product("prod_addon_x")
+//	quantity("seats_addon") >= 10 and metadata.region == "EU"
+//
+// Expressions are compiled (parsed and type checked) once at startup and evaluated against
+// an organization's Context. They can't loop, call out or mutate anything.
+package rules
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Item is a single subscription item of the organization
+type Item struct {
+	SubscriptionID string `json:"subscription_id"`
+	PriceID        string `json:"price_id"`
+	ProductID      string `json:"product_id"`
+	PlanID         string `json:"plan_id,omitempty"` // Plan catalog ID the item maps to, empty if unmapped
+	Quantity       int64  `json:"quantity"`
+}
+
+// Context is the organization state rules are evaluated against
+type Context struct {
+	Plans     []string               `json:"plans"`     // Plan catalog IDs of the active subscriptions, add-ons included
+	Items     []Item                 `json:"items"`     // Items of the active subscriptions
+	Features  map[string]bool        `json:"features"`  // Features granted before rules are applied
+	Limits    map[string]int64       `json:"limits"`    // Limits granted before rules are applied
+	Overrides map[string]bool        `json:"overrides"` // Features granted or revoked manually for the organization
+	Usage     map[string]int64       `json:"usage"`     // Current usage of limited features
+	Metadata  map[string]interface{} `json:"metadata"`  // The organization's public metadata
+}
+
+// Program is a compiled rule expression
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses and type checks a rule expression, which must evaluate to a boolean
+func Compile(expression string) (*Program, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	if root.typ() != typeBool && root.typ() != typeAny {
+		return nil, fmt.Errorf("expression must be a boolean, got %s", root.typ())
+	}
+
+	return &Program{source: expression, root: root}, nil
+}
+
+// Evaluate runs the program against the context
+func (p *Program) Evaluate(ctx Context) (bool, error) {
+	value, err := p.root.eval(&ctx)
+	if err != nil {
+		return false, err
+	}
+	return asBool(value)
+}
+
+// String returns the source expression of the program
+func (p *Program) String() string {
+	return p.source
+}
+
+// Evaluate compiles and runs an expression against the context in one step
+func Evaluate(expression string, ctx Context) (bool, error) {
+	program, err := Compile(expression)
+	if err != nil {
+		return false, err
+	}
+	return program.Evaluate(ctx)
+}
+
+// function is a built-in callable from expressions, every built-in takes a single string argument
+type function struct {
+	result valueType
+	call   func(ctx *Context, arg string) interface{}
+}
+
+var functions = map[string]function{
+	// has_plan("pro") is true if an active subscription maps to the plan
+	"has_plan": {typeBool, func(ctx *Context, arg string) interface{} {
+		return slices.Contains(ctx.Plans, arg)
+	}},
+	// has_price("price_123") is true if an active subscription item uses the price
+	"has_price": {typeBool, func(ctx *Context, arg string) interface{} {
+		return slices.ContainsFunc(ctx.Items, func(item Item) bool { return item.PriceID == arg })
+	}},
+	// has_product("prod_123") is true if an active subscription item uses the product
+	"has_product": {typeBool, func(ctx *Context, arg string) interface{} {
+		return slices.ContainsFunc(ctx.Items, func(item Item) bool { return item.ProductID == arg })
+	}},
+	// quantity("pro") sums the quantities of the items whose plan, price or product matches
+	"quantity": {typeNumber, func(ctx *Context, arg string) interface{} {
+		var total int64
+		for _, item := range ctx.Items {
+			if item.PlanID == arg || item.PriceID == arg || item.ProductID == arg {
+				total += item.Quantity
+			}
+		}
+		return float64(total)
+	}},
+	// has("sso") is true if the feature is granted by a plan, Stripe or an override
+	"has": {typeBool, func(ctx *Context, arg string) interface{} {
+		return ctx.Features[arg]
+	}},
+	// limit("seats") is the granted limit of the feature, 0 if it has none
+	"limit": {typeNumber, func(ctx *Context, arg string) interface{} {
+		return float64(ctx.Limits[arg])
+	}},
+	// usage("api_calls") is the current usage of the feature, 0 if unknown
+	"usage": {typeNumber, func(ctx *Context, arg string) interface{} {
+		return float64(ctx.Usage[arg])
+	}},
+	// override("beta") is true if the feature was granted manually to the organization, false if revoked or not overridden
+	"override": {typeBool, func(ctx *Context, arg string) interface{} {
+		return ctx.Overrides[arg]
+	}},
+}
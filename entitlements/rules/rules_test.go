@@ -0,0 +1,186 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func testContext() Context {
+	return Context{
+		Plans: []string{"pro"},
+		Items: []Item{
+			{SubscriptionID: "sub_1", PriceID: "price_pro", ProductID: "prod_pro", PlanID: "pro", Quantity: 1},
+			{SubscriptionID: "sub_1", PriceID: "price_seats", ProductID: "prod_seats", PlanID: "seats_addon", Quantity: 4},
+			{SubscriptionID: "sub_2", PriceID: "price_seats", ProductID: "prod_seats", PlanID: "seats_addon", Quantity: 6},
+		},
+		Features:  map[string]bool{"sso": true},
+		Limits:    map[string]int64{"projects": 10},
+		Overrides: map[string]bool{"beta": true, "legacy": false},
+		Usage:     map[string]int64{"api_calls": 500},
+		Metadata: map[string]interface{}{
+			"region":   "EU",
+			"verified": true,
+			"seats":    int64(12),
+			"score":    4.5,
+			"company": map[string]interface{}{
+				"size": 250.0,
+				"tier": "enterprise",
+			},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{
+		// Built-ins
+		{name: "has_plan", expression: `has_plan("pro")`, want: true},
+		{name: "has_plan missing", expression: `has_plan("enterprise")`, want: false},
+		{name: "has_price", expression: `has_price("price_seats")`, want: true},
+		{name: "has_product", expression: `has_product("prod_other")`, want: false},
+		{name: "quantity sums items", expression: `quantity("seats_addon") == 10`, want: true},
+		{name: "quantity by price", expression: `quantity("price_pro") >= 1`, want: true},
+		{name: "has", expression: `has("sso")`, want: true},
+		{name: "limit", expression: `limit("projects") > 5`, want: true},
+		{name: "limit missing is zero", expression: `limit("members") == 0`, want: true},
+		{name: "usage", expression: `usage("api_calls") < 1000`, want: true},
+		{name: "override granted", expression: `override("beta")`, want: true},
+		{name: "override revoked", expression: `override("legacy")`, want: false},
+
+		// Operator precedence
+		{name: "and binds tighter than or", expression: `true || false && false`, want: true},
+		{name: "and binds tighter than or on the left", expression: `false && false || true`, want: true},
+		{name: "parentheses", expression: `(true || false) && false`, want: false},
+		{name: "not binds tighter than and", expression: `!false && false`, want: false},
+		{name: "not applies to a comparison", expression: `!1 > 2`, want: true},
+		{name: "double not", expression: `!!true`, want: true},
+		{name: "keywords", expression: `has_plan("pro") AND not has("audit") or false`, want: true},
+		{name: "comparison inside logic", expression: `quantity("seats_addon") >= 10 && limit("projects") != 10`, want: false},
+
+		// Metadata paths
+		{name: "metadata string", expression: `metadata.region == "EU"`, want: true},
+		{name: "metadata boolean", expression: `metadata.verified`, want: true},
+		{name: "metadata integer is a number", expression: `metadata.seats == 12`, want: true},
+		{name: "metadata float", expression: `metadata.score > 4`, want: true},
+		{name: "metadata nested path", expression: `metadata.company.tier == 'enterprise' && metadata.company.size >= 100`, want: true},
+		{name: "metadata types never equal", expression: `metadata.seats == "12"`, want: false},
+		{name: "metadata types differ with !=", expression: `metadata.region != 1`, want: true},
+
+		// Missing keys
+		{name: "missing key equals nothing", expression: `metadata.plan == "pro"`, want: false},
+		{name: "missing key is not equal", expression: `metadata.plan != "pro"`, want: true},
+		{name: "missing nested key", expression: `metadata.company.owner.name == "x"`, want: false},
+		{name: "path through a scalar", expression: `metadata.region.code == "x"`, want: false},
+
+		// Short-circuiting skips operands that would fail
+		{name: "or short-circuits", expression: `true || metadata.missing > 1`, want: true},
+		{name: "and short-circuits", expression: `false && metadata.missing`, want: false},
+		{name: "and evaluates the right when left is true", expression: `true && metadata.verified`, want: true},
+	}
+
+	ctx := testContext()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Evaluate(test.expression, ctx)
+			if err != nil {
+				t.Fatalf("Evaluate(%q) error: %v", test.expression, err)
+			}
+			if got != test.want {
+				t.Errorf("Evaluate(%q) = %v, want %v", test.expression, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{
+		// Type errors caught when compiling
+		{name: "boolean compared as number", expression: `has_plan("pro") > 1`, wantErr: "needs numbers"},
+		{name: "number used with and", expression: `quantity("seats") && true`, wantErr: "needs booleans"},
+		{name: "number used with or", expression: `true || limit("projects")`, wantErr: "needs booleans"},
+		{name: "not of a number", expression: `!usage("api_calls")`, wantErr: "needs a boolean"},
+		{name: "string compared with number", expression: `"a" == 1`, wantErr: "cannot compare"},
+		{name: "string ordered", expression: `"a" < "b"`, wantErr: "needs numbers"},
+		{name: "non boolean result", expression: `quantity("seats")`, wantErr: "must be a boolean"},
+
+		// Syntax errors
+		{name: "unknown function", expression: `has_team("x")`, wantErr: "unknown identifier"},
+		{name: "missing argument", expression: `has_plan()`, wantErr: "string argument"},
+		{name: "number argument", expression: `has_plan(1)`, wantErr: "string argument"},
+		{name: "bare metadata", expression: `metadata == 1`, wantErr: "needs a key"},
+		{name: "unclosed parenthesis", expression: `(true || false`, wantErr: "expected ')'"},
+		{name: "trailing tokens", expression: `true false`, wantErr: "unexpected"},
+		{name: "chained comparison", expression: `1 < 2 < 3`, wantErr: "unexpected"},
+		{name: "unterminated string", expression: `metadata.region == "EU`, wantErr: "unterminated string"},
+		{name: "invalid number", expression: `limit("x") > 1.2.3`, wantErr: "invalid number"},
+		{name: "unexpected character", expression: `true & false`, wantErr: "unexpected character"},
+		{name: "empty", expression: ``, wantErr: "unexpected"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Compile(test.expression)
+			if err == nil {
+				t.Fatalf("Compile(%q) succeeded, want an error containing %q", test.expression, test.wantErr)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Compile(%q) error = %q, want it to contain %q", test.expression, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	// Metadata values are only typed at evaluation time, so these compile but fail when run
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "string ordered as number", expression: `metadata.region > 1`},
+		{name: "missing key ordered as number", expression: `metadata.missing >= 0`},
+		{name: "string used as boolean", expression: `metadata.region && true`},
+		{name: "missing key used as boolean", expression: `metadata.missing`},
+		{name: "not of a missing key", expression: `!metadata.missing`},
+		{name: "right operand evaluated when needed", expression: `false || metadata.region`},
+	}
+
+	ctx := testContext()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatalf("Compile(%q) error: %v", test.expression, err)
+			}
+			if got, err := program.Evaluate(ctx); err == nil {
+				t.Errorf("Evaluate(%q) = %v, want an error", test.expression, got)
+			}
+		})
+	}
+}
+
+func TestProgramIsReusable(t *testing.T) {
+	program, err := Compile(`metadata.region == "EU"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if program.String() != `metadata.region == "EU"` {
+		t.Errorf("String() = %q", program.String())
+	}
+
+	for region, want := range map[string]bool{"EU": true, "US": false} {
+		got, err := program.Evaluate(Context{Metadata: map[string]interface{}{"region": region}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("region %s: got %v, want %v", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package entitlements
+
+import (
+	"slices"
+	"time"
+)
+
+// SubscriptionItem is a single item of a subscription mirrored in metadata
+type SubscriptionItem struct {
+	ID               string
+	PriceID          string
+	ProductID        string
+	Quantity         int64
+	CurrentPeriodEnd int64
+}
+
+// SubscriptionItems returns the items of a subscription mirrored in metadata
+// Subscriptions mirrored before items were tracked only have their first item's price and product at the top level
+func SubscriptionItems(subscription map[string]interface{}) []SubscriptionItem {
+	rawItems, ok := subscription["items"].([]interface{})
+	if !ok {
+		item := SubscriptionItem{Quantity: 1}
+		item.PriceID, _ = subscription["price_id"].(string)
+		item.ProductID, _ = subscription["product_id"].(string)
+		if quantity, ok := MetadataInt(subscription["quantity"]); ok {
+			item.Quantity = quantity
+		}
+		item.CurrentPeriodEnd, _ = MetadataInt(subscription["current_period_end"])
+		return []SubscriptionItem{item}
+	}
+
+	items := make([]SubscriptionItem, 0, len(rawItems))
+	for _, rawItem := range rawItems {
+		itemMap, ok := rawItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		item := SubscriptionItem{Quantity: 1}
+		item.ID, _ = itemMap["id"].(string)
+		item.PriceID, _ = itemMap["price_id"].(string)
+		item.ProductID, _ = itemMap["product_id"].(string)
+		if quantity, ok := MetadataInt(itemMap["quantity"]); ok {
+			item.Quantity = quantity
+		}
+		item.CurrentPeriodEnd, _ = MetadataInt(itemMap["current_period_end"])
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// MetadataInt reads a number from metadata, which is a float64 once decoded from JSON
+// but still an integer when the metadata was just built in memory
+func MetadataInt(value interface{}) (int64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return int64(number), true
+	case int64:
+		return number, true
+	case int:
+		return int64(number), true
+	default:
+		return 0, false
+	}
+}
+
+// CurrentPeriod returns the current billing period: that of the active subscription granting the feature (the earliest
+// ending one if several do), of any active subscription when none in grantedBy is, or the calendar month (UTC) for
+// owners without subscriptions. Quotas and spend estimates both use it, so their periods always line up
+func CurrentPeriod(subscriptions []map[string]interface{}, grantedBy []string, now time.Time) (time.Time, time.Time) {
+	var granting, earliest map[string]interface{}
+	var grantingEnd, earliestEnd int64
+	for _, subscription := range subscriptions {
+		periodEnd, ok := MetadataInt(subscription["current_period_end"])
+		if !ok || periodEnd <= now.Unix() {
+			continue
+		}
+
+		if earliest == nil || periodEnd < earliestEnd {
+			earliest, earliestEnd = subscription, periodEnd
+		}
+		if id, _ := subscription["id"].(string); slices.Contains(grantedBy, id) && (granting == nil || periodEnd < grantingEnd) {
+			granting, grantingEnd = subscription, periodEnd
+		}
+	}
+
+	current, end := granting, grantingEnd
+	if current == nil {
+		current, end = earliest, earliestEnd
+	}
+	if current == nil {
+		year, month, _ := now.UTC().Date()
+		start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	periodEnd := time.Unix(end, 0).UTC()
+	// Subscriptions mirrored before period starts were tracked are assumed to renew monthly until their next webhook
+	start, ok := MetadataInt(current["current_period_start"])
+	if !ok || start <= 0 {
+		return periodEnd.AddDate(0, -1, 0), periodEnd
+	}
+	return time.Unix(start, 0).UTC(), periodEnd
+}
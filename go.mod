@@ -8,6 +8,7 @@ require (
 	github.com/stripe/stripe-go/v82 v82.2.1
 	github.com/supabase-community/supabase-go v0.0.4
 	github.com/svix/svix-webhooks v1.68.0
+	go.mongodb.org/mongo-driver/v2 v2.2.2
 )
 
 require (
@@ -25,7 +26,6 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
-	go.mongodb.org/mongo-driver/v2 v2.2.2 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
@@ -0,0 +1,177 @@
+package holds
+
+import (
+	"errors"
+	"log"
+	"nucleus/clerk"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Policy is what happens to an owner's access when a payment event places a hold
+type Policy string
+
+const (
+	PolicySuspend Policy = "suspend" // Remove every feature until the hold is released
+	PolicyFlag    Policy = "flag"    // Keep access but mark the owner for review
+	PolicyIgnore  Policy = "ignore"  // Do nothing
+)
+
+const (
+	// EventAccessHoldPlaced is recorded in the billing history when a hold is placed
+	EventAccessHoldPlaced = "access_hold.placed"
+	// EventAccessHoldReleased is recorded when a hold is released
+	EventAccessHoldReleased = "access_hold.released"
+)
+
+// StripeActor is the actor of holds placed and released by Stripe events
+const StripeActor = "stripe"
+
+// policies is the configured policy of each hold reason
+var policies = map[mongodbTypes.HoldReason]Policy{}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	policies[mongodbTypes.HoldReasonDispute] = policyFromEnv("DISPUTE_POLICY", PolicySuspend)
+	policies[mongodbTypes.HoldReasonRefund] = policyFromEnv("REFUND_POLICY", PolicyFlag)
+	policies[mongodbTypes.HoldReasonEarlyFraudWarning] = policyFromEnv("EARLY_FRAUD_WARNING_POLICY", PolicyFlag)
+
+	if err := mongodb.EnsureAccessHoldIndexes(); err != nil {
+		log.Fatalf("Error creating access hold indexes: %v", err)
+	}
+}
+
+// policyFromEnv returns the policy set in the environment variable, or the fallback if it's unset or invalid
+func policyFromEnv(key string, fallback Policy) Policy {
+	switch policy := Policy(os.Getenv(key)); policy {
+	case "":
+		return fallback
+	case PolicySuspend, PolicyFlag, PolicyIgnore:
+		return policy
+	default:
+		log.Printf("Warning: invalid %s %q, using %s", key, policy, fallback)
+		return fallback
+	}
+}
+
+// Hold describes the payment event a hold is placed for
+type Hold struct {
+	Reason      mongodbTypes.HoldReason
+	ReferenceID string // ID of the dispute, refunded charge or early fraud warning
+	ChargeID    string
+	Amount      int64
+	Currency    string
+}
+
+// Place applies the reason's policy to the customer's owner, suspending or flagging it and republishing its entitlements
+// Placing a hold for the same event again does nothing
+func Place(customerId string, hold Hold) error {
+	policy := policies[hold.Reason]
+	if policy == PolicyIgnore {
+		log.Printf("[HOLDS] Ignoring %s %s of customer %s", hold.Reason, hold.ReferenceID, customerId)
+		return nil
+	}
+
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	created, ok, err := mongodb.CreateAccessHold(mongodbTypes.AccessHold{
+		OwnerID:          owner.OwnerID(),
+		StripeCustomerID: customerId,
+		Reason:           hold.Reason,
+		Action:           mongodbTypes.HoldAction(policy),
+		ReferenceID:      hold.ReferenceID,
+		ChargeID:         hold.ChargeID,
+		Amount:           hold.Amount,
+		Currency:         hold.Currency,
+		CreatedAt:        time.Now(),
+	})
+	if err != nil || !ok {
+		return err
+	}
+
+	recordEvent(owner, EventAccessHoldPlaced, StripeActor, created)
+	log.Printf("[HOLDS] Placed %s hold on %s for %s %s", created.Action, created.OwnerID, created.Reason, created.ReferenceID)
+	return clerk.RefreshOwnerEntitlements(owner)
+}
+
+// Release releases the hold placed for a payment event, like a dispute that was won, and restores access
+// Releasing an event without an active hold does nothing
+func Release(reason mongodbTypes.HoldReason, referenceID string, releasedBy string, releaseReason string) error {
+	hold, err := mongodb.ReleaseAccessHold(reason, referenceID, releasedBy, releaseReason)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return afterRelease(hold)
+}
+
+// ReleaseByID releases an active hold of the owner, returning mongo.ErrNoDocuments if there is none
+// A failed refresh is only logged: the hold is released and stops applying on the next recompute
+func ReleaseByID(ownerID string, id bson.ObjectID, releasedBy string, releaseReason string) (mongodbTypes.AccessHold, error) {
+	hold, err := mongodb.ReleaseAccessHoldByID(ownerID, id, releasedBy, releaseReason)
+	if err != nil {
+		return mongodbTypes.AccessHold{}, err
+	}
+
+	if err := afterRelease(hold); err != nil {
+		log.Printf("Error refreshing entitlements of %s after releasing hold %s: %v", ownerID, hold.ID.Hex(), err)
+	}
+	return hold, nil
+}
+
+// List returns every hold of the owner, newest first
+func List(ownerID string) ([]mongodbTypes.AccessHold, error) {
+	return mongodb.ListAccessHolds(ownerID)
+}
+
+// afterRelease records the release and republishes the owner's entitlements
+func afterRelease(hold mongodbTypes.AccessHold) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(hold.StripeCustomerID)
+	if err != nil {
+		return err
+	}
+
+	recordEvent(owner, EventAccessHoldReleased, hold.ReleasedBy, hold)
+	log.Printf("[HOLDS] Released %s hold on %s for %s %s", hold.Action, hold.OwnerID, hold.Reason, hold.ReferenceID)
+	return clerk.RefreshOwnerEntitlements(owner)
+}
+
+// recordEvent appends a hold event to the organization's billing history, users billed personally have none
+func recordEvent(owner mongodbTypes.Organization, eventType string, actor string, hold mongodbTypes.AccessHold) {
+	if owner.IsUser() {
+		return
+	}
+
+	err := mongodb.CreateBillingEvent(mongodbTypes.BillingEvent{
+		OrganizationID: owner.ClerkID,
+		Type:           eventType,
+		Actor:          actor,
+		Data: map[string]interface{}{
+			"hold_id":      hold.ID.Hex(),
+			"reason":       hold.Reason,
+			"action":       hold.Action,
+			"reference_id": hold.ReferenceID,
+			"charge_id":    hold.ChargeID,
+			"amount":       hold.Amount,
+			"currency":     hold.Currency,
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording %s in billing history: %v", eventType, err)
+	}
+}
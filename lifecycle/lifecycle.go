@@ -0,0 +1,233 @@
+package lifecycle
+
+import (
+	"fmt"
+	"log"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/config"
+	"nucleus/entitlements"
+	"nucleus/lifecycle/machine"
+	"nucleus/mongodb"
+	catalogTypes "nucleus/types/catalog"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stripe/stripe-go/v82/subscription"
+)
+
+// maxTransitionAttempts is how many times a transition is retried when concurrent events change the lifecycle
+const maxTransitionAttempts = 5
+
+// EventBillingStateChanged is recorded in the billing history on every transition
+const EventBillingStateChanged = "billing_state.changed"
+
+// Causes of the transitions fired by timers rather than Stripe events
+const (
+	CausePastDueExpired = "timer.past_due_expired"
+	CauseGraceExpired   = "timer.grace_expired"
+)
+
+// pastDuePeriod is how long an owner stays past due before entering grace
+var pastDuePeriod time.Duration
+
+// gracePeriod is how long the grace state lasts before the end action when the plan's grace policy sets no days
+var gracePeriod time.Duration
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	pastDuePeriod = config.Duration("BILLING_PAST_DUE_PERIOD", 72*time.Hour)
+	gracePeriod = config.Duration("BILLING_GRACE_PERIOD", 7*24*time.Hour)
+
+	if err := mongodb.EnsureBillingStateIndexes(); err != nil {
+		log.Fatalf("Error creating billing state indexes: %v", err)
+	}
+}
+
+// Cause is the Stripe event or timer that triggers a transition
+type Cause = machine.Cause
+
+// ApplySubscriptionStatus moves the customer's owner to the state matching a subscription's status
+// Statuses in the grace statuses of the subscription's plan move it to past due, like a failed payment
+// When the subscription ends while the owner still pays for another one, that one drives the state instead of the owner being canceled
+func ApplySubscriptionStatus(customerId string, subscriptionID string, status string, cause Cause) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	policy, _ := subscriptionGrace(owner, subscriptionID)
+	if slices.Contains(policy.GraceStatuses, status) {
+		return transition(owner, subscriptionID, mongodbTypes.BillingStatePastDue, nil, cause)
+	}
+
+	state, ok := machine.StateFromSubscriptionStatus(status)
+	if !ok {
+		return nil
+	}
+	if state == mongodbTypes.BillingStateCanceled {
+		if otherID, otherState, ok := runningSubscription(owner, subscriptionID); ok {
+			log.Printf("[LIFECYCLE] Subscription %s of %s ended, %s is still %s", subscriptionID, owner.OwnerID(), otherID, otherState)
+			return transition(owner, otherID, otherState, nil, cause)
+		}
+	}
+	return transition(owner, subscriptionID, state, nil, cause)
+}
+
+// runningSubscription returns another subscription mirrored in the owner's metadata that is active, or trialing if none is
+func runningSubscription(owner mongodbTypes.Organization, subscriptionID string) (string, mongodbTypes.BillingState, bool) {
+	subscriptions, err := clerk.ListOwnerSubscriptions(owner)
+	if err != nil {
+		log.Printf("Error listing subscriptions of %s: %v", owner.OwnerID(), err)
+		return "", "", false
+	}
+	return machine.RunningSubscription(subscriptions, subscriptionID)
+}
+
+// ApplyPaymentFailed moves the customer's owner to past due after a subscription invoice payment failed
+// The timer sweep moves it on into grace once it stayed past due for BILLING_PAST_DUE_PERIOD
+func ApplyPaymentFailed(customerId string, subscriptionID string, cause Cause) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	return transition(owner, subscriptionID, mongodbTypes.BillingStatePastDue, nil, cause)
+}
+
+// ApplyPaymentSucceeded restores a past due, grace or suspended owner to active after a subscription invoice was paid
+func ApplyPaymentSucceeded(customerId string, subscriptionID string, cause Cause) error {
+	owner, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+
+	return transition(owner, subscriptionID, mongodbTypes.BillingStateActive, nil, cause)
+}
+
+// Get returns the billing lifecycle of the owner with its recent transitions
+func Get(ownerID string) (mongodbTypes.BillingLifecycle, error) {
+	return mongodb.GetBillingLifecycle(ownerID)
+}
+
+// subscriptionGrace returns the grace policy of the subscription's plan and when the subscription stopped being paid
+// The subscription is read from the owner's metadata, which the webhook updates before the lifecycle, and the grace
+// period is counted from now if it isn't marked unpaid yet, e.g. when the failed invoice arrives before the subscription update
+func subscriptionGrace(owner mongodbTypes.Organization, subscriptionID string) (catalogTypes.GracePolicy, time.Time) {
+	subscription, ok := clerk.GetOwnerSubscription(owner, subscriptionID)
+	if !ok {
+		return catalog.GracePolicyFor(""), time.Now()
+	}
+
+	since := time.Now()
+	if pastDueSince, ok := entitlements.MetadataInt(subscription["past_due_since"]); ok {
+		since = time.Unix(pastDueSince, 0)
+	}
+	return entitlements.SubscriptionGracePolicy(subscription), since
+}
+
+// startGrace moves a past due owner into grace when the past due period ended
+// Grace ends with the plan's grace period counted from the first failed payment, the same end the entitlements use,
+// or after BILLING_GRACE_PERIOD when the plan sets no grace days. A grace period that's already over is ended right away
+func startGrace(owner mongodbTypes.Organization, subscriptionID string, cause Cause) error {
+	policy, since := subscriptionGrace(owner, subscriptionID)
+
+	graceEndsAt := machine.GraceEndsAt(policy, since, time.Now(), gracePeriod)
+	if graceEndsAt.After(time.Now()) {
+		return transition(owner, subscriptionID, mongodbTypes.BillingStateGrace, &graceEndsAt, cause)
+	}
+	return endGrace(owner, subscriptionID, policy, cause)
+}
+
+// endGrace applies the policy's end action: the owner is suspended, or the subscription is canceled in Stripe
+func endGrace(owner mongodbTypes.Organization, subscriptionID string, policy catalogTypes.GracePolicy, cause Cause) error {
+	if policy.OnEnd == catalogTypes.GraceOnEndCancel && subscriptionID != "" {
+		if _, err := subscription.Cancel(subscriptionID, nil); err != nil {
+			return fmt.Errorf("canceling subscription %s: %w", subscriptionID, err)
+		}
+		log.Printf("[LIFECYCLE] Canceled subscription %s of %s after its grace period", subscriptionID, owner.OwnerID())
+		return transition(owner, subscriptionID, mongodbTypes.BillingStateCanceled, nil, cause)
+	}
+
+	return transition(owner, subscriptionID, mongodbTypes.BillingStateSuspended, nil, cause)
+}
+
+// transition moves the owner's lifecycle to a new state if the state machine allows it and records why
+func transition(owner mongodbTypes.Organization, subscriptionID string, to mongodbTypes.BillingState, graceEndsAt *time.Time, cause Cause) error {
+	for attempt := 0; attempt < maxTransitionAttempts; attempt++ {
+		lifecycle, err := mongodb.GetBillingLifecycle(owner.OwnerID())
+		if err != nil {
+			return err
+		}
+
+		if lifecycle.State == to {
+			if !machine.AdoptsSubscription(lifecycle, subscriptionID, cause) {
+				return nil
+			}
+
+			lifecycle = machine.Adopt(lifecycle, subscriptionID, cause)
+			updated, err := mongodb.UpdateBillingLifecycleSubscription(lifecycle)
+			if err != nil {
+				return err
+			}
+			if !updated {
+				continue
+			}
+			log.Printf("[LIFECYCLE] %s stays %s, now driven by subscription %s on %s", lifecycle.OwnerID, to, subscriptionID, cause.Type)
+			return nil
+		}
+		if !machine.ShouldTransition(lifecycle, subscriptionID, to, cause) {
+			return nil
+		}
+
+		from := lifecycle.State
+		lifecycle.StripeCustomerID = owner.StripeCustomerID
+		lifecycle, record := machine.Transition(lifecycle, subscriptionID, to, graceEndsAt, cause, time.Now())
+		updated, err := mongodb.UpdateBillingLifecycle(lifecycle, record)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			continue
+		}
+
+		log.Printf("[LIFECYCLE] %s moved from %s to %s on %s", lifecycle.OwnerID, from, to, cause.Type)
+		recordEvent(owner, record)
+		return clerk.RefreshOwnerEntitlements(owner)
+	}
+
+	return fmt.Errorf("billing lifecycle of %s kept changing, %s left unapplied", owner.OwnerID(), cause.Type)
+}
+
+// recordEvent appends a transition to the organization's billing history, users billed personally have none
+func recordEvent(owner mongodbTypes.Organization, transition mongodbTypes.BillingTransition) {
+	if owner.IsUser() {
+		return
+	}
+
+	actor := "stripe"
+	if transition.EventID == "" {
+		actor = "nucleus"
+	}
+
+	err := mongodb.CreateBillingEvent(mongodbTypes.BillingEvent{
+		OrganizationID: owner.ClerkID,
+		Type:           EventBillingStateChanged,
+		Actor:          actor,
+		Data: map[string]interface{}{
+			"from":            transition.From,
+			"to":              transition.To,
+			"cause":           transition.Cause,
+			"event_id":        transition.EventID,
+			"subscription_id": transition.SubscriptionID,
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording %s in billing history: %v", EventBillingStateChanged, err)
+	}
+}
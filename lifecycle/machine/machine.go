@@ -0,0 +1,159 @@
+// Package machine decides the transitions of the billing lifecycle state machine
+// It only works on lifecycle values, reading and storing them is left to the lifecycle package
+package machine
+
+import (
+	"log"
+	catalogTypes "nucleus/types/catalog"
+	mongodbTypes "nucleus/types/mongodb"
+	"slices"
+	"time"
+)
+
+// allowed lists the states each state can move to, transitions outside of it are ignored
+// so late or out of order events can't, for example, move a suspended owner back to past due
+var allowed = map[mongodbTypes.BillingState][]mongodbTypes.BillingState{
+	mongodbTypes.BillingStateNone:      {mongodbTypes.BillingStateTrialing, mongodbTypes.BillingStateActive, mongodbTypes.BillingStatePastDue, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStateTrialing:  {mongodbTypes.BillingStateActive, mongodbTypes.BillingStatePastDue, mongodbTypes.BillingStateSuspended, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStateActive:    {mongodbTypes.BillingStatePastDue, mongodbTypes.BillingStateSuspended, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStatePastDue:   {mongodbTypes.BillingStateActive, mongodbTypes.BillingStateGrace, mongodbTypes.BillingStateSuspended, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStateGrace:     {mongodbTypes.BillingStateActive, mongodbTypes.BillingStateSuspended, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStateSuspended: {mongodbTypes.BillingStateActive, mongodbTypes.BillingStateCanceled},
+	mongodbTypes.BillingStateCanceled:  {mongodbTypes.BillingStateTrialing, mongodbTypes.BillingStateActive},
+}
+
+// Cause is the Stripe event or timer that triggers a transition
+type Cause struct {
+	Type    string    // Stripe event type, or one of the timer causes
+	EventID string    // Stripe event ID, empty for timers
+	At      time.Time // When the Stripe event was created, events older than the latest one applied are ignored
+}
+
+// Allowed reports whether the state machine lets the state move to another one
+func Allowed(from mongodbTypes.BillingState, to mongodbTypes.BillingState) bool {
+	return slices.Contains(allowed[from], to)
+}
+
+// StateFromSubscriptionStatus maps a Stripe subscription status to the billing state it leads to
+// Incomplete subscriptions never started, so they don't move the lifecycle
+func StateFromSubscriptionStatus(status string) (mongodbTypes.BillingState, bool) {
+	switch status {
+	case "trialing":
+		return mongodbTypes.BillingStateTrialing, true
+	case "active":
+		return mongodbTypes.BillingStateActive, true
+	case "past_due":
+		return mongodbTypes.BillingStatePastDue, true
+	case "unpaid", "paused":
+		return mongodbTypes.BillingStateSuspended, true
+	case "canceled", "incomplete_expired":
+		return mongodbTypes.BillingStateCanceled, true
+	default:
+		return "", false
+	}
+}
+
+// ShouldTransition reports whether the cause moves the lifecycle to the target state
+// Events about a subscription other than the one driving the state only apply when they start a new trial or activation,
+// so a stale duplicate subscription being canceled doesn't cancel an owner paying for another one
+func ShouldTransition(lifecycle mongodbTypes.BillingLifecycle, subscriptionID string, to mongodbTypes.BillingState, cause Cause) bool {
+	if lifecycle.State == to {
+		return false
+	}
+	if cause.EventID != "" {
+		applied := slices.ContainsFunc(lifecycle.Transitions, func(t mongodbTypes.BillingTransition) bool { return t.EventID == cause.EventID })
+		if applied || cause.At.Before(lifecycle.LastEventAt) {
+			return false
+		}
+	}
+	if !Allowed(lifecycle.State, to) {
+		log.Printf("[LIFECYCLE] Ignoring %s of %s: %s can't move to %s", cause.Type, lifecycle.OwnerID, lifecycle.State, to)
+		return false
+	}
+
+	drivingOther := subscriptionID != "" && lifecycle.SubscriptionID != "" && subscriptionID != lifecycle.SubscriptionID
+	startsNew := to == mongodbTypes.BillingStateTrialing || to == mongodbTypes.BillingStateActive
+	if drivingOther && !startsNew && lifecycle.State != mongodbTypes.BillingStateCanceled {
+		log.Printf("[LIFECYCLE] Ignoring %s of %s: subscription %s doesn't drive its state", cause.Type, lifecycle.OwnerID, subscriptionID)
+		return false
+	}
+
+	return true
+}
+
+// Transition returns the lifecycle moved to the target state and the transition to record, once ShouldTransition allowed it
+func Transition(lifecycle mongodbTypes.BillingLifecycle, subscriptionID string, to mongodbTypes.BillingState, graceEndsAt *time.Time, cause Cause, now time.Time) (mongodbTypes.BillingLifecycle, mongodbTypes.BillingTransition) {
+	from := lifecycle.State
+	lifecycle.State = to
+	lifecycle.Since = now
+	lifecycle.GraceEndsAt = graceEndsAt
+	if subscriptionID != "" {
+		lifecycle.SubscriptionID = subscriptionID
+	}
+	if cause.EventID != "" && cause.At.After(lifecycle.LastEventAt) {
+		lifecycle.LastEventAt = cause.At
+	}
+
+	return lifecycle, mongodbTypes.BillingTransition{
+		From:           from,
+		To:             to,
+		Cause:          cause.Type,
+		EventID:        cause.EventID,
+		SubscriptionID: lifecycle.SubscriptionID,
+		At:             now,
+	}
+}
+
+// AdoptsSubscription reports whether a trial or activation of another subscription takes over driving a lifecycle
+// that's already in that state, e.g. a new subscription created by an upgrade through Checkout before the old one is canceled
+func AdoptsSubscription(lifecycle mongodbTypes.BillingLifecycle, subscriptionID string, cause Cause) bool {
+	if subscriptionID == "" || subscriptionID == lifecycle.SubscriptionID {
+		return false
+	}
+	if lifecycle.State != mongodbTypes.BillingStateTrialing && lifecycle.State != mongodbTypes.BillingStateActive {
+		return false
+	}
+	return cause.EventID == "" || !cause.At.Before(lifecycle.LastEventAt)
+}
+
+// Adopt returns the lifecycle driven by the subscription, once AdoptsSubscription allowed it
+func Adopt(lifecycle mongodbTypes.BillingLifecycle, subscriptionID string, cause Cause) mongodbTypes.BillingLifecycle {
+	lifecycle.SubscriptionID = subscriptionID
+	if cause.EventID != "" && cause.At.After(lifecycle.LastEventAt) {
+		lifecycle.LastEventAt = cause.At
+	}
+	return lifecycle
+}
+
+// RunningSubscription returns another of the mirrored subscriptions that is active, or trialing if none is
+func RunningSubscription(subscriptions []map[string]interface{}, subscriptionID string) (string, mongodbTypes.BillingState, bool) {
+	trialing := ""
+	for _, other := range subscriptions {
+		id, _ := other["id"].(string)
+		status, _ := other["status"].(string)
+		if id == "" || id == subscriptionID {
+			continue
+		}
+		switch state, _ := StateFromSubscriptionStatus(status); state {
+		case mongodbTypes.BillingStateActive:
+			return id, state, true
+		case mongodbTypes.BillingStateTrialing:
+			if trialing == "" {
+				trialing = id
+			}
+		}
+	}
+	if trialing != "" {
+		return trialing, mongodbTypes.BillingStateTrialing, true
+	}
+	return "", "", false
+}
+
+// GraceEndsAt returns when the grace state entered now ends: the policy's grace days counted from the first failed payment,
+// the same end the entitlements use, or after the fallback period when the policy sets no grace days
+func GraceEndsAt(policy catalogTypes.GracePolicy, pastDueSince time.Time, now time.Time, fallback time.Duration) time.Time {
+	if policy.Days > 0 {
+		return pastDueSince.AddDate(0, 0, policy.Days)
+	}
+	return now.Add(fallback)
+}
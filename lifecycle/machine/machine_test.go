@@ -0,0 +1,204 @@
+package machine
+
+import (
+	"testing"
+	"time"
+
+	catalogTypes "nucleus/types/catalog"
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// apply decides a transition the way the lifecycle package does before storing it
+func apply(lifecycle mongodbTypes.BillingLifecycle, subscriptionID string, to mongodbTypes.BillingState, cause Cause, now time.Time) mongodbTypes.BillingLifecycle {
+	if lifecycle.State == to {
+		if AdoptsSubscription(lifecycle, subscriptionID, cause) {
+			return Adopt(lifecycle, subscriptionID, cause)
+		}
+		return lifecycle
+	}
+	if !ShouldTransition(lifecycle, subscriptionID, to, cause) {
+		return lifecycle
+	}
+
+	var graceEndsAt *time.Time
+	if to == mongodbTypes.BillingStateGrace {
+		end := now.Add(24 * time.Hour)
+		graceEndsAt = &end
+	}
+	lifecycle, record := Transition(lifecycle, subscriptionID, to, graceEndsAt, cause, now)
+	lifecycle.Transitions = append(lifecycle.Transitions, record)
+	return lifecycle
+}
+
+func TestTransitions(t *testing.T) {
+	now := time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC)
+	event := func(id string, at time.Time) Cause {
+		return Cause{Type: "customer.subscription.updated", EventID: id, At: at}
+	}
+	timer := func(cause string) Cause { return Cause{Type: cause} }
+
+	type step struct {
+		subscriptionID   string
+		to               mongodbTypes.BillingState
+		cause            Cause
+		wantState        mongodbTypes.BillingState
+		wantSubscription string
+	}
+
+	active := mongodbTypes.BillingLifecycle{
+		OwnerID:        "org_123",
+		State:          mongodbTypes.BillingStateActive,
+		SubscriptionID: "sub_1",
+		LastEventAt:    now,
+		Transitions:    []mongodbTypes.BillingTransition{{From: mongodbTypes.BillingStateNone, To: mongodbTypes.BillingStateActive, EventID: "evt_1", SubscriptionID: "sub_1"}},
+	}
+
+	tests := []struct {
+		name  string
+		start mongodbTypes.BillingLifecycle
+		steps []step
+	}{
+		{
+			name:  "first subscription",
+			start: mongodbTypes.BillingLifecycle{OwnerID: "org_123", State: mongodbTypes.BillingStateNone},
+			steps: []step{
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateTrialing, cause: event("evt_1", now), wantState: mongodbTypes.BillingStateTrialing, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateActive, cause: event("evt_2", now.Add(time.Hour)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+		{
+			name:  "out of order event",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStatePastDue, cause: event("evt_0", now.Add(-time.Hour)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+		{
+			name:  "repeated event",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateCanceled, cause: event("evt_2", now), wantState: mongodbTypes.BillingStateCanceled, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateActive, cause: event("evt_1", now), wantState: mongodbTypes.BillingStateCanceled, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateActive, cause: event("evt_3", now), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+		{
+			name:  "stale subscription canceled while another is active",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_2", to: mongodbTypes.BillingStateActive, cause: event("evt_2", now.Add(time.Minute)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_2"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateCanceled, cause: event("evt_3", now.Add(2*time.Minute)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_2"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStatePastDue, cause: event("evt_4", now.Add(3*time.Minute)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_2"},
+			},
+		},
+		{
+			name:  "older activation of another subscription isn't adopted",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_2", to: mongodbTypes.BillingStateActive, cause: event("evt_2", now.Add(-time.Minute)), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+		{
+			name:  "past due then grace then suspended",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStatePastDue, cause: Cause{Type: "invoice.payment_failed", EventID: "evt_2", At: now.Add(time.Hour)}, wantState: mongodbTypes.BillingStatePastDue, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateGrace, cause: timer("timer.past_due_expired"), wantState: mongodbTypes.BillingStateGrace, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateSuspended, cause: timer("timer.grace_expired"), wantState: mongodbTypes.BillingStateSuspended, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStatePastDue, cause: Cause{Type: "invoice.payment_failed", EventID: "evt_3", At: now.Add(2 * time.Hour)}, wantState: mongodbTypes.BillingStateSuspended, wantSubscription: "sub_1"},
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateActive, cause: Cause{Type: "invoice.paid", EventID: "evt_4", At: now.Add(3 * time.Hour)}, wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+		{
+			name:  "grace is only entered from past due",
+			start: active,
+			steps: []step{
+				{subscriptionID: "sub_1", to: mongodbTypes.BillingStateGrace, cause: timer("timer.past_due_expired"), wantState: mongodbTypes.BillingStateActive, wantSubscription: "sub_1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lifecycle := test.start
+			lifecycle.Transitions = append([]mongodbTypes.BillingTransition{}, test.start.Transitions...)
+			for i, step := range test.steps {
+				lifecycle = apply(lifecycle, step.subscriptionID, step.to, step.cause, now)
+				if lifecycle.State != step.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, lifecycle.State, step.wantState)
+				}
+				if lifecycle.SubscriptionID != step.wantSubscription {
+					t.Fatalf("step %d: subscription = %s, want %s", i, lifecycle.SubscriptionID, step.wantSubscription)
+				}
+				if (lifecycle.State == mongodbTypes.BillingStateGrace) != (lifecycle.GraceEndsAt != nil) {
+					t.Fatalf("step %d: grace_ends_at = %v in state %s", i, lifecycle.GraceEndsAt, lifecycle.State)
+				}
+			}
+		})
+	}
+}
+
+func TestTransitionRecordsCause(t *testing.T) {
+	now := time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC)
+	start := mongodbTypes.BillingLifecycle{OwnerID: "org_123", State: mongodbTypes.BillingStateActive, SubscriptionID: "sub_1", LastEventAt: now}
+
+	lifecycle, record := Transition(start, "", mongodbTypes.BillingStatePastDue, nil, Cause{Type: "invoice.payment_failed", EventID: "evt_2", At: now.Add(time.Hour)}, now)
+	if record.From != mongodbTypes.BillingStateActive || record.To != mongodbTypes.BillingStatePastDue || record.EventID != "evt_2" {
+		t.Errorf("record = %+v", record)
+	}
+	if record.SubscriptionID != "sub_1" || lifecycle.SubscriptionID != "sub_1" {
+		t.Errorf("subscription = %s, %s, want the driving sub_1 kept", record.SubscriptionID, lifecycle.SubscriptionID)
+	}
+	if !lifecycle.LastEventAt.Equal(now.Add(time.Hour)) || !lifecycle.Since.Equal(now) {
+		t.Errorf("last_event_at = %v, since = %v", lifecycle.LastEventAt, lifecycle.Since)
+	}
+
+	lifecycle, _ = Transition(lifecycle, "", mongodbTypes.BillingStateGrace, nil, Cause{Type: "timer.past_due_expired"}, now.Add(2*time.Hour))
+	if !lifecycle.LastEventAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("timer moved last_event_at to %v", lifecycle.LastEventAt)
+	}
+}
+
+func TestRunningSubscription(t *testing.T) {
+	subscriptions := func(statuses ...string) []map[string]interface{} {
+		var result []map[string]interface{}
+		for i, status := range statuses {
+			result = append(result, map[string]interface{}{"id": "sub_" + string(rune('1'+i)), "status": status})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name          string
+		subscriptions []map[string]interface{}
+		wantID        string
+		wantState     mongodbTypes.BillingState
+		wantOK        bool
+	}{
+		{name: "only the ending one", subscriptions: subscriptions("canceled")},
+		{name: "another active", subscriptions: subscriptions("canceled", "trialing", "active"), wantID: "sub_3", wantState: mongodbTypes.BillingStateActive, wantOK: true},
+		{name: "another trialing", subscriptions: subscriptions("canceled", "past_due", "trialing"), wantID: "sub_3", wantState: mongodbTypes.BillingStateTrialing, wantOK: true},
+		{name: "others not running", subscriptions: subscriptions("canceled", "past_due", "incomplete")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, state, ok := RunningSubscription(test.subscriptions, "sub_1")
+			if id != test.wantID || state != test.wantState || ok != test.wantOK {
+				t.Errorf("RunningSubscription = %s, %s, %v, want %s, %s, %v", id, state, ok, test.wantID, test.wantState, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestGraceEndsAt(t *testing.T) {
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	pastDueSince := now.Add(-72 * time.Hour)
+
+	if got := GraceEndsAt(catalogTypes.GracePolicy{Days: 7}, pastDueSince, now, 48*time.Hour); !got.Equal(pastDueSince.AddDate(0, 0, 7)) {
+		t.Errorf("with grace days = %v, want 7 days after the first failed payment", got)
+	}
+	if got := GraceEndsAt(catalogTypes.GracePolicy{}, pastDueSince, now, 48*time.Hour); !got.Equal(now.Add(48 * time.Hour)) {
+		t.Errorf("without grace days = %v, want the fallback period from now", got)
+	}
+}
@@ -0,0 +1,53 @@
+package lifecycle
+
+import (
+	"log"
+	"nucleus/config"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+)
+
+// StartTimerSweeper periodically moves owners that stayed past due too long into grace,
+// and applies the end action of the grace periods that ended without a successful payment
+func StartTimerSweeper() {
+	interval := config.Duration("BILLING_STATE_SWEEP_INTERVAL", 5*time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepTimers()
+		}
+	}()
+}
+
+// sweepTimers fires the timer transitions that are due, failed ones are retried on the next sweep
+func sweepTimers() {
+	lifecycles, err := mongodb.ListDueBillingLifecycles(time.Now().Add(-pastDuePeriod))
+	if err != nil {
+		log.Printf("Error listing due billing lifecycles: %v", err)
+		return
+	}
+
+	for _, lifecycle := range lifecycles {
+		owner, err := mongodb.GetOrganizationByStripeCustomerID(lifecycle.StripeCustomerID)
+		if err != nil {
+			log.Printf("Error getting owner of billing lifecycle %s: %v", lifecycle.OwnerID, err)
+			continue
+		}
+
+		if lifecycle.State == mongodbTypes.BillingStatePastDue {
+			if err := startGrace(owner, lifecycle.SubscriptionID, Cause{Type: CausePastDueExpired}); err != nil {
+				log.Printf("Error starting grace period of %s: %v", lifecycle.OwnerID, err)
+			}
+			continue
+		}
+
+		policy, _ := subscriptionGrace(owner, lifecycle.SubscriptionID)
+		if err := endGrace(owner, lifecycle.SubscriptionID, policy, Cause{Type: CauseGraceExpired}); err != nil {
+			log.Printf("Error ending grace period of %s: %v", lifecycle.OwnerID, err)
+		}
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"nucleus/api"
 	"nucleus/auth"
 	"nucleus/clerk"
+	"nucleus/credits"
+	"nucleus/lifecycle"
 	"nucleus/stripe"
+	"nucleus/usage"
 
 	"github.com/joho/godotenv"
 	stripeSDK "github.com/stripe/stripe-go/v82"
@@ -26,6 +29,45 @@ func main() {
 	http.HandleFunc("/clerk/webhook", clerk.HandleWebhook)
 	http.Handle("/user/subscriptions", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserSuscriptionsHandler)))
 	http.Handle("/user/stripe-customer-id", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserStripeCustomerIDHandler)))
+	http.Handle("/user/organizations", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserOrganizationsHandler)))
+	http.Handle("/user/billing-state", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserBillingStateHandler)))
+	http.Handle("/user/plan", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserPlanHandler)))
+	http.Handle("/user/entitlements", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserEntitlementsHandler)))
+	http.Handle("/user/entitlements/{feature}", auth.VerifyingMiddleware(http.HandlerFunc(api.GetUserEntitlementHandler)))
+	http.Handle("/user/tokens", auth.VerifyingMiddleware(auth.RequireSession(auth.RequireRole(auth.RoleAdmin)(http.HandlerFunc(api.AccessTokensHandler)))))
+	http.Handle("/user/tokens/{id}", auth.VerifyingMiddleware(auth.RequireSession(auth.RequireRole(auth.RoleAdmin)(http.HandlerFunc(api.RevokeAccessTokenHandler)))))
+	http.Handle("/billing/spend-cap", auth.VerifyingMiddleware(auth.RequireBillingManager(http.HandlerFunc(api.SpendCapHandler))))
+	http.Handle("/billing/credits", auth.VerifyingMiddleware(auth.RequireBillingManager(http.HandlerFunc(api.GetCreditsHandler))))
+	http.Handle("/billing/history", auth.VerifyingMiddleware(auth.RequireBillingManager(http.HandlerFunc(api.GetBillingHistoryHandler))))
+	http.Handle("/metrics", auth.AdminMiddleware(http.HandlerFunc(api.GetMetricsHandler)))
+	http.Handle("/usage/events", auth.ServiceKeyOrVerifyingMiddleware(auth.ScopeUsageWrite)(http.HandlerFunc(api.PostUsageEventsHandler)))
+	http.Handle("/quota/{feature}/consume", auth.ServiceKeyOrVerifyingMiddleware(auth.ScopeQuotaConsume)(auth.RequireQuotaPermission(http.HandlerFunc(api.ConsumeQuotaHandler))))
+	http.Handle("/credits/debit", auth.ServiceKeyOrVerifyingMiddleware(auth.ScopeCreditsDebit)(auth.RequireMemberPermission(auth.PermissionCreditsDebit)(http.HandlerFunc(api.DebitCreditsHandler))))
+
+	// Internal backends authenticated with service API keys
+	http.Handle("/internal/orgs/{clerkOrgId}/subscriptions", auth.ServiceKeyMiddleware(auth.RequireScope(auth.ScopeSubscriptionsRead)(http.HandlerFunc(api.GetInternalOrganizationSubscriptionsHandler))))
+	http.Handle("/internal/orgs/{clerkOrgId}/entitlements", auth.ServiceKeyMiddleware(auth.RequireScope(auth.ScopeEntitlementsRead)(http.HandlerFunc(api.GetInternalOrganizationEntitlementsHandler))))
+
+	// Operator endpoints authenticated with ADMIN_API_KEY
+	http.Handle("/admin/service-keys", auth.AdminMiddleware(http.HandlerFunc(api.ServiceKeysHandler)))
+	http.Handle("/admin/service-keys/{id}", auth.AdminMiddleware(http.HandlerFunc(api.RevokeServiceKeyHandler)))
+	http.Handle("/admin/service-keys/{id}/rotate", auth.AdminMiddleware(http.HandlerFunc(api.RotateServiceKeyHandler)))
+	http.Handle("/admin/entitlements/evaluate", auth.AdminMiddleware(http.HandlerFunc(api.EvaluateRulesHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/overrides", auth.AdminMiddleware(http.HandlerFunc(api.OverridesHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/overrides/{id}", auth.AdminMiddleware(http.HandlerFunc(api.RevokeOverrideHandler)))
+	http.Handle("/admin/users/{clerkUserId}/overrides", auth.AdminMiddleware(http.HandlerFunc(api.OverridesHandler)))
+	http.Handle("/admin/users/{clerkUserId}/overrides/{id}", auth.AdminMiddleware(http.HandlerFunc(api.RevokeOverrideHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/billing-state", auth.AdminMiddleware(http.HandlerFunc(api.GetBillingStateHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/subscriptions/{subscriptionId}/schedule", auth.AdminMiddleware(http.HandlerFunc(api.SubscriptionScheduleHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/holds", auth.AdminMiddleware(http.HandlerFunc(api.ListHoldsHandler)))
+	http.Handle("/admin/orgs/{clerkOrgId}/holds/{id}", auth.AdminMiddleware(http.HandlerFunc(api.ReleaseHoldHandler)))
+
+	clerk.StartOverrideExpirySweeper()
+	clerk.StartPurchaseExpirySweeper()
+	usage.StartFlusher()
+	credits.StartExpirySweeper()
+	lifecycle.StartTimerSweeper()
+	stripe.StartSubscriptionExpirySweeper()
 
 	addr := fmt.Sprintf(":%s", os.Getenv("PORT"))
 	fmt.Println("Listening on", addr)
@@ -0,0 +1,108 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// CreateAccessToken stores a new organization access token
+func CreateAccessToken(token mongodbTypes.AccessToken) (mongodbTypes.AccessToken, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	token.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), token)
+	if err != nil {
+		return mongodbTypes.AccessToken{}, err
+	}
+
+	log.Printf("[MONGO] Created access token %s (%s) for organization %s", token.ID.Hex(), token.Prefix, token.OrganizationID)
+	return token, nil
+}
+
+// GetActiveAccessTokenByHash returns the access token with the given hash if it's neither revoked nor expired
+func GetActiveAccessTokenByHash(tokenHash string) (mongodbTypes.AccessToken, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	var result mongodbTypes.AccessToken
+	err := coll.FindOne(context.Background(), bson.M{
+		"token_hash": tokenHash,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&result)
+	if err != nil {
+		return mongodbTypes.AccessToken{}, err
+	}
+
+	return result, nil
+}
+
+// ListAccessTokensByOrganization returns every access token of the organization, including revoked and expired ones
+func ListAccessTokensByOrganization(organizationID string) ([]mongodbTypes.AccessToken, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{"organization_id": organizationID})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.AccessToken{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// RevokeAccessToken marks an access token of the organization as revoked
+func RevokeAccessToken(organizationID string, id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	result, err := coll.UpdateOne(context.Background(),
+		bson.M{"_id": id, "organization_id": organizationID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	log.Printf("[MONGO] Revoked access token %s for organization %s", id.Hex(), organizationID)
+	return nil
+}
+
+// RevokeAccessTokens revokes every active access token of the organization, or only those created by createdBy when it's set
+func RevokeAccessTokens(organizationID string, createdBy string) (int64, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	filter := bson.M{"organization_id": organizationID, "revoked_at": bson.M{"$exists": false}}
+	if createdBy != "" {
+		filter["created_by"] = createdBy
+	}
+
+	result, err := coll.UpdateMany(context.Background(), filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+
+	if result.ModifiedCount > 0 {
+		log.Printf("[MONGO] Revoked %d access tokens for organization %s", result.ModifiedCount, organizationID)
+	}
+	return result.ModifiedCount, nil
+}
+
+// TouchAccessToken records when an access token was last used
+func TouchAccessToken(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_TOKENS"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	return err
+}
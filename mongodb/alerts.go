@@ -0,0 +1,73 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// EnsureAlertIndexes creates the unique index that keeps alerts from being raised twice
+func EnsureAlertIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ALERTS"))
+
+	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "dedup_key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// CreateAlert stores an alert, returning false if an alert with the same dedup key was already raised
+func CreateAlert(alert mongodbTypes.Alert) (mongodbTypes.Alert, bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ALERTS"))
+
+	alert.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), alert)
+	if mongo.IsDuplicateKeyError(err) {
+		return mongodbTypes.Alert{}, false, nil
+	}
+	if err != nil {
+		return mongodbTypes.Alert{}, false, err
+	}
+
+	log.Printf("[MONGO] Raised alert %s (%s) for organization %s", alert.ID.Hex(), alert.Type, alert.OrganizationID)
+	return alert, true, nil
+}
+
+// ListPendingAlerts returns the alerts raised between since and before that were never delivered
+func ListPendingAlerts(since time.Time, before time.Time) ([]mongodbTypes.Alert, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ALERTS"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{
+		"status":     mongodbTypes.AlertPending,
+		"created_at": bson.M{"$gte": since, "$lt": before},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.Alert{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CompleteAlertDelivery records the outcome of every channel the alert was sent to
+func CompleteAlertDelivery(id bson.ObjectID, status mongodbTypes.AlertStatus, deliveries []mongodbTypes.AlertDelivery) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ALERTS"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{
+		"$set":  bson.M{"status": status},
+		"$push": bson.M{"deliveries": bson.M{"$each": deliveries}},
+	})
+	return err
+}
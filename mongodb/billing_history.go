@@ -0,0 +1,44 @@
+package mongodb
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// CreateBillingEvent appends an event to the organization's billing history
+func CreateBillingEvent(event mongodbTypes.BillingEvent) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_HISTORY"))
+
+	event.ID = bson.NewObjectID()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	_, err := coll.InsertOne(context.Background(), event)
+	return err
+}
+
+// ListBillingEvents returns the organization's most recent billing events, newest first
+func ListBillingEvents(organizationID string, limit int64) ([]mongodbTypes.BillingEvent, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_HISTORY"))
+
+	cursor, err := coll.Find(context.Background(),
+		bson.M{"organization_id": organizationID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.BillingEvent{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,140 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// maxBillingTransitions is how many recent transitions a billing lifecycle keeps
+const maxBillingTransitions = 100
+
+// EnsureBillingStateIndexes creates the unique index that keeps one billing lifecycle per owner
+func EnsureBillingStateIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_STATES"))
+
+	_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "owner_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "state", Value: 1}, {Key: "since", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "state", Value: 1}, {Key: "grace_ends_at", Value: 1}},
+		},
+	})
+	return err
+}
+
+// GetBillingLifecycle returns the billing lifecycle of the owner, or one in the none state if it never subscribed
+func GetBillingLifecycle(ownerID string) (mongodbTypes.BillingLifecycle, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_STATES"))
+
+	var result mongodbTypes.BillingLifecycle
+	err := coll.FindOne(context.Background(), bson.M{"owner_id": ownerID}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return mongodbTypes.BillingLifecycle{
+			OwnerID:     ownerID,
+			State:       mongodbTypes.BillingStateNone,
+			Transitions: []mongodbTypes.BillingTransition{},
+		}, nil
+	}
+	if err != nil {
+		return mongodbTypes.BillingLifecycle{}, err
+	}
+
+	return result, nil
+}
+
+// UpdateBillingLifecycle stores the new state of a lifecycle and appends the transition that led to it
+// It returns false without changing anything if the lifecycle changed since it was read at lifecycle.Version
+func UpdateBillingLifecycle(lifecycle mongodbTypes.BillingLifecycle, transition mongodbTypes.BillingTransition) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_STATES"))
+
+	set := bson.M{
+		"stripe_customer_id": lifecycle.StripeCustomerID,
+		"state":              lifecycle.State,
+		"subscription_id":    lifecycle.SubscriptionID,
+		"since":              lifecycle.Since,
+		"last_event_at":      lifecycle.LastEventAt,
+		"updated_at":         time.Now(),
+	}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+		"$push": bson.M{"transitions": bson.M{
+			"$each":  bson.A{transition},
+			"$slice": -maxBillingTransitions,
+		}},
+	}
+	if lifecycle.GraceEndsAt != nil {
+		set["grace_ends_at"] = lifecycle.GraceEndsAt
+	} else {
+		update["$unset"] = bson.M{"grace_ends_at": ""}
+	}
+
+	// A lifecycle that doesn't exist yet is created by the upsert, if another event created it
+	// first the upsert collides with the unique index and the caller retries with the new version
+	_, err := coll.UpdateOne(context.Background(),
+		bson.M{"owner_id": lifecycle.OwnerID, "version": lifecycle.Version},
+		update,
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UpdateBillingLifecycleSubscription changes the subscription driving a lifecycle without changing its state
+// It returns false without changing anything if the lifecycle changed since it was read at lifecycle.Version
+func UpdateBillingLifecycleSubscription(lifecycle mongodbTypes.BillingLifecycle) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_STATES"))
+
+	result, err := coll.UpdateOne(context.Background(),
+		bson.M{"owner_id": lifecycle.OwnerID, "version": lifecycle.Version},
+		bson.M{
+			"$set": bson.M{"subscription_id": lifecycle.SubscriptionID, "last_event_at": lifecycle.LastEventAt, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ListDueBillingLifecycles returns the lifecycles a timer should move on: past due since before pastDueBefore,
+// or in grace with a grace period that ended
+func ListDueBillingLifecycles(pastDueBefore time.Time) ([]mongodbTypes.BillingLifecycle, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_BILLING_STATES"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{"$or": bson.A{
+		bson.M{"state": mongodbTypes.BillingStatePastDue, "since": bson.M{"$lte": pastDueBefore}},
+		bson.M{"state": mongodbTypes.BillingStateGrace, "grace_ends_at": bson.M{"$lte": time.Now()}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.BillingLifecycle{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,233 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// maxAppliedCreditTransactions is how many recent transaction IDs a wallet remembers to apply each one only once
+const maxAppliedCreditTransactions = 500
+
+// EnsureCreditIndexes creates the unique indexes that keep one wallet per organization and make transactions idempotent
+func EnsureCreditIndexes() error {
+	wallets := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_WALLETS"))
+	_, err := wallets.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	transactions := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+	_, err = transactions.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	return err
+}
+
+// GetCreditWallet returns the wallet of the organization, or an empty wallet if it never had credits
+func GetCreditWallet(organizationID string) (mongodbTypes.CreditWallet, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_WALLETS"))
+
+	var result mongodbTypes.CreditWallet
+	err := coll.FindOne(context.Background(), bson.M{"organization_id": organizationID}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return mongodbTypes.CreditWallet{OrganizationID: organizationID, Grants: []mongodbTypes.CreditGrant{}}, nil
+	}
+	if err != nil {
+		return mongodbTypes.CreditWallet{}, err
+	}
+
+	return result, nil
+}
+
+// UpdateCreditWallet stores the new balance and grants of a wallet for a transaction
+// It returns false without changing anything if the wallet changed since it was read at wallet.Version
+// or if the transaction was already applied
+func UpdateCreditWallet(wallet mongodbTypes.CreditWallet, transactionID bson.ObjectID) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_WALLETS"))
+
+	// A wallet that doesn't exist yet is created by the upsert, if another transaction created it
+	// first the upsert collides with the unique index and the caller retries with the new version
+	_, err := coll.UpdateOne(context.Background(),
+		bson.M{
+			"organization_id":      wallet.OrganizationID,
+			"version":              wallet.Version,
+			"applied_transactions": bson.M{"$ne": transactionID},
+		},
+		bson.M{
+			"$set": bson.M{"balance": wallet.Balance, "grants": wallet.Grants, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+			"$push": bson.M{"applied_transactions": bson.M{
+				"$each":  bson.A{transactionID},
+				"$slice": -maxAppliedCreditTransactions,
+			}},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListWalletsWithExpiredGrants returns the wallets holding grants that expired with credits left
+func ListWalletsWithExpiredGrants() ([]mongodbTypes.CreditWallet, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_WALLETS"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{
+		"grants": bson.M{"$elemMatch": bson.M{"expires_at": bson.M{"$lte": time.Now()}, "remaining": bson.M{"$gt": 0}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.CreditWallet{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CreateCreditTransaction stores a pending transaction, returning false if one with the same idempotency key already exists
+func CreateCreditTransaction(transaction mongodbTypes.CreditTransaction) (mongodbTypes.CreditTransaction, bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	transaction.ID = bson.NewObjectID()
+	transaction.Status = mongodbTypes.CreditTransactionPending
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	_, err := coll.InsertOne(context.Background(), transaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return mongodbTypes.CreditTransaction{}, false, nil
+	}
+	if err != nil {
+		return mongodbTypes.CreditTransaction{}, false, err
+	}
+
+	return transaction, true, nil
+}
+
+// GetCreditTransaction returns the transaction of the organization with the idempotency key
+func GetCreditTransaction(organizationID string, idempotencyKey string) (mongodbTypes.CreditTransaction, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	var result mongodbTypes.CreditTransaction
+	err := coll.FindOne(context.Background(), bson.M{
+		"organization_id": organizationID,
+		"idempotency_key": idempotencyKey,
+	}).Decode(&result)
+	if err != nil {
+		return mongodbTypes.CreditTransaction{}, err
+	}
+
+	return result, nil
+}
+
+// PrepareCreditTransaction records the entries and resulting balance of a pending transaction before the wallet is updated,
+// so a transaction interrupted after the wallet update can still be posted with the right entries
+func PrepareCreditTransaction(id bson.ObjectID, entries []mongodbTypes.LedgerEntry, balanceAfter int64) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	_, err := coll.UpdateOne(context.Background(),
+		bson.M{"_id": id, "status": mongodbTypes.CreditTransactionPending},
+		bson.M{"$set": bson.M{"entries": entries, "balance_after": balanceAfter}},
+	)
+	return err
+}
+
+// PostCreditTransaction marks a transaction as applied to the wallet
+func PostCreditTransaction(id bson.ObjectID) (mongodbTypes.CreditTransaction, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	var result mongodbTypes.CreditTransaction
+	err := coll.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": mongodbTypes.CreditTransactionPosted, "posted_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&result)
+	if err != nil {
+		return mongodbTypes.CreditTransaction{}, err
+	}
+
+	return result, nil
+}
+
+// DeleteCreditTransaction removes a pending transaction that can't be applied, freeing its idempotency key
+func DeleteCreditTransaction(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	_, err := coll.DeleteOne(context.Background(), bson.M{"_id": id, "status": mongodbTypes.CreditTransactionPending})
+	return err
+}
+
+// SumCreditTransactions returns the total amount of the organization's posted transactions of a type with the reference,
+// e.g. the credits granted for a payment intent
+func SumCreditTransactions(organizationID string, reference string, transactionType mongodbTypes.CreditTransactionType) (int64, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	cursor, err := coll.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"organization_id": organizationID,
+			"reference":       reference,
+			"type":            transactionType,
+			"status":          mongodbTypes.CreditTransactionPosted,
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var rows []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Total, nil
+}
+
+// ListCreditTransactions returns the organization's most recent posted transactions, newest first
+func ListCreditTransactions(organizationID string, limit int64) ([]mongodbTypes.CreditTransaction, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_CREDIT_TRANSACTIONS"))
+
+	cursor, err := coll.Find(context.Background(),
+		bson.M{"organization_id": organizationID, "status": mongodbTypes.CreditTransactionPosted},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.CreditTransaction{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,101 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// EnsureAccessHoldIndexes creates the unique index that places a single hold per dispute, refunded charge or warning
+func EnsureAccessHoldIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_HOLDS"))
+
+	_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "reason", Value: 1}, {Key: "reference_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}},
+		},
+	})
+	return err
+}
+
+// CreateAccessHold stores a new hold, returning false if one was already placed for the same event
+func CreateAccessHold(hold mongodbTypes.AccessHold) (mongodbTypes.AccessHold, bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_HOLDS"))
+
+	hold.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), hold)
+	if mongo.IsDuplicateKeyError(err) {
+		return mongodbTypes.AccessHold{}, false, nil
+	}
+	if err != nil {
+		return mongodbTypes.AccessHold{}, false, err
+	}
+
+	log.Printf("[MONGO] Created %s hold %s for owner %s, %s %s", hold.Action, hold.ID.Hex(), hold.OwnerID, hold.Reason, hold.ReferenceID)
+	return hold, true, nil
+}
+
+// ListAccessHolds returns every hold of the owner, including released ones, newest first
+func ListAccessHolds(ownerID string) ([]mongodbTypes.AccessHold, error) {
+	return findAccessHolds(bson.M{"owner_id": ownerID})
+}
+
+// ListActiveAccessHolds returns the holds of the owner that weren't released
+func ListActiveAccessHolds(ownerID string) ([]mongodbTypes.AccessHold, error) {
+	return findAccessHolds(bson.M{"owner_id": ownerID, "released_at": bson.M{"$exists": false}})
+}
+
+// ReleaseAccessHold releases the active hold placed for an event, returning mongo.ErrNoDocuments if there is none
+func ReleaseAccessHold(reason mongodbTypes.HoldReason, referenceID string, releasedBy string, releaseReason string) (mongodbTypes.AccessHold, error) {
+	return releaseAccessHold(bson.M{"reason": reason, "reference_id": referenceID}, releasedBy, releaseReason)
+}
+
+// ReleaseAccessHoldByID releases an active hold of the owner, returning mongo.ErrNoDocuments if there is none
+func ReleaseAccessHoldByID(ownerID string, id bson.ObjectID, releasedBy string, releaseReason string) (mongodbTypes.AccessHold, error) {
+	return releaseAccessHold(bson.M{"_id": id, "owner_id": ownerID}, releasedBy, releaseReason)
+}
+
+func releaseAccessHold(filter bson.M, releasedBy string, releaseReason string) (mongodbTypes.AccessHold, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_HOLDS"))
+
+	filter["released_at"] = bson.M{"$exists": false}
+	var result mongodbTypes.AccessHold
+	err := coll.FindOneAndUpdate(context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"released_at": time.Now(), "released_by": releasedBy, "release_reason": releaseReason}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&result)
+	if err != nil {
+		return mongodbTypes.AccessHold{}, err
+	}
+
+	log.Printf("[MONGO] Released hold %s of owner %s by %s", result.ID.Hex(), result.OwnerID, releasedBy)
+	return result, nil
+}
+
+func findAccessHolds(filter bson.M) ([]mongodbTypes.AccessHold, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_ACCESS_HOLDS"))
+
+	cursor, err := coll.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.AccessHold{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
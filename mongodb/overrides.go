@@ -0,0 +1,101 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// CreateEntitlementOverride stores a new entitlement override
+func CreateEntitlementOverride(override mongodbTypes.EntitlementOverride) (mongodbTypes.EntitlementOverride, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_OVERRIDES"))
+
+	override.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), override)
+	if err != nil {
+		return mongodbTypes.EntitlementOverride{}, err
+	}
+
+	log.Printf("[MONGO] Created %s override %s for owner %s by %s", override.Kind, override.ID.Hex(), override.OwnerID(), override.CreatedBy)
+	return override, nil
+}
+
+// ListEntitlementOverrides returns every override of the owner, including revoked and expired ones
+func ListEntitlementOverrides(owner mongodbTypes.Organization) ([]mongodbTypes.EntitlementOverride, error) {
+	return findEntitlementOverrides(overrideOwnerFilter(owner))
+}
+
+// ListActiveEntitlementOverrides returns the overrides of the owner that are neither revoked nor expired, oldest first
+func ListActiveEntitlementOverrides(owner mongodbTypes.Organization) ([]mongodbTypes.EntitlementOverride, error) {
+	filter := overrideOwnerFilter(owner)
+	filter["revoked_at"] = bson.M{"$exists": false}
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	return findEntitlementOverrides(filter)
+}
+
+// ListUnappliedExpiredOverrides returns the overrides that expired since the entitlements were last recomputed
+func ListUnappliedExpiredOverrides() ([]mongodbTypes.EntitlementOverride, error) {
+	return findEntitlementOverrides(bson.M{
+		"revoked_at":     bson.M{"$exists": false},
+		"expires_at":     bson.M{"$lte": time.Now()},
+		"expiry_applied": bson.M{"$ne": true},
+	})
+}
+
+// MarkOverrideExpiryApplied records that the entitlements were recomputed after the override expired
+func MarkOverrideExpiryApplied(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_OVERRIDES"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"expiry_applied": true}})
+	return err
+}
+
+// RevokeEntitlementOverride marks an active override of the owner as revoked
+func RevokeEntitlementOverride(owner mongodbTypes.Organization, id bson.ObjectID, revokedBy string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_OVERRIDES"))
+
+	filter := overrideOwnerFilter(owner)
+	filter["_id"] = id
+	filter["revoked_at"] = bson.M{"$exists": false}
+	result, err := coll.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_by": revokedBy}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	log.Printf("[MONGO] Revoked override %s for owner %s by %s", id.Hex(), owner.OwnerID(), revokedBy)
+	return nil
+}
+
+// overrideOwnerFilter matches the overrides of an organization, or of a user billed personally
+func overrideOwnerFilter(owner mongodbTypes.Organization) bson.M {
+	if owner.IsUser() {
+		return bson.M{"user_id": owner.ClerkUserID}
+	}
+	return bson.M{"organization_id": owner.ClerkID}
+}
+
+func findEntitlementOverrides(filter bson.M) ([]mongodbTypes.EntitlementOverride, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_OVERRIDES"))
+
+	cursor, err := coll.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.EntitlementOverride{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
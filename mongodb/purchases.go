@@ -0,0 +1,165 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// EnsurePurchaseIndexes creates the unique index that records each plan of a payment once, even when webhooks are redelivered
+func EnsurePurchaseIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "payment_intent_id", Value: 1}, {Key: "price_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}},
+		},
+	})
+	return err
+}
+
+// CreatePurchase stores a one-time purchase, returning false if the plan of the payment was already recorded
+func CreatePurchase(purchase mongodbTypes.Purchase) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	purchase.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), purchase)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("[MONGO] Created purchase of plan %s for owner %s, payment intent %s", purchase.PlanID, purchase.OwnerID, purchase.PaymentIntentID)
+	return true, nil
+}
+
+// ListPurchases returns every purchase of the owner, including refunded and expired ones
+func ListPurchases(ownerID string) ([]mongodbTypes.Purchase, error) {
+	return findPurchases(bson.M{"owner_id": ownerID})
+}
+
+// ListActivePurchases returns the purchases of the owner that started and are neither refunded nor expired
+func ListActivePurchases(ownerID string) ([]mongodbTypes.Purchase, error) {
+	now := time.Now()
+	return findPurchases(bson.M{
+		"owner_id":    ownerID,
+		"refunded_at": bson.M{"$exists": false},
+		"starts_at":   bson.M{"$not": bson.M{"$gt": now}},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	})
+}
+
+// GetLastRunningPass returns the unrefunded fixed-term purchase of the plan by the owner that expires last,
+// or mongo.ErrNoDocuments when none of them is still running
+func GetLastRunningPass(ownerID string, planID string) (mongodbTypes.Purchase, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	var result mongodbTypes.Purchase
+	err := coll.FindOne(context.Background(),
+		bson.M{
+			"owner_id":    ownerID,
+			"plan_id":     planID,
+			"refunded_at": bson.M{"$exists": false},
+			"expires_at":  bson.M{"$gt": time.Now()},
+		},
+		options.FindOne().SetSort(bson.D{{Key: "expires_at", Value: -1}}),
+	).Decode(&result)
+	if err != nil {
+		return mongodbTypes.Purchase{}, err
+	}
+
+	return result, nil
+}
+
+// ListUnappliedStartedPurchases returns the purchases with a delayed start that started since the entitlements were last recomputed
+func ListUnappliedStartedPurchases() ([]mongodbTypes.Purchase, error) {
+	return findPurchases(bson.M{
+		"refunded_at":   bson.M{"$exists": false},
+		"starts_at":     bson.M{"$lte": time.Now()},
+		"start_applied": bson.M{"$ne": true},
+	})
+}
+
+// MarkPurchaseStartApplied records that the entitlements were recomputed after the purchase started
+func MarkPurchaseStartApplied(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"start_applied": true}})
+	return err
+}
+
+// ListUnappliedExpiredPurchases returns the purchases that expired since the entitlements were last recomputed
+func ListUnappliedExpiredPurchases() ([]mongodbTypes.Purchase, error) {
+	return findPurchases(bson.M{
+		"refunded_at":    bson.M{"$exists": false},
+		"expires_at":     bson.M{"$lte": time.Now()},
+		"expiry_applied": bson.M{"$ne": true},
+	})
+}
+
+// MarkPurchaseExpiryApplied records that the entitlements were recomputed after the purchase expired
+func MarkPurchaseExpiryApplied(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"expiry_applied": true}})
+	return err
+}
+
+// RefundPurchasesByPaymentIntent marks every purchase paid with the payment intent as refunded
+// It returns the purchases that were refunded by this call
+func RefundPurchasesByPaymentIntent(paymentIntentID string) ([]mongodbTypes.Purchase, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	filter := bson.M{"payment_intent_id": paymentIntentID, "refunded_at": bson.M{"$exists": false}}
+	purchases, err := findPurchases(filter)
+	if err != nil || len(purchases) == 0 {
+		return purchases, err
+	}
+
+	ids := make([]bson.ObjectID, 0, len(purchases))
+	for _, purchase := range purchases {
+		ids = append(ids, purchase.ID)
+	}
+	_, err = coll.UpdateMany(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}, "refunded_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"refunded_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[MONGO] Refunded %d purchases of payment intent %s", len(purchases), paymentIntentID)
+	return purchases, nil
+}
+
+func findPurchases(filter bson.M) ([]mongodbTypes.Purchase, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_PURCHASES"))
+
+	cursor, err := coll.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "purchased_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.Purchase{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
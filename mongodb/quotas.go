@@ -0,0 +1,84 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// EnsureQuotaCounterIndexes creates the unique index that keeps a single counter per organization, feature and period
+func EnsureQuotaCounterIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_QUOTAS"))
+
+	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "feature", Value: 1}, {Key: "period_end", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// ConsumeQuota atomically adds amount to the period's counter, unless the counter would go past the limit
+// It returns the counter after the update, or the unchanged counter and false if there wasn't enough quota left
+// A nil limit always consumes
+func ConsumeQuota(organizationID string, feature string, periodEnd time.Time, amount int64, limit *int64) (mongodbTypes.QuotaCounter, bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_QUOTAS"))
+
+	filter := bson.M{"organization_id": organizationID, "feature": feature, "period_end": periodEnd}
+	if limit != nil {
+		if amount > *limit {
+			counter, err := GetQuotaCounter(organizationID, feature, periodEnd)
+			return counter, false, err
+		}
+		filter["used"] = bson.M{"$lte": *limit - amount}
+	}
+
+	// The upsert collides with the unique index both when the counter has no room left and when a concurrent
+	// first consumption of the period inserted it meanwhile, so the increment is retried against the existing
+	// counter and only rejected when that doesn't match either
+	update := bson.M{"$inc": bson.M{"used": amount}, "$set": bson.M{"updated_at": time.Now()}}
+	var result mongodbTypes.QuotaCounter
+	err := coll.FindOneAndUpdate(context.Background(), filter, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&result)
+	if mongo.IsDuplicateKeyError(err) {
+		err = coll.FindOneAndUpdate(context.Background(), filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&result)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		counter, err := GetQuotaCounter(organizationID, feature, periodEnd)
+		return counter, false, err
+	}
+	if err != nil {
+		return mongodbTypes.QuotaCounter{}, false, err
+	}
+
+	return result, true, nil
+}
+
+// GetQuotaCounter returns the counter of the period, or an empty counter if nothing was consumed yet
+func GetQuotaCounter(organizationID string, feature string, periodEnd time.Time) (mongodbTypes.QuotaCounter, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_QUOTAS"))
+
+	var result mongodbTypes.QuotaCounter
+	err := coll.FindOne(context.Background(), bson.M{
+		"organization_id": organizationID,
+		"feature":         feature,
+		"period_end":      periodEnd,
+	}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return mongodbTypes.QuotaCounter{OrganizationID: organizationID, Feature: feature, PeriodEnd: periodEnd}, nil
+	}
+	if err != nil {
+		return mongodbTypes.QuotaCounter{}, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// CreateServiceAPIKey stores a new service API key
+func CreateServiceAPIKey(key mongodbTypes.ServiceAPIKey) (mongodbTypes.ServiceAPIKey, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	key.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), key)
+	if err != nil {
+		return mongodbTypes.ServiceAPIKey{}, err
+	}
+
+	log.Printf("[MONGO] Created service API key %s (%s)", key.ID.Hex(), key.Prefix)
+	return key, nil
+}
+
+// GetServiceAPIKeyByHash returns the active (not revoked) service API key with the given hash
+func GetServiceAPIKeyByHash(keyHash string) (mongodbTypes.ServiceAPIKey, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	var result mongodbTypes.ServiceAPIKey
+	err := coll.FindOne(context.Background(), bson.M{"key_hash": keyHash, "revoked_at": bson.M{"$exists": false}}).Decode(&result)
+	if err != nil {
+		return mongodbTypes.ServiceAPIKey{}, err
+	}
+
+	return result, nil
+}
+
+// ListServiceAPIKeys returns every service API key, including revoked ones
+func ListServiceAPIKeys() ([]mongodbTypes.ServiceAPIKey, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.ServiceAPIKey{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// RotateServiceAPIKey replaces the hash of an active service API key, invalidating the previous key
+func RotateServiceAPIKey(id bson.ObjectID, prefix string, keyHash string) (mongodbTypes.ServiceAPIKey, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	var result mongodbTypes.ServiceAPIKey
+	err := coll.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"prefix": prefix, "key_hash": keyHash, "rotated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&result)
+	if err != nil {
+		return mongodbTypes.ServiceAPIKey{}, err
+	}
+
+	log.Printf("[MONGO] Rotated service API key %s (%s)", id.Hex(), prefix)
+	return result, nil
+}
+
+// RevokeServiceAPIKey marks a service API key as revoked
+func RevokeServiceAPIKey(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	result, err := coll.UpdateOne(context.Background(),
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	log.Printf("[MONGO] Revoked service API key %s", id.Hex())
+	return nil
+}
+
+// TouchServiceAPIKey records when a service API key was last used
+func TouchServiceAPIKey(id bson.ObjectID) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SERVICE_KEYS"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -42,6 +43,7 @@ func CreateOrganizationSync(clerkID string, stripeCustomerID string) error {
 	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
 
 	_, err := coll.InsertOne(context.TODO(), bson.M{
+		"owner_type":            mongodbTypes.OwnerTypeOrganization,
 		"clerk_organization_id": clerkID,
 		"stripe_customer_id":    stripeCustomerID,
 	})
@@ -50,6 +52,20 @@ func CreateOrganizationSync(clerkID string, stripeCustomerID string) error {
 	return err
 }
 
+// CreateUserSync stores the mapping between a Clerk user billed personally and its Stripe customer
+func CreateUserSync(clerkUserID string, stripeCustomerID string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	_, err := coll.InsertOne(context.TODO(), bson.M{
+		"owner_type":         mongodbTypes.OwnerTypeUser,
+		"clerk_user_id":      clerkUserID,
+		"stripe_customer_id": stripeCustomerID,
+	})
+
+	log.Printf("[MONGO] Created user sync for clerkUserID: %s, stripeCustomerID: %s", clerkUserID, stripeCustomerID)
+	return err
+}
+
 func GetOrganizationByClerkID(clerkID string) (mongodbTypes.Organization, error) {
 
 	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
@@ -63,6 +79,37 @@ func GetOrganizationByClerkID(clerkID string) (mongodbTypes.Organization, error)
 	return result, nil
 }
 
+// GetOrganizationByOwnerID returns the billing mapping of a Clerk organization, or of a user billed personally
+func GetOrganizationByOwnerID(ownerID string) (mongodbTypes.Organization, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	var result mongodbTypes.Organization
+	err := coll.FindOne(context.Background(), ownerFilter(ownerID)).Decode(&result)
+	if err != nil {
+		return mongodbTypes.Organization{}, err
+	}
+
+	return result, nil
+}
+
+// ownerFilter matches the billing mapping of an organization or user by its Clerk ID, which never collide
+func ownerFilter(ownerID string) bson.M {
+	return bson.M{"$or": []bson.M{{"clerk_organization_id": ownerID}, {"clerk_user_id": ownerID}}}
+}
+
+// GetOrganizationByClerkUserID returns the personal billing mapping of a Clerk user
+func GetOrganizationByClerkUserID(clerkUserID string) (mongodbTypes.Organization, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	var result mongodbTypes.Organization
+	err := coll.FindOne(context.Background(), bson.M{"clerk_user_id": clerkUserID}).Decode(&result)
+	if err != nil {
+		return mongodbTypes.Organization{}, err
+	}
+
+	return result, nil
+}
+
 func GetOrganizationByStripeCustomerID(stripeCustomerID string) (mongodbTypes.Organization, error) {
 	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
 
@@ -86,6 +133,18 @@ func DeleteOrganizationByClerkID(clerkID string) error {
 	return nil
 }
 
+// DeleteOrganizationByClerkUserID removes the personal billing mapping of a Clerk user
+func DeleteOrganizationByClerkUserID(clerkUserID string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	_, err := coll.DeleteOne(context.Background(), bson.M{"clerk_user_id": clerkUserID})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func DeleteOrganizationByStripeCustomerID(stripeCustomerID string) error {
 	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
 
@@ -108,6 +167,18 @@ func UpdateOrganizationStripeCustomerID(clerkID string, stripeCustomerID string)
 	return nil
 }
 
+// UpdateOrganizationStripeFeatures stores the lookup keys of the customer's active Stripe entitlements
+func UpdateOrganizationStripeFeatures(stripeCustomerID string, features []string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"stripe_customer_id": stripeCustomerID}, bson.M{"$set": bson.M{"stripe_features": features}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func UpdateOrganizationClerkID(stripeCustomerID string, clerkID string) error {
 	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
 
@@ -118,3 +189,107 @@ func UpdateOrganizationClerkID(stripeCustomerID string, clerkID string) error {
 
 	return nil
 }
+
+// UpdateOwnerSpendCap sets the spend cap of an organization or user, or removes it when spendCap is nil
+func UpdateOwnerSpendCap(ownerID string, spendCap *mongodbTypes.SpendCap) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	update := bson.M{"$unset": bson.M{"spend_cap": ""}}
+	if spendCap != nil {
+		update = bson.M{"$set": bson.M{"spend_cap": spendCap}}
+	}
+
+	result, err := coll.UpdateOne(context.Background(), ownerFilter(ownerID), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	log.Printf("[MONGO] Updated spend cap of owner %s", ownerID)
+	return nil
+}
+
+// UpdateOwnerSpendCapReached records the end of the period in which the spend cap was reached, nil lifts it
+// It reports whether the state changed, so concurrent checks record a transition only once
+func UpdateOwnerSpendCapReached(ownerID string, periodEnd *time.Time) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	filter := bson.M{"$and": []bson.M{ownerFilter(ownerID), {"spend_cap": bson.M{"$exists": true}}}}
+	update := bson.M{"$unset": bson.M{"spend_cap.reached_period_end": ""}}
+	if periodEnd != nil {
+		filter["spend_cap.reached_period_end"] = bson.M{"$ne": *periodEnd}
+		update = bson.M{"$set": bson.M{"spend_cap.reached_period_end": *periodEnd}}
+	} else {
+		filter["spend_cap.reached_period_end"] = bson.M{"$exists": true}
+	}
+
+	result, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// EnsureSubscriptionExpiryIndex creates the index the stale subscription sweep looks owners up with
+func EnsureSubscriptionExpiryIndex() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "subscriptions_expire_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// UpdateOrganizationSubscriptionsExpireAt stores the earliest period end of the customer's mirrored subscriptions
+// It's stored as null when the customer has none, so the backfill can tell the mapping was already computed
+func UpdateOrganizationSubscriptionsExpireAt(stripeCustomerID string, expireAt *time.Time) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	var value interface{}
+	if expireAt != nil {
+		value = *expireAt
+	}
+
+	_, err := coll.UpdateOne(context.Background(), bson.M{"stripe_customer_id": stripeCustomerID}, bson.M{"$set": bson.M{"subscriptions_expire_at": value}})
+	return err
+}
+
+// ListOrganizationsWithoutSubscriptionsExpireAt returns the mappings with a Stripe customer whose subscriptions expiry was never computed
+func ListOrganizationsWithoutSubscriptionsExpireAt() ([]mongodbTypes.Organization, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{
+		"stripe_customer_id":      bson.M{"$exists": true, "$ne": ""},
+		"subscriptions_expire_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.Organization{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ListOrganizationsWithExpiredSubscriptions returns the mappings with a mirrored subscription whose period ended before the given time
+func ListOrganizationsWithExpiredSubscriptions(before time.Time) ([]mongodbTypes.Organization, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_SYNC"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{"subscriptions_expire_at": bson.M{"$lte": before}})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.Organization{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,189 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	mongodbTypes "nucleus/types/mongodb"
+)
+
+// EnsureUsageEventIndexes creates the unique index that makes usage reports idempotent
+// and the indexes the flusher uses to find due events and the events of a flush
+func EnsureUsageEventIndexes() error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "flush_id", Value: 1}},
+		},
+	})
+	return err
+}
+
+// CreateUsageEvent buffers a usage event, returning false if an event with the same idempotency key was already reported
+func CreateUsageEvent(event mongodbTypes.UsageEvent) (bool, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	event.ID = bson.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), event)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetUsageEvent returns the usage event reported for the organization with the idempotency key
+func GetUsageEvent(organizationID string, idempotencyKey string) (mongodbTypes.UsageEvent, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	var result mongodbTypes.UsageEvent
+	err := coll.FindOne(context.Background(), bson.M{
+		"organization_id": organizationID,
+		"idempotency_key": idempotencyKey,
+	}).Decode(&result)
+	if err != nil {
+		return mongodbTypes.UsageEvent{}, err
+	}
+
+	return result, nil
+}
+
+// ListDueUsageEvents returns up to limit pending or failed usage events whose next attempt is due, oldest first
+func ListDueUsageEvents(limit int64) ([]mongodbTypes.UsageEvent, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	cursor, err := coll.Find(context.Background(),
+		bson.M{
+			"status":          bson.M{"$in": []mongodbTypes.UsageEventStatus{mongodbTypes.UsageEventPending, mongodbTypes.UsageEventFailed}},
+			"next_attempt_at": bson.M{"$lte": time.Now()},
+		},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.UsageEvent{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ClaimUsageEvents assigns the flush ID to the usage events that don't belong to a flush yet
+// It's persisted before the meter event is sent, so a flush that fails halfway is resent with the same events and identifier
+func ClaimUsageEvents(ids []bson.ObjectID, flushID string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	_, err := coll.UpdateMany(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}, "flush_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"flush_id": flushID}},
+	)
+	return err
+}
+
+// ListUsageEventsByFlushID returns the usage events claimed by the flush that weren't given up on
+func ListUsageEventsByFlushID(flushID string) ([]mongodbTypes.UsageEvent, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	cursor, err := coll.Find(context.Background(), bson.M{
+		"flush_id": flushID,
+		"status":   bson.M{"$ne": mongodbTypes.UsageEventDead},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []mongodbTypes.UsageEvent{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// MarkUsageEventsSent records that the usage events claimed by the flush were reported to Stripe
+func MarkUsageEventsSent(flushID string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	now := time.Now()
+	result, err := coll.UpdateMany(context.Background(),
+		bson.M{"flush_id": flushID, "status": bson.M{"$in": []mongodbTypes.UsageEventStatus{mongodbTypes.UsageEventPending, mongodbTypes.UsageEventFailed}}},
+		bson.M{
+			"$set":  bson.M{"status": mongodbTypes.UsageEventSent},
+			"$inc":  bson.M{"attempts": 1},
+			"$push": bson.M{"history": mongodbTypes.UsageEventStatusChange{Status: mongodbTypes.UsageEventSent, At: now}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[MONGO] Marked %d usage events as sent in %s", result.ModifiedCount, flushID)
+	return nil
+}
+
+// MarkUsageEventsFailed records a failed flush, the events are retried at nextAttemptAt unless they're dead
+func MarkUsageEventsFailed(ids []bson.ObjectID, status mongodbTypes.UsageEventStatus, nextAttemptAt time.Time, reason string) error {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	_, err := coll.UpdateMany(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{
+			"$set":  bson.M{"status": status, "next_attempt_at": nextAttemptAt},
+			"$inc":  bson.M{"attempts": 1},
+			"$push": bson.M{"history": mongodbTypes.UsageEventStatusChange{Status: status, At: time.Now(), Error: reason}},
+		},
+	)
+	return err
+}
+
+// SumUsageByMeter returns the total value reported by the organization per meter between from and to,
+// counting every event that wasn't given up on whether or not it already reached Stripe
+func SumUsageByMeter(organizationID string, from time.Time, to time.Time) (map[string]int64, error) {
+	coll := Client.Database(os.Getenv("MONGO_DATABASE")).Collection(os.Getenv("MONGO_COLLECTION_USAGE_EVENTS"))
+
+	cursor, err := coll.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"organization_id": organizationID,
+			"timestamp":       bson.M{"$gte": from, "$lt": to},
+			"status":          bson.M{"$ne": mongodbTypes.UsageEventDead},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$meter", "total": bson.M{"$sum": "$value"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Meter string `bson:"_id"`
+		Total int64  `bson:"total"`
+	}
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		return nil, err
+	}
+
+	totals := map[string]int64{}
+	for _, row := range rows {
+		totals[row.Meter] = row.Total
+	}
+	return totals, nil
+}
@@ -0,0 +1,160 @@
+package quota
+
+import (
+	"errors"
+	"log"
+	"nucleus/alerts"
+	"nucleus/clerk"
+	"nucleus/entitlements"
+	"nucleus/mongodb"
+	"nucleus/spend"
+	entitlementsTypes "nucleus/types/entitlements"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ErrNotEntitled is returned when the organization or user doesn't have the feature it tries to consume
+var ErrNotEntitled = errors.New("feature not enabled for the organization")
+
+const (
+	// ReasonLimitReached denies consumption that would go past the feature's hard limit
+	ReasonLimitReached = "limit_reached"
+	// ReasonSpendCapReached denies any consumption once the organization or user reached its spend cap
+	ReasonSpendCapReached = "spend_cap_reached"
+)
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if err := mongodb.EnsureQuotaCounterIndexes(); err != nil {
+		log.Fatalf("Error creating quota counter indexes: %v", err)
+	}
+}
+
+// Result is the outcome of a quota consumption
+type Result struct {
+	Feature           string    `json:"feature"`
+	Allowed           bool      `json:"allowed"`
+	Reason            string    `json:"reason,omitempty"` // Why the consumption was denied
+	Used              int64     `json:"used"`
+	Limit             *int64    `json:"limit,omitempty"`
+	Remaining         *int64    `json:"remaining,omitempty"`
+	SoftLimit         *int64    `json:"soft_limit,omitempty"`
+	SoftLimitExceeded bool      `json:"soft_limit_exceeded"`
+	PeriodEnd         time.Time `json:"period_end"`
+}
+
+// Consume atomically consumes amount of the quota of an organization, or a user for personal billing, for the feature in the current billing period
+// Consumption past the hard limit is rejected, consumption past the soft limit is allowed but flagged
+func Consume(ownerID string, feature string, amount int64) (Result, error) {
+	granted, periodEnd, err := currentGrant(ownerID, feature)
+	if errors.Is(err, ErrNotEntitled) {
+		return Result{Feature: feature}, err
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	blocked, err := spend.Blocked(ownerID)
+	if err != nil {
+		return Result{}, err
+	}
+	if blocked {
+		counter, err := mongodb.GetQuotaCounter(ownerID, feature, periodEnd)
+		if err != nil {
+			return Result{}, err
+		}
+		log.Printf("[QUOTA] Rejected %d %s for owner %s: spend cap reached", amount, feature, ownerID)
+		return Result{
+			Feature:   feature,
+			Reason:    ReasonSpendCapReached,
+			Used:      counter.Used,
+			Limit:     granted.Limit,
+			SoftLimit: granted.SoftLimit,
+			PeriodEnd: periodEnd,
+		}, nil
+	}
+
+	counter, allowed, err := mongodb.ConsumeQuota(ownerID, feature, periodEnd, amount, granted.Limit)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Feature:   feature,
+		Allowed:   allowed,
+		Used:      counter.Used,
+		Limit:     granted.Limit,
+		SoftLimit: granted.SoftLimit,
+		PeriodEnd: periodEnd,
+	}
+	if granted.Limit != nil {
+		remaining := max(*granted.Limit-counter.Used, 0)
+		result.Remaining = &remaining
+	}
+	if granted.SoftLimit != nil && counter.Used > *granted.SoftLimit {
+		result.SoftLimitExceeded = true
+		if allowed && counter.Used-amount <= *granted.SoftLimit {
+			log.Printf("[QUOTA] Owner %s went past the soft limit of %s (%d/%d)", ownerID, feature, counter.Used, *granted.SoftLimit)
+		}
+	}
+	if allowed {
+		if limit := alertLimit(granted.Limit, granted.SoftLimit); limit != nil {
+			alerts.CheckQuota(ownerID, feature, counter.Used-amount, counter.Used, *limit, periodEnd)
+		}
+	} else {
+		result.Reason = ReasonLimitReached
+		log.Printf("[QUOTA] Rejected %d %s for owner %s (%d/%d used)", amount, feature, ownerID, counter.Used, *granted.Limit)
+	}
+
+	return result, nil
+}
+
+// alertLimit returns the limit usage alerts are relative to: the hard limit, or the soft limit for features without one
+func alertLimit(limit *int64, softLimit *int64) *int64 {
+	if limit != nil {
+		return limit
+	}
+	return softLimit
+}
+
+// CurrentUsage returns how much of the feature the organization or user consumed in the current period, the same counter Consume updates
+func CurrentUsage(ownerID string, feature string) (int64, error) {
+	_, periodEnd, err := currentGrant(ownerID, feature)
+	if errors.Is(err, ErrNotEntitled) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	counter, err := mongodb.GetQuotaCounter(ownerID, feature, periodEnd)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Used, nil
+}
+
+// currentGrant returns the owner's grant of the feature and the end of its current quota period,
+// read from the cached entitlements so consuming quota doesn't call Clerk
+func currentGrant(ownerID string, feature string) (entitlementsTypes.Feature, time.Time, error) {
+	owner, err := mongodb.GetOrganizationByOwnerID(ownerID)
+	if err != nil {
+		return entitlementsTypes.Feature{}, time.Time{}, err
+	}
+	cached, err := clerk.GetCachedOwnerEntitlements(owner)
+	if err != nil {
+		return entitlementsTypes.Feature{}, time.Time{}, err
+	}
+
+	granted, ok := cached.Entitlements.Features[feature]
+	if !ok || !granted.Enabled {
+		return entitlementsTypes.Feature{}, time.Time{}, ErrNotEntitled
+	}
+
+	_, periodEnd := entitlements.CurrentPeriod(cached.Subscriptions, granted.GrantedBy, time.Now())
+	return granted, periodEnd, nil
+}
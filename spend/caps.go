@@ -0,0 +1,175 @@
+package spend
+
+import (
+	"fmt"
+	"log"
+	"nucleus/alerts"
+	"nucleus/catalog"
+	"nucleus/config"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// EventSpendCapUpdated is recorded in the billing history when an admin sets or removes the spend cap
+	EventSpendCapUpdated = "spend_cap.updated"
+	// EventSpendCapReached is recorded when the estimated spend reaches the cap and consumption gets blocked
+	EventSpendCapReached = "spend_cap.reached"
+	// EventSpendCapLifted is recorded when consumption is unblocked because the cap was raised or removed
+	EventSpendCapLifted = "spend_cap.lifted"
+)
+
+// SystemActor is the actor of billing events nucleus records on its own
+const SystemActor = "nucleus"
+
+// spendThreshold is the estimated spend that raises an alert, in the currency's smallest unit, 0 disables it
+var spendThreshold int64
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	spendThreshold = int64(config.Int("ALERT_SPEND_THRESHOLD", 0))
+}
+
+// GetCap returns the spend cap of an organization or user billed personally, nil if it has none
+func GetCap(ownerID string) (*mongodbTypes.SpendCap, error) {
+	owner, err := mongodb.GetOrganizationByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return owner.SpendCap, nil
+}
+
+// SetCap sets the owner's spend cap for each billing period, or removes it when amount is nil, and enforces it right away
+func SetCap(ownerID string, amount *int64, actor string) (*mongodbTypes.SpendCap, error) {
+	previous, err := GetCap(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var spendCap *mongodbTypes.SpendCap
+	if amount != nil {
+		if *amount <= 0 {
+			return nil, fmt.Errorf("amount must be positive")
+		}
+		spendCap = &mongodbTypes.SpendCap{
+			Amount:    *amount,
+			Currency:  catalog.Get().Currency,
+			UpdatedBy: actor,
+			UpdatedAt: time.Now(),
+		}
+		// Keep the block until Check decides whether the new amount lifts it
+		if previous != nil {
+			spendCap.ReachedPeriodEnd = previous.ReachedPeriodEnd
+		}
+	}
+
+	if err := mongodb.UpdateOwnerSpendCap(ownerID, spendCap); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"amount": nil}
+	if spendCap != nil {
+		data["amount"] = spendCap.Amount
+		data["currency"] = spendCap.Currency
+	}
+	if previous != nil {
+		data["previous_amount"] = previous.Amount
+	}
+	recordEvent(ownerID, EventSpendCapUpdated, actor, data)
+
+	if previous != nil && previous.ReachedPeriodEnd != nil && spendCap == nil {
+		recordEvent(ownerID, EventSpendCapLifted, actor, map[string]interface{}{"reason": "cap removed"})
+	}
+
+	if _, err := Check(ownerID); err != nil {
+		log.Printf("Error checking spend of owner %s: %v", ownerID, err)
+	}
+
+	return GetCap(ownerID)
+}
+
+// Blocked reports whether the organization or user reached its spend cap in the current period
+func Blocked(ownerID string) (bool, error) {
+	spendCap, err := GetCap(ownerID)
+	if err != nil {
+		return false, err
+	}
+	return spendCap != nil && spendCap.ReachedPeriodEnd != nil && spendCap.ReachedPeriodEnd.After(time.Now()), nil
+}
+
+// Check estimates the owner's spend, raises the spend threshold alert and enforces its spend cap:
+// reaching the cap blocks quota consumption and notifies the admins, raising the cap above the spend lifts the block
+// It runs when usage is ingested and after every flush, and concurrent checks record each transition only once
+func Check(ownerID string) (Estimate, error) {
+	estimate, err := EstimateCurrentPeriod(ownerID)
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	if spendThreshold > 0 && estimate.Amount >= spendThreshold {
+		alerts.Raise(mongodbTypes.Alert{
+			Type:           alerts.TypeSpendThreshold,
+			OrganizationID: ownerID,
+			Threshold:      spendThreshold,
+			Value:          estimate.Amount,
+			Currency:       estimate.Currency,
+			PeriodEnd:      estimate.PeriodEnd,
+		})
+	}
+
+	spendCap, err := GetCap(ownerID)
+	if err != nil || spendCap == nil {
+		return estimate, err
+	}
+	blocked := spendCap.ReachedPeriodEnd != nil && spendCap.ReachedPeriodEnd.Equal(estimate.PeriodEnd)
+
+	switch {
+	case estimate.Amount >= spendCap.Amount && !blocked:
+		changed, err := mongodb.UpdateOwnerSpendCapReached(ownerID, &estimate.PeriodEnd)
+		if err != nil || !changed {
+			return estimate, err
+		}
+		log.Printf("[SPEND] Owner %s reached its spend cap (%d/%d %s)", ownerID, estimate.Amount, spendCap.Amount, spendCap.Currency)
+		recordEvent(ownerID, EventSpendCapReached, SystemActor, map[string]interface{}{
+			"amount": estimate.Amount, "cap": spendCap.Amount, "currency": spendCap.Currency, "period_end": estimate.PeriodEnd,
+		})
+		alerts.Raise(mongodbTypes.Alert{
+			Type:           alerts.TypeSpendCapReached,
+			OrganizationID: ownerID,
+			Threshold:      spendCap.Amount,
+			Value:          estimate.Amount,
+			Currency:       spendCap.Currency,
+			PeriodEnd:      estimate.PeriodEnd,
+		})
+	case estimate.Amount < spendCap.Amount && blocked:
+		changed, err := mongodb.UpdateOwnerSpendCapReached(ownerID, nil)
+		if err != nil || !changed {
+			return estimate, err
+		}
+		log.Printf("[SPEND] Lifted the spend cap block of owner %s (%d/%d %s)", ownerID, estimate.Amount, spendCap.Amount, spendCap.Currency)
+		recordEvent(ownerID, EventSpendCapLifted, SystemActor, map[string]interface{}{
+			"amount": estimate.Amount, "cap": spendCap.Amount, "currency": spendCap.Currency,
+		})
+	}
+
+	return estimate, nil
+}
+
+// recordEvent appends a spend cap event to the owner's billing history
+func recordEvent(ownerID string, eventType string, actor string, data map[string]interface{}) {
+	err := mongodb.CreateBillingEvent(mongodbTypes.BillingEvent{
+		OrganizationID: ownerID,
+		Type:           eventType,
+		Actor:          actor,
+		Data:           data,
+	})
+	if err != nil {
+		log.Printf("Error recording billing event %s: %v", eventType, err)
+	}
+}
@@ -0,0 +1,42 @@
+package spend
+
+import (
+	catalogTypes "nucleus/types/catalog"
+)
+
+// Price returns what quantity units of the meter cost, in the currency's smallest unit
+func Price(meter catalogTypes.Meter, quantity int64) float64 {
+	if len(meter.Tiers) == 0 {
+		return float64(quantity) * meter.UnitAmount
+	}
+	if quantity <= 0 {
+		return 0
+	}
+
+	if meter.TiersMode == catalogTypes.TiersModeVolume {
+		for _, tier := range meter.Tiers {
+			if tier.UpTo == nil || quantity <= *tier.UpTo {
+				return float64(quantity)*tier.UnitAmount + tier.FlatAmount
+			}
+		}
+		return 0
+	}
+
+	var amount float64
+	var lower int64
+	for _, tier := range meter.Tiers {
+		if quantity <= lower {
+			break
+		}
+		upper := quantity
+		if tier.UpTo != nil && *tier.UpTo < quantity {
+			upper = *tier.UpTo
+		}
+		amount += float64(upper-lower)*tier.UnitAmount + tier.FlatAmount
+		if tier.UpTo == nil {
+			break
+		}
+		lower = *tier.UpTo
+	}
+	return amount
+}
@@ -0,0 +1,69 @@
+package spend
+
+import (
+	"math"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/entitlements"
+	"nucleus/mongodb"
+	"sort"
+	"time"
+)
+
+// Estimate is the metered spend an organization or user accrued in a period, priced with the plan catalog meters
+// Amounts are in the smallest unit of the currency, usage of meters missing from the catalog is reported but not priced
+type Estimate struct {
+	OrganizationID string       `json:"organization_id"` // Clerk organization ID, or user ID for personal billing
+	Currency       string       `json:"currency"`
+	Amount         int64        `json:"amount"`
+	PeriodStart    time.Time    `json:"period_start"`
+	PeriodEnd      time.Time    `json:"period_end"`
+	Meters         []MeterSpend `json:"meters"`
+}
+
+// MeterSpend is the usage and spend of a single meter
+type MeterSpend struct {
+	Meter    string `json:"meter"`
+	Quantity int64  `json:"quantity"`
+	Amount   int64  `json:"amount"`
+	Priced   bool   `json:"priced"`
+}
+
+// EstimateCurrentPeriod estimates the metered spend of an organization or user in its current billing period,
+// the same period quotas are counted over
+func EstimateCurrentPeriod(ownerID string) (Estimate, error) {
+	owner, err := mongodb.GetOrganizationByOwnerID(ownerID)
+	if err != nil {
+		return Estimate{}, err
+	}
+	cached, err := clerk.GetCachedOwnerEntitlements(owner)
+	if err != nil {
+		return Estimate{}, err
+	}
+	start, end := entitlements.CurrentPeriod(cached.Subscriptions, nil, time.Now())
+
+	totals, err := mongodb.SumUsageByMeter(ownerID, start, end)
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	estimate := Estimate{
+		OrganizationID: ownerID,
+		Currency:       catalog.Get().Currency,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		Meters:         []MeterSpend{},
+	}
+	for name, quantity := range totals {
+		meterSpend := MeterSpend{Meter: name, Quantity: quantity}
+		if meter, ok := catalog.GetMeter(name); ok {
+			meterSpend.Amount = int64(math.Round(Price(meter, quantity)))
+			meterSpend.Priced = true
+		}
+		estimate.Amount += meterSpend.Amount
+		estimate.Meters = append(estimate.Meters, meterSpend)
+	}
+	sort.Slice(estimate.Meters, func(i, j int) bool { return estimate.Meters[i].Meter < estimate.Meters[j].Meter })
+
+	return estimate, nil
+}
@@ -0,0 +1,21 @@
+package stripe
+
+import (
+	"fmt"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/charge"
+)
+
+// getCharge fetches a charge that a dispute or early fraud warning only references by ID
+func getCharge(chargeID string) (*stripe.Charge, error) {
+	result, err := charge.Get(chargeID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if result.Customer == nil {
+		return nil, fmt.Errorf("charge %s has no customer", chargeID)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package stripe
+
+import (
+	"fmt"
+	"log"
+	"nucleus/catalog"
+	"nucleus/credits"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+)
+
+// creditPackPurchase is a number of units of a credit pack bought in a one-time payment
+type creditPackPurchase struct {
+	PackID   string
+	Quantity int64
+}
+
+// creditPackPurchases keeps the line items of a Checkout session that are priced as credit packs, in their order
+func creditPackPurchases(items []*stripe.LineItem) []creditPackPurchase {
+	purchases := []creditPackPurchase{}
+	for _, item := range items {
+		if item.Price == nil {
+			continue
+		}
+		if pack, ok := catalog.FindCreditPack(item.Price.ID); ok {
+			purchases = append(purchases, creditPackPurchase{PackID: pack.ID, Quantity: item.Quantity})
+		}
+	}
+
+	return purchases
+}
+
+// creditPackFromMetadata reads the credit pack of a payment intent created outside of Checkout
+// from its credit_pack and optional credit_pack_quantity metadata
+func creditPackFromMetadata(metadata map[string]string) (creditPackPurchase, bool, error) {
+	packID, ok := metadata["credit_pack"]
+	if !ok {
+		return creditPackPurchase{}, false, nil
+	}
+
+	purchase := creditPackPurchase{PackID: packID, Quantity: 1}
+	if quantity, ok := metadata["credit_pack_quantity"]; ok {
+		parsed, err := strconv.ParseInt(quantity, 10, 64)
+		if err != nil || parsed <= 0 {
+			return creditPackPurchase{}, false, fmt.Errorf("invalid credit_pack_quantity %q", quantity)
+		}
+		purchase.Quantity = parsed
+	}
+
+	return purchase, true, nil
+}
+
+// grantCreditPacks credits the wallet of the customer's organization with the packs bought in a payment
+// The idempotency key is made of the payment intent ID and the purchase's position among the payment's packs,
+// so the Checkout and payment intent events of the same payment grant the credits only once,
+// while several line items of the same pack each grant their own credits
+func grantCreditPacks(customerId string, paymentIntentID string, purchases []creditPackPurchase) error {
+	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+	if organization.IsUser() {
+		log.Printf("[STRIPE] Skipping credit packs bought by customer %s, credits are only sold to organizations", customerId)
+		return nil
+	}
+
+	for i, purchase := range purchases {
+		pack, ok := catalog.GetCreditPack(purchase.PackID)
+		if !ok {
+			log.Printf("Error granting credits for payment %s: unknown credit pack %s", paymentIntentID, purchase.PackID)
+			continue
+		}
+
+		var expiresAt *time.Time
+		if pack.ExpiresInDays > 0 {
+			expiry := time.Now().AddDate(0, 0, pack.ExpiresInDays)
+			expiresAt = &expiry
+		}
+
+		key := fmt.Sprintf("payment:%s:%d:%s", paymentIntentID, i, pack.ID)
+		description := fmt.Sprintf("%d x %s", purchase.Quantity, pack.Name)
+		if _, err := credits.Grant(organization.ClerkID, key, pack.Credits*purchase.Quantity, paymentIntentID, pack.ID, expiresAt, description); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revokeCreditPacks takes back the share of the credits granted for a payment that its charge refunded so far
+// The key is made of the charge ID and the refunded amount, so redelivered refund events take the credits back only once,
+// and each further partial refund takes back what the previous ones didn't
+func revokeCreditPacks(customerId string, paymentIntentID string, charge *stripe.Charge) error {
+	if charge.Amount <= 0 || charge.AmountRefunded <= 0 {
+		return nil
+	}
+
+	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		return err
+	}
+	if organization.IsUser() {
+		return nil
+	}
+
+	granted, err := mongodb.SumCreditTransactions(organization.ClerkID, paymentIntentID, mongodbTypes.CreditTransactionGrant)
+	if err != nil {
+		return err
+	}
+	revoked, err := mongodb.SumCreditTransactions(organization.ClerkID, paymentIntentID, mongodbTypes.CreditTransactionRevocation)
+	if err != nil {
+		return err
+	}
+	due := granted
+	if !charge.Refunded {
+		due = granted * min(charge.AmountRefunded, charge.Amount) / charge.Amount
+	}
+	if due-revoked <= 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf("refund:%s:%d", charge.ID, charge.AmountRefunded)
+	transaction, err := credits.Revoke(organization.ClerkID, key, due-revoked, paymentIntentID, "Payment refunded")
+	if err != nil {
+		return err
+	}
+	if transaction.Amount < due-revoked {
+		log.Printf("[STRIPE] Took back %d of the %d credits refunded for payment %s, the rest was already consumed", transaction.Amount, due-revoked, paymentIntentID)
+	}
+
+	return nil
+}
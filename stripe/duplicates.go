@@ -0,0 +1,39 @@
+package stripe
+
+import (
+	"log"
+	"nucleus/alerts"
+	"nucleus/mongodb"
+	entitlementsTypes "nucleus/types/entitlements"
+	mongodbTypes "nucleus/types/mongodb"
+	"strings"
+)
+
+// checkDuplicateSubscriptions alerts the admins of the customer's organization when several of its subscriptions
+// grant a base plan, which usually means an upgrade through Checkout didn't cancel the old subscription
+// current is the entitlements just computed for the subscription change, so Clerk isn't read again
+// The alert is deduplicated on the set of subscriptions, so it's raised again only if that set changes
+func checkDuplicateSubscriptions(customerId string, current entitlementsTypes.Entitlements) {
+	if len(current.DuplicateSubscriptions) == 0 {
+		return
+	}
+
+	organization, err := mongodb.GetOrganizationByStripeCustomerID(customerId)
+	if err != nil {
+		log.Printf("Error getting organization by customer ID: %v", err)
+		return
+	}
+
+	if organization.IsUser() {
+		log.Printf("[STRIPE] User %s has duplicate subscriptions: %v", organization.ClerkUserID, current.DuplicateSubscriptions)
+		return
+	}
+
+	log.Printf("[STRIPE] Organization %s has duplicate subscriptions: %v", organization.ClerkID, current.DuplicateSubscriptions)
+	alerts.Raise(mongodbTypes.Alert{
+		Type:           alerts.TypeDuplicateSubscriptions,
+		OrganizationID: organization.ClerkID,
+		Subject:        strings.Join(current.DuplicateSubscriptions, ","),
+		Value:          int64(len(current.DuplicateSubscriptions)),
+	})
+}
@@ -0,0 +1,24 @@
+package stripe
+
+import (
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/entitlements/activeentitlement"
+)
+
+// listActiveEntitlementLookupKeys fetches the lookup keys of every feature the customer is currently entitled to in Stripe
+func listActiveEntitlementLookupKeys(customerId string) ([]string, error) {
+	params := &stripe.EntitlementsActiveEntitlementListParams{
+		Customer: stripe.String(customerId),
+	}
+
+	lookupKeys := []string{}
+	iter := activeentitlement.List(params)
+	for iter.Next() {
+		lookupKeys = append(lookupKeys, iter.EntitlementsActiveEntitlement().LookupKey)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return lookupKeys, nil
+}
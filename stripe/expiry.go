@@ -0,0 +1,122 @@
+package stripe
+
+import (
+	"errors"
+	"log"
+	"nucleus/clerk"
+	"nucleus/config"
+	"nucleus/lifecycle"
+	"nucleus/mongodb"
+	"slices"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/subscription"
+)
+
+// CauseSubscriptionExpired is the cause of the transitions applied when the sweep confirms a stale subscription with Stripe
+const CauseSubscriptionExpired = "sweep.subscription_expired"
+
+func init() {
+	if err := mongodb.EnsureSubscriptionExpiryIndex(); err != nil {
+		log.Fatalf("Error creating subscription expiry index: %v", err)
+	}
+}
+
+// StartSubscriptionExpirySweeper periodically confirms with Stripe the mirrored subscriptions whose current period ended
+// A renewal or deletion webhook that never arrived would otherwise leave them in metadata until the next event for the customer
+func StartSubscriptionExpirySweeper() {
+	interval := config.Duration("SUBSCRIPTION_SWEEP_INTERVAL", 15*time.Minute)
+	leeway := config.Duration("SUBSCRIPTION_SWEEP_LEEWAY", time.Hour)
+
+	go func() {
+		backfillSubscriptionsExpireAt()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredSubscriptions(time.Now().Add(-leeway))
+		}
+	}()
+}
+
+// backfillSubscriptionsExpireAt computes the subscriptions expiry of the mappings that don't have one yet
+// Once every mapping has it, stored as null when there's no subscription, later starts find nothing to backfill
+func backfillSubscriptionsExpireAt() {
+	owners, err := mongodb.ListOrganizationsWithoutSubscriptionsExpireAt()
+	if err != nil {
+		log.Printf("Error listing owners without subscriptions expiry: %v", err)
+		return
+	}
+
+	for _, owner := range owners {
+		if err := clerk.BackfillSubscriptionsExpireAt(owner); err != nil {
+			log.Printf("Error backfilling subscriptions expiry of %s: %v", owner.OwnerID(), err)
+		}
+	}
+	log.Printf("[STRIPE] Backfilled the subscriptions expiry of %d owners", len(owners))
+}
+
+// sweepExpiredSubscriptions refreshes every subscription whose period ended before the given time from Stripe
+// The leeway before it leaves Stripe time to renew the subscription and deliver its webhook. Subscriptions
+// whose period is still ended after the refresh are left out of the owner's expiry until its metadata changes again
+func sweepExpiredSubscriptions(before time.Time) {
+	owners, err := mongodb.ListOrganizationsWithExpiredSubscriptions(before)
+	if err != nil {
+		log.Printf("Error listing owners with expired subscriptions: %v", err)
+		return
+	}
+
+	cause := lifecycle.Cause{Type: CauseSubscriptionExpired}
+	for _, owner := range owners {
+		subscriptionIDs, err := clerk.GetExpiredOwnerSubscriptionIDs(owner, before)
+		if err != nil {
+			log.Printf("Error getting expired subscriptions of %s: %v", owner.OwnerID(), err)
+			continue
+		}
+
+		var failedIDs []string
+		for _, subscriptionID := range subscriptionIDs {
+			if err := refreshExpiredSubscription(owner.StripeCustomerID, subscriptionID, cause); err != nil {
+				log.Printf("Error refreshing expired subscription %s of %s: %v", subscriptionID, owner.OwnerID(), err)
+				failedIDs = append(failedIDs, subscriptionID)
+			}
+		}
+
+		staleIDs, err := clerk.GetExpiredOwnerSubscriptionIDs(owner, before)
+		if err != nil {
+			log.Printf("Error getting expired subscriptions of %s: %v", owner.OwnerID(), err)
+			continue
+		}
+		confirmedIDs := slices.DeleteFunc(staleIDs, func(id string) bool { return slices.Contains(failedIDs, id) })
+		if len(confirmedIDs) == 0 {
+			continue
+		}
+		if err := clerk.AdvanceSubscriptionsExpireAt(owner, confirmedIDs); err != nil {
+			log.Printf("Error advancing subscriptions expiry of %s: %v", owner.OwnerID(), err)
+		}
+	}
+}
+
+// refreshExpiredSubscription mirrors the current state of a subscription from Stripe, like the matching webhook would
+// Subscriptions Stripe no longer has, or that ended, are removed from metadata and the rest are updated
+func refreshExpiredSubscription(customerId string, subscriptionID string, cause lifecycle.Cause) error {
+	sub, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		var stripeErr *stripe.Error
+		if !errors.As(err, &stripeErr) || stripeErr.Code != stripe.ErrorCodeResourceMissing {
+			return err
+		}
+		sub = &stripe.Subscription{ID: subscriptionID, Customer: &stripe.Customer{ID: customerId}, Status: stripe.SubscriptionStatusCanceled}
+	}
+
+	log.Printf("[STRIPE] Subscription %s of customer %s is past its period end in metadata, Stripe has it %s", subscriptionID, customerId, sub.Status)
+	switch sub.Status {
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		HandleSubscriptionDeleted(sub, cause)
+	default:
+		HandleSubscriptionUpdated(sub, cause)
+	}
+	return nil
+}
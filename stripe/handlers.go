@@ -3,30 +3,277 @@ package stripe
 import (
 	"log"
 	"nucleus/clerk"
+	"nucleus/holds"
+	"nucleus/lifecycle"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
 
 	"github.com/stripe/stripe-go/v82"
 )
 
 // HandleSubscriptionCreated handles the subscription created event
 // It adds the subscription information to the organization metadata
-func HandleSubscriptionCreated(subscription *stripe.Subscription) {
+func HandleSubscriptionCreated(subscription *stripe.Subscription, cause lifecycle.Cause) {
 	customerId := subscription.Customer.ID
-	clerk.AddSubscriptionToOrganizationMetadata(customerId, subscription)
+	computed, published := clerk.AddSubscriptionToOrganizationMetadata(customerId, subscription)
 	log.Printf("Subscription created for customer: %s, subscription: %s", customerId, subscription.ID)
+	applySubscriptionStatus(customerId, subscription, cause)
+	if published {
+		checkDuplicateSubscriptions(customerId, computed)
+	}
 }
 
 // HandleSubscriptionUpdated handles the subscription updated event
 // It updates the subscription information in the organization metadata
-func HandleSubscriptionUpdated(subscription *stripe.Subscription) {
+func HandleSubscriptionUpdated(subscription *stripe.Subscription, cause lifecycle.Cause) {
 	customerId := subscription.Customer.ID
-	clerk.UpdateSubscriptionInOrganizationMetadata(customerId, subscription)
+	computed, published := clerk.UpdateSubscriptionInOrganizationMetadata(customerId, subscription)
 	log.Printf("Subscription updated for customer: %s, subscription: %s", customerId, subscription.ID)
+	applySubscriptionStatus(customerId, subscription, cause)
+	if published {
+		checkDuplicateSubscriptions(customerId, computed)
+	}
 }
 
 // HandleSubscriptionDeleted handles the subscription deleted event
 // It removes the subscription from the organization metadata
-func HandleSubscriptionDeleted(subscription *stripe.Subscription) {
+func HandleSubscriptionDeleted(subscription *stripe.Subscription, cause lifecycle.Cause) {
 	customerId := subscription.Customer.ID
 	clerk.RemoveSubscriptionFromOrganizationMetadata(customerId, subscription.ID)
 	log.Printf("Subscription deleted for customer: %s, subscription: %s", customerId, subscription.ID)
+	applySubscriptionStatus(customerId, subscription, cause)
+}
+
+// HandleInvoicePaymentFailed handles the invoice payment failed event
+// A failed renewal of a subscription starts the grace period of the customer's owner, failed first payments leave the subscription incomplete instead
+func HandleInvoicePaymentFailed(invoice *stripe.Invoice, cause lifecycle.Cause) {
+	subscriptionID, ok := invoiceSubscriptionID(invoice)
+	if !ok || invoice.Customer == nil || invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate {
+		return
+	}
+
+	customerId := invoice.Customer.ID
+	if err := lifecycle.ApplyPaymentFailed(customerId, subscriptionID, cause); err != nil {
+		log.Printf("Error applying failed payment of invoice %s to billing state: %v", invoice.ID, err)
+		return
+	}
+	log.Printf("Invoice payment failed for customer: %s, invoice: %s, attempt: %d", customerId, invoice.ID, invoice.AttemptCount)
+}
+
+// HandleInvoicePaid handles the invoice paid event
+// Paying a subscription renewal restores a past due, grace or suspended owner to active
+// First invoices are skipped, the subscription events already start the lifecycle and a trial's free invoice mustn't end it
+func HandleInvoicePaid(invoice *stripe.Invoice, cause lifecycle.Cause) {
+	subscriptionID, ok := invoiceSubscriptionID(invoice)
+	if !ok || invoice.Customer == nil || invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate {
+		return
+	}
+
+	customerId := invoice.Customer.ID
+	if err := lifecycle.ApplyPaymentSucceeded(customerId, subscriptionID, cause); err != nil {
+		log.Printf("Error applying payment of invoice %s to billing state: %v", invoice.ID, err)
+		return
+	}
+	log.Printf("Invoice paid for customer: %s, invoice: %s", customerId, invoice.ID)
+}
+
+// applySubscriptionStatus moves the billing state of the customer's owner to match the subscription's status
+func applySubscriptionStatus(customerId string, subscription *stripe.Subscription, cause lifecycle.Cause) {
+	if err := lifecycle.ApplySubscriptionStatus(customerId, subscription.ID, string(subscription.Status), cause); err != nil {
+		log.Printf("Error applying status of subscription %s to billing state: %v", subscription.ID, err)
+	}
+}
+
+// HandleActiveEntitlementSummaryUpdated handles the active entitlement summary updated event
+// It fetches the customer's active entitlements and mirrors their lookup keys into the organization metadata
+func HandleActiveEntitlementSummaryUpdated(summary *stripe.EntitlementsActiveEntitlementSummary) {
+	customerId := summary.Customer
+	features, err := listActiveEntitlementLookupKeys(customerId)
+	if err != nil {
+		log.Printf("Error listing active entitlements for customer %s: %v", customerId, err)
+		return
+	}
+
+	clerk.SetStripeFeaturesInOrganizationMetadata(customerId, features)
+	log.Printf("Active entitlements updated for customer: %s, features: %v", customerId, features)
+}
+
+// HandleCheckoutSessionCompleted handles the checkout session completed and async payment succeeded events
+// It records the one-time plans and credits the organization's wallet with the credit packs bought in a paid one-time payment
+func HandleCheckoutSessionCompleted(checkoutSession *stripe.CheckoutSession) {
+	if checkoutSession.Mode != stripe.CheckoutSessionModePayment || checkoutSession.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		return
+	}
+	if checkoutSession.Customer == nil || checkoutSession.PaymentIntent == nil {
+		log.Printf("[STRIPE] Skipping checkout session %s without a customer or payment intent", checkoutSession.ID)
+		return
+	}
+
+	items, err := listCheckoutLineItems(checkoutSession.ID)
+	if err != nil {
+		log.Printf("Error listing line items of checkout session %s: %v", checkoutSession.ID, err)
+		return
+	}
+
+	customerId := checkoutSession.Customer.ID
+	if purchases := planPurchases(checkoutSession, items, time.Now()); len(purchases) > 0 {
+		if err := clerk.AddPurchasesToOwner(customerId, purchases); err != nil {
+			log.Printf("Error recording purchases of checkout session %s: %v", checkoutSession.ID, err)
+		} else {
+			log.Printf("Plans purchased by customer: %s, checkout session: %s", customerId, checkoutSession.ID)
+		}
+	}
+
+	if packs := creditPackPurchases(items); len(packs) > 0 {
+		if err := grantCreditPacks(customerId, checkoutSession.PaymentIntent.ID, packs); err != nil {
+			log.Printf("Error granting credits for checkout session %s: %v", checkoutSession.ID, err)
+		} else {
+			log.Printf("Credits purchased by customer: %s, checkout session: %s", customerId, checkoutSession.ID)
+		}
+	}
+}
+
+// HandlePaymentIntentSucceeded handles the payment intent succeeded event
+// It records the one-time plan and credits the organization's wallet with the credit pack named by the payment intent's metadata
+func HandlePaymentIntentSucceeded(paymentIntent *stripe.PaymentIntent) {
+	planPurchase, hasPlan, err := planPurchaseFromMetadata(paymentIntent, time.Now())
+	if err != nil {
+		log.Printf("Error reading plan of payment intent %s: %v", paymentIntent.ID, err)
+	}
+	packPurchase, hasPack, err := creditPackFromMetadata(paymentIntent.Metadata)
+	if err != nil {
+		log.Printf("Error reading credit pack of payment intent %s: %v", paymentIntent.ID, err)
+	}
+	if !hasPlan && !hasPack {
+		return
+	}
+	if paymentIntent.Customer == nil {
+		log.Printf("[STRIPE] Skipping payment intent %s without a customer", paymentIntent.ID)
+		return
+	}
+
+	customerId := paymentIntent.Customer.ID
+	if hasPlan {
+		if err := clerk.AddPurchasesToOwner(customerId, []mongodbTypes.Purchase{planPurchase}); err != nil {
+			log.Printf("Error recording purchase of payment intent %s: %v", paymentIntent.ID, err)
+		} else {
+			log.Printf("Plan purchased by customer: %s, payment intent: %s", customerId, paymentIntent.ID)
+		}
+	}
+
+	if hasPack {
+		if err := grantCreditPacks(customerId, paymentIntent.ID, []creditPackPurchase{packPurchase}); err != nil {
+			log.Printf("Error granting credits for payment intent %s: %v", paymentIntent.ID, err)
+		} else {
+			log.Printf("Credits purchased by customer: %s, payment intent: %s", customerId, paymentIntent.ID)
+		}
+	}
+}
+
+// HandleChargeRefunded handles the charge refunded event, for partial refunds too
+// It applies the refund policy to the customer's owner and takes back the share of the credits granted by the payment
+// that was refunded, and revokes the one-time purchases paid with the charge once it's fully refunded
+func HandleChargeRefunded(charge *stripe.Charge) {
+	if charge.Customer != nil {
+		err := holds.Place(charge.Customer.ID, holds.Hold{
+			Reason:      mongodbTypes.HoldReasonRefund,
+			ReferenceID: charge.ID,
+			ChargeID:    charge.ID,
+			Amount:      charge.AmountRefunded,
+			Currency:    string(charge.Currency),
+		})
+		if err != nil {
+			log.Printf("Error placing refund hold for charge %s: %v", charge.ID, err)
+		}
+	}
+
+	if charge.PaymentIntent == nil {
+		return
+	}
+
+	if charge.Customer != nil {
+		if err := revokeCreditPacks(charge.Customer.ID, charge.PaymentIntent.ID, charge); err != nil {
+			log.Printf("Error taking back credits of charge %s: %v", charge.ID, err)
+		}
+	}
+
+	// A partial refund can't be attributed to a single plan, so purchases are only revoked with the whole charge
+	if !charge.Refunded {
+		log.Printf("Charge partially refunded: %s, payment intent: %s, %d of %d", charge.ID, charge.PaymentIntent.ID, charge.AmountRefunded, charge.Amount)
+		return
+	}
+	if err := clerk.RefundPurchases(charge.PaymentIntent.ID); err != nil {
+		log.Printf("Error revoking purchases of charge %s: %v", charge.ID, err)
+		return
+	}
+	log.Printf("Charge refunded: %s, payment intent: %s", charge.ID, charge.PaymentIntent.ID)
+}
+
+// HandleDisputeCreated handles the charge dispute created event
+// It applies the dispute policy to the owner of the disputed charge's customer
+func HandleDisputeCreated(dispute *stripe.Dispute) {
+	if dispute.Charge == nil {
+		return
+	}
+
+	charge, err := getCharge(dispute.Charge.ID)
+	if err != nil {
+		log.Printf("Error getting disputed charge of dispute %s: %v", dispute.ID, err)
+		return
+	}
+
+	err = holds.Place(charge.Customer.ID, holds.Hold{
+		Reason:      mongodbTypes.HoldReasonDispute,
+		ReferenceID: dispute.ID,
+		ChargeID:    charge.ID,
+		Amount:      dispute.Amount,
+		Currency:    string(dispute.Currency),
+	})
+	if err != nil {
+		log.Printf("Error placing dispute hold for dispute %s: %v", dispute.ID, err)
+		return
+	}
+	log.Printf("Dispute created for customer: %s, dispute: %s", charge.Customer.ID, dispute.ID)
+}
+
+// HandleDisputeClosed handles the charge dispute closed event
+// It releases the dispute's hold when the dispute was won, a lost dispute keeps it
+func HandleDisputeClosed(dispute *stripe.Dispute) {
+	if dispute.Status != stripe.DisputeStatusWon {
+		log.Printf("Dispute closed: %s, status: %s", dispute.ID, dispute.Status)
+		return
+	}
+
+	if err := holds.Release(mongodbTypes.HoldReasonDispute, dispute.ID, holds.StripeActor, "dispute won"); err != nil {
+		log.Printf("Error releasing dispute hold for dispute %s: %v", dispute.ID, err)
+		return
+	}
+	log.Printf("Dispute won: %s", dispute.ID)
+}
+
+// HandleEarlyFraudWarningCreated handles the Radar early fraud warning created event
+// It applies the early fraud warning policy to the owner of the charge's customer
+func HandleEarlyFraudWarningCreated(warning *stripe.RadarEarlyFraudWarning) {
+	if warning.Charge == nil {
+		return
+	}
+
+	charge, err := getCharge(warning.Charge.ID)
+	if err != nil {
+		log.Printf("Error getting charge of early fraud warning %s: %v", warning.ID, err)
+		return
+	}
+
+	err = holds.Place(charge.Customer.ID, holds.Hold{
+		Reason:      mongodbTypes.HoldReasonEarlyFraudWarning,
+		ReferenceID: warning.ID,
+		ChargeID:    charge.ID,
+		Amount:      charge.Amount,
+		Currency:    string(charge.Currency),
+	})
+	if err != nil {
+		log.Printf("Error placing fraud warning hold for warning %s: %v", warning.ID, err)
+		return
+	}
+	log.Printf("Early fraud warning for customer: %s, warning: %s", charge.Customer.ID, warning.ID)
 }
@@ -0,0 +1,21 @@
+package stripe
+
+import (
+	"nucleus/lifecycle"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+)
+
+// eventCause describes a webhook event as the cause of a billing state transition
+func eventCause(event *stripe.Event) lifecycle.Cause {
+	return lifecycle.Cause{Type: string(event.Type), EventID: event.ID, At: time.Unix(event.Created, 0)}
+}
+
+// invoiceSubscriptionID returns the ID of the subscription that generated the invoice, if any
+func invoiceSubscriptionID(invoice *stripe.Invoice) (string, bool) {
+	if invoice.Parent == nil || invoice.Parent.SubscriptionDetails == nil || invoice.Parent.SubscriptionDetails.Subscription == nil {
+		return "", false
+	}
+	return invoice.Parent.SubscriptionDetails.Subscription.ID, true
+}
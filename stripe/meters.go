@@ -0,0 +1,26 @@
+package stripe
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/billing/meterevent"
+)
+
+// ReportMeterEvent sends a usage value for the customer to the Stripe billing meter with the given event name
+// Stripe ignores events whose identifier it already received, so retrying with the same identifier is safe
+func ReportMeterEvent(customerId string, eventName string, identifier string, value int64, timestamp time.Time) error {
+	params := &stripe.BillingMeterEventParams{
+		EventName:  stripe.String(eventName),
+		Identifier: stripe.String(identifier),
+		Payload: map[string]string{
+			"stripe_customer_id": customerId,
+			"value":              strconv.FormatInt(value, 10),
+		},
+		Timestamp: stripe.Int64(timestamp.Unix()),
+	}
+
+	_, err := meterevent.New(params)
+	return err
+}
@@ -0,0 +1,111 @@
+package stripe
+
+import (
+	"fmt"
+	"nucleus/catalog"
+	catalogTypes "nucleus/types/catalog"
+	mongodbTypes "nucleus/types/mongodb"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/checkout/session"
+)
+
+// listCheckoutLineItems fetches every line item of a Checkout session
+func listCheckoutLineItems(sessionID string) ([]*stripe.LineItem, error) {
+	params := &stripe.CheckoutSessionListLineItemsParams{
+		Session: stripe.String(sessionID),
+	}
+
+	items := []*stripe.LineItem{}
+	iter := session.ListLineItems(params)
+	for iter.Next() {
+		items = append(items, iter.LineItem())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// planPurchases maps the line items of a paid Checkout session to the one-time plans of the catalog
+func planPurchases(checkoutSession *stripe.CheckoutSession, items []*stripe.LineItem, now time.Time) []mongodbTypes.Purchase {
+	purchases := []mongodbTypes.Purchase{}
+	for _, item := range items {
+		if item.Price == nil {
+			continue
+		}
+
+		productID := ""
+		if item.Price.Product != nil {
+			productID = item.Price.Product.ID
+		}
+		plan, ok := catalog.FindPlan(productID, item.Price.ID)
+		if !ok || !plan.IsOneTime() {
+			continue
+		}
+
+		purchase := newPlanPurchase(plan, item.Price.ID, productID, item.Quantity, checkoutSession.PaymentIntent.ID, now)
+		purchase.CheckoutSessionID = checkoutSession.ID
+		purchases = append(purchases, purchase)
+	}
+
+	return purchases
+}
+
+// planPurchaseFromMetadata reads the one-time plan of a payment intent created outside of Checkout
+// from its plan and optional plan_quantity metadata
+func planPurchaseFromMetadata(paymentIntent *stripe.PaymentIntent, now time.Time) (mongodbTypes.Purchase, bool, error) {
+	planID, ok := paymentIntent.Metadata["plan"]
+	if !ok {
+		return mongodbTypes.Purchase{}, false, nil
+	}
+
+	plan, ok := catalog.GetPlan(planID)
+	if !ok || !plan.IsOneTime() {
+		return mongodbTypes.Purchase{}, false, fmt.Errorf("%q isn't a one-time plan", planID)
+	}
+
+	quantity := int64(1)
+	if value, ok := paymentIntent.Metadata["plan_quantity"]; ok {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return mongodbTypes.Purchase{}, false, fmt.Errorf("invalid plan_quantity %q", value)
+		}
+		quantity = parsed
+	}
+
+	// The plan's first price stands in for the price the payment was made at,
+	// so the purchase is recorded once per payment intent like Checkout line items
+	priceID, productID := "", ""
+	if len(plan.PriceIDs) > 0 {
+		priceID = plan.PriceIDs[0]
+	}
+	if len(plan.ProductIDs) > 0 {
+		productID = plan.ProductIDs[0]
+	}
+
+	return newPlanPurchase(plan, priceID, productID, quantity, paymentIntent.ID, now), true, nil
+}
+
+// newPlanPurchase builds the purchase of a one-time plan paid with a payment intent
+// A fixed-term plan bought several times lasts its duration once per unit
+func newPlanPurchase(plan catalogTypes.Plan, priceID string, productID string, quantity int64, paymentIntentID string, now time.Time) mongodbTypes.Purchase {
+	quantity = max(quantity, 1)
+	purchase := mongodbTypes.Purchase{
+		PlanID:          plan.ID,
+		PriceID:         priceID,
+		ProductID:       productID,
+		Quantity:        quantity,
+		PaymentIntentID: paymentIntentID,
+		PurchasedAt:     now,
+	}
+	if plan.DurationDays > 0 {
+		expiresAt := now.AddDate(0, 0, plan.DurationDays*int(quantity))
+		purchase.ExpiresAt = &expiresAt
+	}
+
+	return purchase
+}
@@ -0,0 +1,212 @@
+package stripe
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"nucleus/catalog"
+	"nucleus/clerk"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/subscription"
+	"github.com/stripe/stripe-go/v82/subscriptionschedule"
+)
+
+const (
+	// EventSubscriptionScheduleCreated is recorded in the billing history when an admin schedules a plan change
+	EventSubscriptionScheduleCreated = "subscription_schedule.created"
+	// EventSubscriptionScheduleReleased is recorded in the billing history when an admin releases a schedule
+	EventSubscriptionScheduleReleased = "subscription_schedule.released"
+)
+
+// ErrSubscriptionNotFound is returned when the subscription doesn't exist or belongs to another customer
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// ErrScheduleExists is returned when scheduling a change on a subscription that already has a schedule
+var ErrScheduleExists = errors.New("subscription already has a schedule")
+
+// ErrNoSchedule is returned when releasing the schedule of a subscription that has none
+var ErrNoSchedule = errors.New("subscription has no schedule")
+
+// ErrNotBasePlan is returned when a scheduled change isn't to a price of a recurring base plan in the catalog
+var ErrNotBasePlan = errors.New("price isn't a recurring base plan in the catalog")
+
+// HandleSubscriptionScheduleUpdated handles the subscription schedule events
+// Active schedules are mirrored into their subscription's metadata with their next phase, the others are removed from it
+func HandleSubscriptionScheduleUpdated(schedule *stripe.SubscriptionSchedule) {
+	subscriptionID := ""
+	if schedule.Subscription != nil {
+		subscriptionID = schedule.Subscription.ID
+	} else if schedule.ReleasedSubscription != nil {
+		subscriptionID = schedule.ReleasedSubscription.ID
+	}
+	if subscriptionID == "" || schedule.Customer == nil {
+		log.Printf("Subscription schedule %s has no subscription yet, skipping", schedule.ID)
+		return
+	}
+
+	customerId := schedule.Customer.ID
+	if err := mirrorSubscriptionSchedule(customerId, subscriptionID, schedule.ID, schedule.Status); err != nil {
+		log.Printf("Error mirroring subscription schedule %s: %v", schedule.ID, err)
+		return
+	}
+	log.Printf("Subscription schedule %s for customer: %s, subscription: %s", schedule.Status, customerId, subscriptionID)
+}
+
+// mirrorSubscriptionSchedule writes an active schedule into its subscription's metadata, or removes it once it ended
+// The schedule is fetched again with its phase prices expanded, so the next phase maps to a catalog plan
+func mirrorSubscriptionSchedule(customerId string, subscriptionID string, scheduleID string, status stripe.SubscriptionScheduleStatus) error {
+	if status != stripe.SubscriptionScheduleStatusActive {
+		return clerk.UpdateSubscriptionScheduleInOwnerMetadata(customerId, subscriptionID, nil)
+	}
+
+	params := &stripe.SubscriptionScheduleParams{}
+	params.AddExpand("phases.items.price")
+	schedule, err := subscriptionschedule.Get(scheduleID, params)
+	if err != nil {
+		return err
+	}
+	if schedule.Status != stripe.SubscriptionScheduleStatusActive {
+		return clerk.UpdateSubscriptionScheduleInOwnerMetadata(customerId, subscriptionID, nil)
+	}
+
+	return clerk.UpdateSubscriptionScheduleInOwnerMetadata(customerId, subscriptionID, clerk.SubscriptionScheduleMetadata(schedule))
+}
+
+// ScheduleSubscriptionChange schedules a subscription to switch its base plan to another price when its current period ends
+// Add-on items are kept, the schedule is released once the change applied, and a schedule that couldn't be set up is
+// released again so the subscription is left as it was
+func ScheduleSubscriptionChange(owner mongodbTypes.Organization, subscriptionID string, priceID string, quantity int64, createdBy string) (*stripe.SubscriptionSchedule, error) {
+	plan, ok := catalog.FindPlan("", priceID)
+	if !ok || plan.IsAddon() || plan.IsOneTime() {
+		return nil, ErrNotBasePlan
+	}
+
+	sub, err := getOwnerSubscription(owner, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Schedule != nil {
+		return nil, ErrScheduleExists
+	}
+	if sub.Items == nil {
+		return nil, fmt.Errorf("subscription %s has no items", subscriptionID)
+	}
+
+	schedule, err := subscriptionschedule.New(&stripe.SubscriptionScheduleParams{FromSubscription: stripe.String(subscriptionID)})
+	if err != nil {
+		return nil, err
+	}
+	if len(schedule.Phases) == 0 {
+		return nil, fmt.Errorf("schedule %s created without phases", schedule.ID)
+	}
+
+	current := schedule.Phases[0]
+	currentItems := []*stripe.SubscriptionSchedulePhaseItemParams{}
+	nextItems := []*stripe.SubscriptionSchedulePhaseItemParams{{Price: stripe.String(priceID), Quantity: stripe.Int64(quantity)}}
+	for _, item := range sub.Items.Data {
+		if item.Price == nil {
+			continue
+		}
+		itemParams := &stripe.SubscriptionSchedulePhaseItemParams{Price: stripe.String(item.Price.ID), Quantity: stripe.Int64(item.Quantity)}
+		currentItems = append(currentItems, itemParams)
+
+		productID := ""
+		if item.Price.Product != nil {
+			productID = item.Price.Product.ID
+		}
+		if itemPlan, ok := catalog.FindPlan(productID, item.Price.ID); ok && itemPlan.IsAddon() {
+			nextItems = append(nextItems, itemParams)
+		}
+	}
+
+	updated, err := subscriptionschedule.Update(schedule.ID, &stripe.SubscriptionScheduleParams{
+		EndBehavior: stripe.String(string(stripe.SubscriptionScheduleEndBehaviorRelease)),
+		Phases: []*stripe.SubscriptionSchedulePhaseParams{
+			{Items: currentItems, StartDate: stripe.Int64(current.StartDate), EndDate: stripe.Int64(current.EndDate)},
+			{Items: nextItems, Iterations: stripe.Int64(1)},
+		},
+	})
+	if err != nil {
+		if _, releaseErr := subscriptionschedule.Release(schedule.ID, nil); releaseErr != nil {
+			log.Printf("Error releasing schedule %s that couldn't be set up: %v", schedule.ID, releaseErr)
+		}
+		return nil, err
+	}
+	schedule = updated
+
+	if err := mirrorSubscriptionSchedule(owner.StripeCustomerID, subscriptionID, schedule.ID, schedule.Status); err != nil {
+		log.Printf("Error mirroring subscription schedule %s: %v", schedule.ID, err)
+	}
+	recordScheduleEvent(owner, EventSubscriptionScheduleCreated, createdBy, schedule, map[string]interface{}{
+		"plan_id":    plan.ID,
+		"price_id":   priceID,
+		"quantity":   quantity,
+		"start_date": current.EndDate,
+	})
+	log.Printf("[STRIPE] Scheduled subscription %s of %s to change to %s on %d", subscriptionID, owner.OwnerID(), plan.ID, current.EndDate)
+	return schedule, nil
+}
+
+// ReleaseSubscriptionSchedule releases the schedule of a subscription, which keeps its current items and drops the upcoming phases
+func ReleaseSubscriptionSchedule(owner mongodbTypes.Organization, subscriptionID string, releasedBy string) (*stripe.SubscriptionSchedule, error) {
+	sub, err := getOwnerSubscription(owner, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Schedule == nil {
+		return nil, ErrNoSchedule
+	}
+
+	schedule, err := subscriptionschedule.Release(sub.Schedule.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := clerk.UpdateSubscriptionScheduleInOwnerMetadata(owner.StripeCustomerID, subscriptionID, nil); err != nil {
+		log.Printf("Error removing subscription schedule %s from metadata: %v", schedule.ID, err)
+	}
+	recordScheduleEvent(owner, EventSubscriptionScheduleReleased, releasedBy, schedule, nil)
+	log.Printf("[STRIPE] Released schedule %s of subscription %s of %s", schedule.ID, subscriptionID, owner.OwnerID())
+	return schedule, nil
+}
+
+// getOwnerSubscription fetches a subscription from Stripe, making sure it belongs to the owner's customer
+func getOwnerSubscription(owner mongodbTypes.Organization, subscriptionID string) (*stripe.Subscription, error) {
+	sub, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, err
+	}
+	if sub.Customer == nil || sub.Customer.ID != owner.StripeCustomerID {
+		return nil, ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+// recordScheduleEvent appends a schedule change made by an admin to the organization's billing history
+func recordScheduleEvent(owner mongodbTypes.Organization, eventType string, actor string, schedule *stripe.SubscriptionSchedule, data map[string]interface{}) {
+	if owner.IsUser() {
+		return
+	}
+
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["schedule_id"] = schedule.ID
+
+	err := mongodb.CreateBillingEvent(mongodbTypes.BillingEvent{
+		OrganizationID: owner.ClerkID,
+		Type:           eventType,
+		Actor:          actor,
+		Data:           data,
+	})
+	if err != nil {
+		log.Printf("Error recording %s in billing history: %v", eventType, err)
+	}
+}
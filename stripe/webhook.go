@@ -55,37 +55,81 @@ func HandleWebhook(w http.ResponseWriter, r *http.Request) {
 }
 
 // processWebhookEvent processes the webhook event asynchronously
-// It handles subscription and invoice events
+// It handles subscription, invoice, entitlement, one-time payment, dispute and fraud events
 // It logs the event type if it's not handled
 func processWebhookEvent(event *stripe.Event) {
 	log.Printf("[STRIPE] Processing webhook event: %s", event.Type)
-	var subscription stripe.Subscription
-
-	jsonData, err := json.Marshal(event.Data.Object)
-	if err != nil {
-		log.Printf("Error marshaling webhook data: %v", err)
-		return
-	}
-
-	err = json.Unmarshal(jsonData, &subscription)
-	if err != nil {
-		log.Printf("Error parsing webhook JSON: %v", err)
-		return
-	}
 
 	switch event.Type {
 	case "customer.subscription.created":
-		HandleSubscriptionCreated(&subscription)
+		if subscription, ok := decodeEventObject[stripe.Subscription](event); ok {
+			HandleSubscriptionCreated(subscription, eventCause(event))
+		}
 	case "customer.subscription.updated":
-		HandleSubscriptionUpdated(&subscription)
+		if subscription, ok := decodeEventObject[stripe.Subscription](event); ok {
+			HandleSubscriptionUpdated(subscription, eventCause(event))
+		}
 	case "customer.subscription.deleted":
-		HandleSubscriptionDeleted(&subscription)
+		if subscription, ok := decodeEventObject[stripe.Subscription](event); ok {
+			HandleSubscriptionDeleted(subscription, eventCause(event))
+		}
+	case "subscription_schedule.created", "subscription_schedule.updated", "subscription_schedule.released",
+		"subscription_schedule.canceled", "subscription_schedule.completed", "subscription_schedule.aborted":
+		if schedule, ok := decodeEventObject[stripe.SubscriptionSchedule](event); ok {
+			HandleSubscriptionScheduleUpdated(schedule)
+		}
+	case "invoice.payment_failed":
+		if invoice, ok := decodeEventObject[stripe.Invoice](event); ok {
+			HandleInvoicePaymentFailed(invoice, eventCause(event))
+		}
+	case "invoice.paid":
+		if invoice, ok := decodeEventObject[stripe.Invoice](event); ok {
+			HandleInvoicePaid(invoice, eventCause(event))
+		}
+	case "entitlements.active_entitlement_summary.updated":
+		if summary, ok := decodeEventObject[stripe.EntitlementsActiveEntitlementSummary](event); ok {
+			HandleActiveEntitlementSummaryUpdated(summary)
+		}
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		if checkoutSession, ok := decodeEventObject[stripe.CheckoutSession](event); ok {
+			HandleCheckoutSessionCompleted(checkoutSession)
+		}
+	case "payment_intent.succeeded":
+		if paymentIntent, ok := decodeEventObject[stripe.PaymentIntent](event); ok {
+			HandlePaymentIntentSucceeded(paymentIntent)
+		}
+	case "charge.refunded":
+		if charge, ok := decodeEventObject[stripe.Charge](event); ok {
+			HandleChargeRefunded(charge)
+		}
+	case "charge.dispute.created":
+		if dispute, ok := decodeEventObject[stripe.Dispute](event); ok {
+			HandleDisputeCreated(dispute)
+		}
+	case "charge.dispute.closed":
+		if dispute, ok := decodeEventObject[stripe.Dispute](event); ok {
+			HandleDisputeClosed(dispute)
+		}
+	case "radar.early_fraud_warning.created":
+		if warning, ok := decodeEventObject[stripe.RadarEarlyFraudWarning](event); ok {
+			HandleEarlyFraudWarningCreated(warning)
+		}
 	default:
 		log.Printf("Unhandled event type: %s", event.Type)
 		return
 	}
 }
 
+// decodeEventObject parses the event's data object into the given Stripe resource type
+func decodeEventObject[T any](event *stripe.Event) (*T, bool) {
+	var object T
+	if err := json.Unmarshal(event.Data.Raw, &object); err != nil {
+		log.Printf("Error parsing webhook JSON: %v", err)
+		return nil, false
+	}
+	return &object, true
+}
+
 // getClientIP extracts the real client IP address from the request
 // It checks various headers that might contain the real IP when behind a proxy
 func getClientIP(r *http.Request) string {
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRUCache is a size bounded cache that evicts the least recently used entry and expires entries after a TTL
+type LRUCache[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List // Front is the most recently used entry
+	hits     int64
+	misses   int64
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+// CacheStats is a snapshot of the cache counters
+type CacheStats struct {
+	Size    int     `json:"size"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// NewLRUCache creates a cache holding at most capacity entries, each valid for ttl
+func NewLRUCache[V any](capacity int, ttl time.Duration) *LRUCache[V] {
+	return &LRUCache[V]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value stored for the key if it exists and hasn't expired
+func (c *LRUCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	element, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[V])
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(element)
+		c.misses++
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits++
+	return entry.value, true
+}
+
+// Set stores the value for the key, evicting the least recently used entry if the cache is full
+func (c *LRUCache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Delete removes the key from the cache
+func (c *LRUCache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// DeleteFunc removes every entry for which the function returns true
+func (c *LRUCache[V]) DeleteFunc(fn func(key string, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.items {
+		if fn(key, element.Value.(*lruEntry[V]).value) {
+			c.removeElement(element)
+		}
+	}
+}
+
+// Stats returns a snapshot of the cache size and hit rate
+func (c *LRUCache[V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{Size: c.order.Len(), Hits: c.hits, Misses: c.misses}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// removeElement removes the element from both the list and the index, callers must hold the lock
+func (c *LRUCache[V]) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*lruEntry[V]).key)
+}
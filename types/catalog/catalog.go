@@ -0,0 +1,98 @@
+package catalog
+
+// Catalog maps Stripe products and prices to named plans with features and limits
+// The version is bumped on every change so consumers can tell which catalog computed their entitlements
+// Rules map a feature to an expression that grants it when true, see the entitlements/rules package
+type Catalog struct {
+	Version     int               `json:"version"`
+	Plans       []Plan            `json:"plans"`
+	Rules       map[string]string `json:"rules,omitempty"`
+	Currency    string            `json:"currency,omitempty"` // Currency of the meter prices, defaults to usd
+	Meters      []Meter           `json:"meters,omitempty"`
+	CreditPacks []CreditPack      `json:"credit_packs,omitempty"`
+	Grace       *GracePolicy      `json:"grace,omitempty"` // Default grace policy of the plans that don't set one
+}
+
+// GracePolicy is how a subscription's status maps to access, and how long access is kept after a payment fails
+// A subscription in one of the grace statuses keeps access for Days after its first failed payment, then OnEnd applies
+type GracePolicy struct {
+	Days             int      `json:"days"`
+	EntitledStatuses []string `json:"entitled_statuses,omitempty"` // Statuses that grant access outright, defaults to active
+	GraceStatuses    []string `json:"grace_statuses,omitempty"`    // Statuses that grant access during grace, defaults to past_due
+	OnEnd            string   `json:"on_end,omitempty"`            // suspend (default) or cancel
+}
+
+const (
+	GraceOnEndSuspend = "suspend" // Remove access but keep the subscription so a later payment restores it
+	GraceOnEndCancel  = "cancel"  // Cancel the subscription in Stripe
+)
+
+// CreditPack is a number of prepaid credits sold through one-time Stripe prices
+// Each unit bought grants the pack's credits, which expire after ExpiresInDays unless it's 0
+type CreditPack struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	PriceIDs      []string `json:"price_ids"`
+	Credits       int64    `json:"credits"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+// Meter prices a Stripe billing meter so nucleus can estimate metered spend before Stripe invoices it
+// A meter either has a flat unit amount or tiers, mirroring the Stripe price it's billed with
+type Meter struct {
+	EventName  string  `json:"event_name"`
+	UnitAmount float64 `json:"unit_amount,omitempty"` // Price per unit in the currency's smallest unit, e.g. 0.05 cents
+	TiersMode  string  `json:"tiers_mode,omitempty"`  // graduated (default) or volume, like Stripe's tiers_mode
+	Tiers      []Tier  `json:"tiers,omitempty"`
+}
+
+// Tier is a price tier of a meter, the last tier has no upper bound
+type Tier struct {
+	UpTo       *int64  `json:"up_to"`
+	UnitAmount float64 `json:"unit_amount"`
+	FlatAmount float64 `json:"flat_amount,omitempty"`
+}
+
+const (
+	TiersModeGraduated = "graduated" // Each tier prices the units that fall into it
+	TiersModeVolume    = "volume"    // The tier the total falls into prices every unit
+)
+
+// Plan is a set of features and numeric limits granted by any of its Stripe prices or products
+// Limits are hard limits that reject consumption past them, soft limits only warn
+// One-time plans are bought with a single payment and last DurationDays, or forever when it's 0
+// Add-ons are bought on top of a base plan, their limits are multiplied by the quantity and added to the base limits
+type Plan struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	ProductIDs   []string         `json:"product_ids"`
+	PriceIDs     []string         `json:"price_ids"`
+	Features     []string         `json:"features"`
+	Limits       map[string]int64 `json:"limits"`
+	SoftLimits   map[string]int64 `json:"soft_limits,omitempty"`
+	Kind         string           `json:"kind,omitempty"`          // base (default) or addon
+	Tier         int              `json:"tier,omitempty"`          // Rank among base plans, the highest held one is the effective plan
+	Billing      string           `json:"billing,omitempty"`       // recurring (default) or one_time
+	DurationDays int              `json:"duration_days,omitempty"` // Only for one-time plans
+	Grace        *GracePolicy     `json:"grace,omitempty"`         // Overrides the catalog's grace policy for subscriptions to the plan
+}
+
+const (
+	PlanKindBase  = "base"  // A plan on its own, the highest limit among base plans applies
+	PlanKindAddon = "addon" // Extra features or limits on top of a base plan
+)
+
+const (
+	BillingRecurring = "recurring" // Granted by a subscription
+	BillingOneTime   = "one_time"  // Granted by a one-time payment
+)
+
+// IsAddon reports whether the plan is an add-on on top of a base plan
+func (p Plan) IsAddon() bool {
+	return p.Kind == PlanKindAddon
+}
+
+// IsOneTime reports whether the plan is bought with a one-time payment instead of a subscription
+func (p Plan) IsOneTime() bool {
+	return p.Billing == BillingOneTime
+}
@@ -11,3 +11,14 @@ type ClerkWebhookEvent struct {
 	Type      string          `json:"type"`
 	CreatedAt int64           `json:"created_at"`
 }
+
+// OrganizationSummary describes one of the user's organizations and its current plan
+type OrganizationSummary struct {
+	ID            string                   `json:"id"`
+	Name          string                   `json:"name"`
+	Slug          string                   `json:"slug"`
+	Role          string                   `json:"role"`
+	Active        bool                     `json:"active"`
+	Subscriptions []map[string]interface{} `json:"subscriptions"`
+	EffectivePlan string                   `json:"effective_plan,omitempty"`
+}
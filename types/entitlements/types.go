@@ -0,0 +1,34 @@
+package entitlements
+
+// Entitlements are the features and limits an organization is entitled to
+type Entitlements struct {
+	CatalogVersion int      `json:"catalog_version"`
+	Plans          []string `json:"plans"`
+	Addons         []string `json:"addons,omitempty"`         // Add-on plans held on top of the base plans
+	EffectivePlan  string   `json:"effective_plan,omitempty"` // Highest tier base plan, the one consumers should show and gate on
+	// Subscriptions that each grant a base plan, which usually means an old subscription wasn't canceled after a plan change
+	DuplicateSubscriptions []string           `json:"duplicate_subscriptions,omitempty"`
+	Features               map[string]Feature `json:"features"`
+	Suspended              bool               `json:"suspended,omitempty"`     // An access hold removed every feature
+	Flags                  []string           `json:"flags,omitempty"`         // Reasons of the holds flagging the owner for review
+	GraceEndsAt            *int64             `json:"grace_ends_at,omitempty"` // Earliest end of the grace period of a subscription kept only through grace
+	ComputedAt             int64              `json:"computed_at"`
+}
+
+// FeatureCheck is the answer to whether an owner has a feature, as returned by the entitlement check endpoints
+type FeatureCheck struct {
+	Feature   string   `json:"feature"`
+	Enabled   bool     `json:"enabled"`
+	Limit     *int64   `json:"limit,omitempty"`
+	SoftLimit *int64   `json:"soft_limit,omitempty"`
+	Usage     *int64   `json:"usage,omitempty"` // Current consumption of a limited feature, when nucleus can measure it
+	GrantedBy []string `json:"granted_by"`
+}
+
+// Feature is a single entitlement, either a flag or a numeric limit
+type Feature struct {
+	Enabled   bool     `json:"enabled"`
+	Limit     *int64   `json:"limit,omitempty"`      // Hard limit, consumption past it is rejected
+	SoftLimit *int64   `json:"soft_limit,omitempty"` // Soft limit, consumption past it is allowed with a warning
+	GrantedBy []string `json:"granted_by"`           // IDs of the subscriptions granting the feature
+}
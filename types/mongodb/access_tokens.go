@@ -0,0 +1,23 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// AccessToken is a named, scoped and expiring token minted by an organization admin for their scripts
+// Only the SHA-256 hash of the token is stored, the plaintext is returned once when the token is created
+type AccessToken struct {
+	ID             bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrganizationID string        `json:"organization_id" bson:"organization_id"`
+	Name           string        `json:"name" bson:"name"`
+	Prefix         string        `json:"prefix" bson:"prefix"` // First characters of the token, used to identify it in logs and listings
+	TokenHash      string        `json:"-" bson:"token_hash"`
+	Scopes         []string      `json:"scopes" bson:"scopes"` // Organization permissions granted to the token
+	CreatedBy      string        `json:"created_by" bson:"created_by"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	ExpiresAt      time.Time     `json:"expires_at" bson:"expires_at"`
+	LastUsedAt     *time.Time    `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
@@ -0,0 +1,41 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// AlertStatus is whether an alert reached its channels
+type AlertStatus string
+
+const (
+	AlertPending   AlertStatus = "pending"   // Stored, not delivered yet
+	AlertDelivered AlertStatus = "delivered" // Every configured channel accepted it
+	AlertFailed    AlertStatus = "failed"    // At least one channel failed after retries
+)
+
+// Alert is an event raised once per organization, subject, threshold and period
+// The dedup key is unique so an alert is never stored, and so never sent, twice
+type Alert struct {
+	ID             bson.ObjectID   `json:"id" bson:"_id,omitempty"`
+	DedupKey       string          `json:"-" bson:"dedup_key"`
+	Type           string          `json:"type" bson:"type"`
+	OrganizationID string          `json:"organization_id" bson:"organization_id"`     // Clerk organization ID
+	Subject        string          `json:"subject,omitempty" bson:"subject,omitempty"` // Feature or meter the alert is about
+	Threshold      int64           `json:"threshold" bson:"threshold"`                 // Percent of the limit, or an amount for spend alerts
+	Value          int64           `json:"value" bson:"value"`
+	Limit          int64           `json:"limit,omitempty" bson:"limit,omitempty"`
+	Currency       string          `json:"currency,omitempty" bson:"currency,omitempty"`
+	PeriodEnd      time.Time       `json:"period_end" bson:"period_end"`
+	CreatedAt      time.Time       `json:"created_at" bson:"created_at"`
+	Status         AlertStatus     `json:"status" bson:"status"`
+	Deliveries     []AlertDelivery `json:"deliveries" bson:"deliveries"`
+}
+
+// AlertDelivery is the outcome of sending an alert to a channel
+type AlertDelivery struct {
+	Channel string    `json:"channel" bson:"channel"`
+	At      time.Time `json:"at" bson:"at"`
+	Error   string    `json:"error,omitempty" bson:"error,omitempty"`
+}
@@ -0,0 +1,17 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// BillingEvent is an entry of an organization's billing history
+type BillingEvent struct {
+	ID             bson.ObjectID          `json:"id" bson:"_id,omitempty"`
+	OrganizationID string                 `json:"organization_id" bson:"organization_id"` // Clerk organization ID
+	Type           string                 `json:"type" bson:"type"`
+	Actor          string                 `json:"actor" bson:"actor"` // Clerk user ID, or "nucleus" for automatic events
+	Data           map[string]interface{} `json:"data,omitempty" bson:"data,omitempty"`
+	CreatedAt      time.Time              `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,56 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// BillingState is a step of an owner's billing lifecycle
+type BillingState string
+
+const (
+	BillingStateNone      BillingState = "none"      // Never subscribed
+	BillingStateTrialing  BillingState = "trialing"  // Subscribed on a trial
+	BillingStateActive    BillingState = "active"    // Paid up
+	BillingStatePastDue   BillingState = "past_due"  // A renewal payment failed and Stripe is retrying it
+	BillingStateGrace     BillingState = "grace"     // Still unpaid after the retry window, access is kept until the grace period ends
+	BillingStateSuspended BillingState = "suspended" // Unpaid after the grace period, access is removed until a payment succeeds
+	BillingStateCanceled  BillingState = "canceled"  // The subscription ended
+)
+
+// Entitled reports whether an owner in this state keeps access to its plan
+func (s BillingState) Entitled() bool {
+	switch s {
+	case BillingStateTrialing, BillingStateActive, BillingStatePastDue, BillingStateGrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// BillingLifecycle is the billing state of an organization, or of a user for personal billing
+// Version is incremented on every transition so concurrent events can't overwrite each other
+type BillingLifecycle struct {
+	ID               bson.ObjectID       `json:"-" bson:"_id,omitempty"`
+	OwnerID          string              `json:"owner_id" bson:"owner_id"` // Clerk organization ID, or user ID for personal billing
+	StripeCustomerID string              `json:"stripe_customer_id,omitempty" bson:"stripe_customer_id,omitempty"`
+	State            BillingState        `json:"state" bson:"state"`
+	SubscriptionID   string              `json:"subscription_id,omitempty" bson:"subscription_id,omitempty"` // Subscription driving the state
+	Since            time.Time           `json:"since" bson:"since"`
+	GraceEndsAt      *time.Time          `json:"grace_ends_at,omitempty" bson:"grace_ends_at,omitempty"`
+	LastEventAt      time.Time           `json:"-" bson:"last_event_at"` // Creation time of the latest Stripe event applied, older events are ignored
+	Transitions      []BillingTransition `json:"transitions" bson:"transitions"`
+	Version          int64               `json:"-" bson:"version"`
+	UpdatedAt        time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// BillingTransition records a change of billing state and what caused it
+type BillingTransition struct {
+	From           BillingState `json:"from" bson:"from"`
+	To             BillingState `json:"to" bson:"to"`
+	Cause          string       `json:"cause" bson:"cause"`                           // Stripe event type, or the timer that fired
+	EventID        string       `json:"event_id,omitempty" bson:"event_id,omitempty"` // Stripe event ID
+	SubscriptionID string       `json:"subscription_id,omitempty" bson:"subscription_id,omitempty"`
+	At             time.Time    `json:"at" bson:"at"`
+}
@@ -0,0 +1,82 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CreditTransactionType is what a credit transaction does to a wallet
+type CreditTransactionType string
+
+const (
+	CreditTransactionGrant  CreditTransactionType = "grant"
+	CreditTransactionDebit  CreditTransactionType = "debit"
+	CreditTransactionExpiry CreditTransactionType = "expiry"
+	// CreditTransactionRevocation takes back credits granted for a payment that was refunded
+	CreditTransactionRevocation CreditTransactionType = "revocation"
+)
+
+// CreditTransactionStatus is whether a credit transaction was applied to the wallet yet
+type CreditTransactionStatus string
+
+const (
+	CreditTransactionPending CreditTransactionStatus = "pending"
+	CreditTransactionPosted  CreditTransactionStatus = "posted"
+)
+
+// Ledger accounts credits move between, every transaction's entries sum to zero
+const (
+	CreditAccountWallet      = "wallet"
+	CreditAccountPurchases   = "purchases"
+	CreditAccountConsumption = "consumption"
+	CreditAccountExpired     = "expired"
+	CreditAccountRefunds     = "refunds"
+)
+
+// CreditWallet is the prepaid credit balance of an organization
+// Version is incremented on every change so concurrent transactions can't overwrite each other
+type CreditWallet struct {
+	ID                  bson.ObjectID   `json:"-" bson:"_id,omitempty"`
+	OrganizationID      string          `json:"organization_id" bson:"organization_id"` // Clerk organization ID
+	Balance             int64           `json:"balance" bson:"balance"`
+	Grants              []CreditGrant   `json:"grants" bson:"grants"`
+	Version             int64           `json:"-" bson:"version"`
+	AppliedTransactions []bson.ObjectID `json:"-" bson:"applied_transactions"`
+	UpdatedAt           time.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+// CreditGrant is a batch of credits added to a wallet, debits consume the grants expiring first
+type CreditGrant struct {
+	ID        bson.ObjectID `json:"id" bson:"id"`
+	Source    string        `json:"source" bson:"source"` // e.g. the Stripe payment intent ID
+	PackID    string        `json:"pack_id,omitempty" bson:"pack_id,omitempty"`
+	Amount    int64         `json:"amount" bson:"amount"`
+	Remaining int64         `json:"remaining" bson:"remaining"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// CreditTransaction is a ledger entry of a wallet, keyed by an idempotency key
+// It is stored as pending before the wallet is updated so an interrupted transaction can be finished on retry
+type CreditTransaction struct {
+	ID             bson.ObjectID           `json:"id" bson:"_id,omitempty"`
+	OrganizationID string                  `json:"organization_id" bson:"organization_id"` // Clerk organization ID
+	Type           CreditTransactionType   `json:"type" bson:"type"`
+	IdempotencyKey string                  `json:"idempotency_key" bson:"idempotency_key"`
+	Amount         int64                   `json:"amount" bson:"amount"`
+	Entries        []LedgerEntry           `json:"entries" bson:"entries"`
+	BalanceAfter   int64                   `json:"balance_after" bson:"balance_after"`
+	Status         CreditTransactionStatus `json:"status" bson:"status"`
+	Description    string                  `json:"description,omitempty" bson:"description,omitempty"`
+	Reference      string                  `json:"reference,omitempty" bson:"reference,omitempty"` // e.g. the Stripe payment intent ID
+	CreatedAt      time.Time               `json:"created_at" bson:"created_at"`
+	PostedAt       *time.Time              `json:"posted_at,omitempty" bson:"posted_at,omitempty"`
+}
+
+// LedgerEntry moves credits in or out of an account, positive amounts are credits
+type LedgerEntry struct {
+	Account string         `json:"account" bson:"account"`
+	GrantID *bson.ObjectID `json:"grant_id,omitempty" bson:"grant_id,omitempty"`
+	Amount  int64          `json:"amount" bson:"amount"`
+}
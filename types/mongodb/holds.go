@@ -0,0 +1,41 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// HoldReason is the payment event that placed an access hold
+type HoldReason string
+
+const (
+	HoldReasonDispute           HoldReason = "dispute"
+	HoldReasonRefund            HoldReason = "refund"
+	HoldReasonEarlyFraudWarning HoldReason = "early_fraud_warning"
+)
+
+// HoldAction is what an access hold does to the owner's access
+type HoldAction string
+
+const (
+	HoldActionSuspend HoldAction = "suspend" // Removes every feature until the hold is released
+	HoldActionFlag    HoldAction = "flag"    // Only marks the owner for review
+)
+
+// AccessHold is a suspension or flag placed on an owner after a dispute, refund or early fraud warning
+type AccessHold struct {
+	ID               bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerID          string        `json:"owner_id" bson:"owner_id"` // Clerk organization ID, or user ID for personal billing
+	StripeCustomerID string        `json:"stripe_customer_id" bson:"stripe_customer_id"`
+	Reason           HoldReason    `json:"reason" bson:"reason"`
+	Action           HoldAction    `json:"action" bson:"action"`
+	ReferenceID      string        `json:"reference_id" bson:"reference_id"` // ID of the dispute, refunded charge or early fraud warning
+	ChargeID         string        `json:"charge_id" bson:"charge_id"`
+	Amount           int64         `json:"amount" bson:"amount"`
+	Currency         string        `json:"currency" bson:"currency"`
+	CreatedAt        time.Time     `json:"created_at" bson:"created_at"`
+	ReleasedAt       *time.Time    `json:"released_at,omitempty" bson:"released_at,omitempty"`
+	ReleasedBy       string        `json:"released_by,omitempty" bson:"released_by,omitempty"`
+	ReleaseReason    string        `json:"release_reason,omitempty" bson:"release_reason,omitempty"`
+}
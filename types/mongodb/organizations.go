@@ -1,9 +1,51 @@
 package mongodb
 
-import "go.mongodb.org/mongo-driver/v2/bson"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// OwnerType identifies whether a billing mapping belongs to a Clerk organization or a Clerk user
+type OwnerType string
+
+const (
+	OwnerTypeOrganization OwnerType = "organization"
+	OwnerTypeUser         OwnerType = "user"
+)
+
+// Organization maps a Clerk organization (or a user billed personally) to its Stripe customer
 type Organization struct {
 	ID               bson.ObjectID `json:"id" bson:"_id"`
-	ClerkID          string        `json:"clerk_organization_id" bson:"clerk_organization_id"`
+	OwnerType        OwnerType     `json:"owner_type" bson:"owner_type,omitempty"`
+	ClerkID          string        `json:"clerk_organization_id,omitempty" bson:"clerk_organization_id,omitempty"`
+	ClerkUserID      string        `json:"clerk_user_id,omitempty" bson:"clerk_user_id,omitempty"`
 	StripeCustomerID string        `json:"stripe_customer_id" bson:"stripe_customer_id"`
+	StripeFeatures   []string      `json:"stripe_features,omitempty" bson:"stripe_features,omitempty"` // Lookup keys of the customer's active Stripe entitlements
+	SpendCap         *SpendCap     `json:"spend_cap,omitempty" bson:"spend_cap,omitempty"`
+	// Earliest current_period_end of the subscriptions mirrored in metadata, so stale ones can be found without reading every owner's metadata
+	SubscriptionsExpireAt *time.Time `json:"subscriptions_expire_at,omitempty" bson:"subscriptions_expire_at,omitempty"`
+}
+
+// SpendCap is the monthly limit an organization set on its metered spend
+type SpendCap struct {
+	Amount           int64      `json:"amount" bson:"amount"` // In the catalog currency's smallest unit
+	Currency         string     `json:"currency" bson:"currency"`
+	UpdatedBy        string     `json:"updated_by" bson:"updated_by"`
+	UpdatedAt        time.Time  `json:"updated_at" bson:"updated_at"`
+	ReachedPeriodEnd *time.Time `json:"reached_period_end,omitempty" bson:"reached_period_end,omitempty"` // End of the period in which the cap was reached
+}
+
+// IsUser reports whether the mapping belongs to a user instead of an organization.
+// Mappings created before user-level billing have no owner type and belong to organizations.
+func (o Organization) IsUser() bool {
+	return o.OwnerType == OwnerTypeUser
+}
+
+// OwnerID returns the Clerk ID of whoever the mapping bills, the user ID for personal billing
+func (o Organization) OwnerID() string {
+	if o.IsUser() {
+		return o.ClerkUserID
+	}
+	return o.ClerkID
 }
@@ -0,0 +1,48 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// OverrideKind is what an entitlement override grants or revokes
+type OverrideKind string
+
+const (
+	OverrideKindFeature OverrideKind = "feature" // Grants (optionally with a limit) or revokes a single feature
+	OverrideKindPlan    OverrideKind = "plan"    // Comps a catalog plan, granting all its features and limits
+)
+
+// EntitlementOverride is a manual grant or revocation made for an organization (or a user billed personally) outside of Stripe,
+// merged with the subscription-derived entitlements until it expires or is revoked
+type EntitlementOverride struct {
+	ID             bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrganizationID string        `json:"organization_id,omitempty" bson:"organization_id,omitempty"` // Clerk organization ID
+	UserID         string        `json:"user_id,omitempty" bson:"user_id,omitempty"`                 // Clerk user ID for personal billing
+	Kind           OverrideKind  `json:"kind" bson:"kind"`
+	Feature        string        `json:"feature,omitempty" bson:"feature,omitempty"`
+	Enabled        bool          `json:"enabled" bson:"enabled"`                 // False revokes the feature even if a plan grants it
+	Limit          *int64        `json:"limit,omitempty" bson:"limit,omitempty"` // Replaces the granted limit, add-ons included
+	PlanID         string        `json:"plan_id,omitempty" bson:"plan_id,omitempty"`
+	Reason         string        `json:"reason" bson:"reason"`
+	CreatedBy      string        `json:"created_by" bson:"created_by"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	ExpiresAt      time.Time     `json:"expires_at" bson:"expires_at"`
+	ExpiryApplied  bool          `json:"-" bson:"expiry_applied,omitempty"` // Set once the entitlements were recomputed after expiry
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	RevokedBy      string        `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+}
+
+// Owner returns the billing owner the override applies to
+func (o EntitlementOverride) Owner() Organization {
+	if o.UserID != "" {
+		return Organization{OwnerType: OwnerTypeUser, ClerkUserID: o.UserID}
+	}
+	return Organization{OwnerType: OwnerTypeOrganization, ClerkID: o.OrganizationID}
+}
+
+// OwnerID returns the Clerk ID of the organization or user the override applies to
+func (o EntitlementOverride) OwnerID() string {
+	return o.Owner().OwnerID()
+}
@@ -0,0 +1,26 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Purchase is a one-time plan bought with a single payment, granting the plan until it expires or is refunded
+type Purchase struct {
+	ID                bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerID           string        `json:"owner_id" bson:"owner_id"` // Clerk organization ID, or user ID for personal billing
+	StripeCustomerID  string        `json:"stripe_customer_id" bson:"stripe_customer_id"`
+	PlanID            string        `json:"plan_id" bson:"plan_id"`
+	PriceID           string        `json:"price_id" bson:"price_id"`
+	ProductID         string        `json:"product_id" bson:"product_id"`
+	Quantity          int64         `json:"quantity" bson:"quantity"`
+	PaymentIntentID   string        `json:"payment_intent_id" bson:"payment_intent_id"`
+	CheckoutSessionID string        `json:"checkout_session_id" bson:"checkout_session_id"`
+	PurchasedAt       time.Time     `json:"purchased_at" bson:"purchased_at"`
+	StartsAt          *time.Time    `json:"starts_at,omitempty" bson:"starts_at,omitempty"`   // Set when it extends a pass that's still running, nil when it starts right away
+	ExpiresAt         *time.Time    `json:"expires_at,omitempty" bson:"expires_at,omitempty"` // Nil for lifetime purchases
+	StartApplied      bool          `json:"-" bson:"start_applied,omitempty"`                 // Set once the entitlements were recomputed after a delayed start
+	ExpiryApplied     bool          `json:"-" bson:"expiry_applied,omitempty"`                // Set once the entitlements were recomputed after expiry
+	RefundedAt        *time.Time    `json:"refunded_at,omitempty" bson:"refunded_at,omitempty"`
+}
@@ -0,0 +1,18 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// QuotaCounter is how much of a limited feature an organization or user consumed in a billing period
+// A new counter is created when the period ends, which resets the usage
+type QuotaCounter struct {
+	ID             bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrganizationID string        `json:"organization_id" bson:"organization_id"` // Clerk organization ID, or user ID for personal billing
+	Feature        string        `json:"feature" bson:"feature"`
+	PeriodEnd      time.Time     `json:"period_end" bson:"period_end"`
+	Used           int64         `json:"used" bson:"used"`
+	UpdatedAt      time.Time     `json:"updated_at" bson:"updated_at"`
+}
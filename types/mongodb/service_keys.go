@@ -0,0 +1,22 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ServiceAPIKey is an API key used by internal backends to query nucleus
+// Only the SHA-256 hash of the key is stored, the plaintext is returned once when the key is created or rotated
+type ServiceAPIKey struct {
+	ID             bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name           string        `json:"name" bson:"name"`
+	Prefix         string        `json:"prefix" bson:"prefix"` // First characters of the key, used to identify it in logs and listings
+	KeyHash        string        `json:"-" bson:"key_hash"`
+	Scopes         []string      `json:"scopes" bson:"scopes"`
+	OrganizationID string        `json:"organization_id,omitempty" bson:"organization_id,omitempty"` // Restricts the key to a single organization when set
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	RotatedAt      *time.Time    `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	LastUsedAt     *time.Time    `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
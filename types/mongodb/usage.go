@@ -0,0 +1,41 @@
+package mongodb
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// UsageEventStatus is where a usage event is in its way to Stripe
+type UsageEventStatus string
+
+const (
+	UsageEventPending UsageEventStatus = "pending" // Buffered, waiting for the next flush
+	UsageEventSent    UsageEventStatus = "sent"    // Reported to Stripe as part of an aggregated meter event
+	UsageEventFailed  UsageEventStatus = "failed"  // The last flush failed, retried after next_attempt_at
+	UsageEventDead    UsageEventStatus = "dead"    // Gave up after USAGE_MAX_ATTEMPTS failed flushes
+)
+
+// UsageEvent is a usage record reported for an organization and buffered until it's flushed to a Stripe billing meter
+// The idempotency key is unique per organization so retried reports are only counted once
+type UsageEvent struct {
+	ID             bson.ObjectID            `json:"id" bson:"_id,omitempty"`
+	OrganizationID string                   `json:"organization_id" bson:"organization_id"` // Clerk organization ID, or user ID for personal billing
+	Meter          string                   `json:"meter" bson:"meter"`                     // Event name of the Stripe billing meter
+	Value          int64                    `json:"value" bson:"value"`
+	IdempotencyKey string                   `json:"idempotency_key" bson:"idempotency_key"`
+	Timestamp      time.Time                `json:"timestamp" bson:"timestamp"`
+	ReceivedAt     time.Time                `json:"received_at" bson:"received_at"`
+	Status         UsageEventStatus         `json:"status" bson:"status"`
+	Attempts       int                      `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time                `json:"next_attempt_at" bson:"next_attempt_at"`
+	FlushID        string                   `json:"flush_id,omitempty" bson:"flush_id,omitempty"` // Identifier of the Stripe meter event the record was aggregated into, set before it's sent
+	History        []UsageEventStatusChange `json:"history" bson:"history"`
+}
+
+// UsageEventStatusChange is an entry of a usage event's status trail
+type UsageEventStatusChange struct {
+	Status UsageEventStatus `json:"status" bson:"status"`
+	At     time.Time        `json:"at" bson:"at"`
+	Error  string           `json:"error,omitempty" bson:"error,omitempty"`
+}
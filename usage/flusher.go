@@ -0,0 +1,172 @@
+package usage
+
+import (
+	"log"
+	"nucleus/alerts"
+	"nucleus/config"
+	"nucleus/mongodb"
+	"nucleus/spend"
+	"nucleus/stripe"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// flushBatchSize is the maximum number of usage events read per flush
+const flushBatchSize = 1000
+
+// maxRetryDelay caps the exponential backoff between failed flushes
+const maxRetryDelay = time.Hour
+
+// StartFlusher periodically aggregates the buffered usage events and reports them to Stripe billing meters,
+// then redelivers the alerts that were never delivered
+func StartFlusher() {
+	interval := config.Duration("USAGE_FLUSH_INTERVAL", time.Minute)
+	maxAttempts := config.Int("USAGE_MAX_ATTEMPTS", 5)
+	window := config.Duration("USAGE_BATCH_WINDOW", time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			Flush(maxAttempts, window)
+			alerts.RedeliverPending()
+		}
+	}()
+}
+
+// batch is the usage events of an organization and meter that are reported as a single Stripe meter event
+// A batch that was already claimed has a flush ID, and is always resent whole under it
+type batch struct {
+	organizationID string
+	meter          string
+	flushID        string
+	events         []mongodbTypes.UsageEvent
+}
+
+// Flush reports every due usage event to Stripe, aggregated by owner, meter and timestamp window, then checks the spend
+// of every owner it reported usage for
+// Batches never span more than one window, so a billing period boundary moves at most a window of usage into the next period
+func Flush(maxAttempts int, window time.Duration) {
+	events, err := mongodb.ListDueUsageEvents(flushBatchSize)
+	if err != nil {
+		log.Printf("Error listing usage events: %v", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	batches := map[string]*batch{}
+	for _, event := range events {
+		// Events claimed by a flush that didn't complete go back out in the same batch
+		key := event.FlushID
+		if key == "" {
+			key = event.OrganizationID + "\x00" + event.Meter + "\x00" + event.Timestamp.Truncate(window).String()
+		}
+		if batches[key] == nil {
+			batches[key] = &batch{organizationID: event.OrganizationID, meter: event.Meter, flushID: event.FlushID}
+		}
+		batches[key].events = append(batches[key].events, event)
+	}
+
+	owners := map[string]bool{}
+	for _, b := range batches {
+		if flushBatch(b, maxAttempts) {
+			owners[b.organizationID] = true
+		}
+	}
+
+	for ownerID := range owners {
+		if _, err := spend.Check(ownerID); err != nil {
+			log.Printf("Error checking spend of owner %s: %v", ownerID, err)
+		}
+	}
+}
+
+// flushBatch sends the sum of the batch to the meter, stamped with its latest event's timestamp, and reports whether it was sent
+// The events are claimed under a new flush ID before anything is sent, so if marking them sent fails after Stripe accepted
+// the meter event, the next flush resends exactly the same events under the same identifier and Stripe drops the duplicate
+func flushBatch(b *batch, maxAttempts int) bool {
+	if b.flushID == "" {
+		b.flushID = "nucleus_" + bson.NewObjectID().Hex()
+		ids := make([]bson.ObjectID, 0, len(b.events))
+		for _, event := range b.events {
+			ids = append(ids, event.ID)
+		}
+		if err := mongodb.ClaimUsageEvents(ids, b.flushID); err != nil {
+			log.Printf("Error claiming usage events for flush %s: %v", b.flushID, err)
+			return false
+		}
+	}
+
+	events, err := mongodb.ListUsageEventsByFlushID(b.flushID)
+	if err != nil {
+		log.Printf("Error listing usage events of flush %s: %v", b.flushID, err)
+		return false
+	}
+	if len(events) == 0 {
+		return false
+	}
+
+	var total int64
+	var timestamp time.Time
+	for _, event := range events {
+		total += event.Value
+		if event.Timestamp.After(timestamp) {
+			timestamp = event.Timestamp
+		}
+	}
+
+	// Personal users buffer usage under their user ID
+	organization, err := mongodb.GetOrganizationByOwnerID(b.organizationID)
+	if err == nil && organization.StripeCustomerID == "" {
+		err = errNoCustomer
+	}
+	if err == nil {
+		err = stripe.ReportMeterEvent(organization.StripeCustomerID, b.meter, b.flushID, total, timestamp)
+	}
+
+	if err != nil {
+		log.Printf("Error flushing %d usage events of organization %s to meter %s: %v", len(events), b.organizationID, b.meter, err)
+		markFailed(events, maxAttempts, err)
+		return false
+	}
+
+	if err := mongodb.MarkUsageEventsSent(b.flushID); err != nil {
+		// The events keep their flush ID, so the next flush resends the same meter event and Stripe drops it
+		log.Printf("Error marking usage events of flush %s as sent: %v", b.flushID, err)
+		return true
+	}
+	log.Printf("[STRIPE] Reported %d to meter %s for organization %s (%d events in %s)", total, b.meter, b.organizationID, len(events), b.flushID)
+	return true
+}
+
+// markFailed schedules a retry with exponential backoff, or gives up on the events that ran out of attempts
+func markFailed(events []mongodbTypes.UsageEvent, maxAttempts int, cause error) {
+	retries := map[int][]bson.ObjectID{}
+	dead := []bson.ObjectID{}
+	for _, event := range events {
+		if event.Attempts+1 >= maxAttempts {
+			dead = append(dead, event.ID)
+		} else {
+			retries[event.Attempts] = append(retries[event.Attempts], event.ID)
+		}
+	}
+
+	for attempts, ids := range retries {
+		delay := min(time.Minute<<attempts, maxRetryDelay)
+		if err := mongodb.MarkUsageEventsFailed(ids, mongodbTypes.UsageEventFailed, time.Now().Add(delay), cause.Error()); err != nil {
+			log.Printf("Error marking usage events as failed: %v", err)
+		}
+	}
+
+	if len(dead) > 0 {
+		log.Printf("[STRIPE] Giving up on %d usage events after %d attempts", len(dead), maxAttempts)
+		if err := mongodb.MarkUsageEventsFailed(dead, mongodbTypes.UsageEventDead, time.Now(), cause.Error()); err != nil {
+			log.Printf("Error marking usage events as dead: %v", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"nucleus/mongodb"
+	mongodbTypes "nucleus/types/mongodb"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// maxEventAge is how old a usage event may be, Stripe rejects meter events older than 35 days
+const maxEventAge = 35 * 24 * time.Hour
+
+// maxEventSkew is how far in the future a usage event may be, Stripe rejects meter events more than 5 minutes ahead
+const maxEventSkew = 5 * time.Minute
+
+// ErrDuplicate is returned when a usage event with the same idempotency key was already recorded
+var ErrDuplicate = errors.New("usage event already recorded")
+
+// errNoCustomer is the flush error of organizations without a Stripe customer
+var errNoCustomer = errors.New("organization has no Stripe customer")
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if err := mongodb.EnsureUsageEventIndexes(); err != nil {
+		log.Fatalf("Error creating usage event indexes: %v", err)
+	}
+}
+
+// Record is a usage record reported by a client
+type Record struct {
+	OrganizationID string    `json:"organization_id"` // Clerk organization ID, or user ID for personal billing
+	Meter          string    `json:"meter"`
+	Value          int64     `json:"value"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Validate checks that the record can be reported to Stripe, a missing timestamp defaults to now
+func (r *Record) Validate() error {
+	if r.OrganizationID == "" || r.Meter == "" || r.IdempotencyKey == "" {
+		return fmt.Errorf("organization_id, meter and idempotency_key are required")
+	}
+	if r.Value < 0 {
+		return fmt.Errorf("value can't be negative")
+	}
+
+	now := time.Now()
+	if r.Timestamp.IsZero() {
+		r.Timestamp = now
+	}
+	if r.Timestamp.Before(now.Add(-maxEventAge)) {
+		return fmt.Errorf("timestamp must be within the last 35 days")
+	}
+	if r.Timestamp.After(now.Add(maxEventSkew)) {
+		return fmt.Errorf("timestamp can't be more than 5 minutes in the future")
+	}
+
+	return nil
+}
+
+// Ingest buffers a validated record until the next flush, returning ErrDuplicate if its idempotency key was already used
+func Ingest(record Record) (mongodbTypes.UsageEvent, error) {
+	now := time.Now()
+	event := mongodbTypes.UsageEvent{
+		OrganizationID: record.OrganizationID,
+		Meter:          record.Meter,
+		Value:          record.Value,
+		IdempotencyKey: record.IdempotencyKey,
+		Timestamp:      record.Timestamp,
+		ReceivedAt:     now,
+		Status:         mongodbTypes.UsageEventPending,
+		NextAttemptAt:  now,
+		History: []mongodbTypes.UsageEventStatusChange{
+			{Status: mongodbTypes.UsageEventPending, At: now},
+		},
+	}
+
+	created, err := mongodb.CreateUsageEvent(event)
+	if err != nil {
+		return mongodbTypes.UsageEvent{}, err
+	}
+	if !created {
+		existing, err := mongodb.GetUsageEvent(record.OrganizationID, record.IdempotencyKey)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return mongodbTypes.UsageEvent{}, err
+		}
+		return existing, ErrDuplicate
+	}
+
+	return event, nil
+}